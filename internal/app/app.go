@@ -11,19 +11,22 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"net/http"
 	"strconv"
 	"time"
 
-	"robin2/internal/cache"
 	"robin2/internal/config"
 	"robin2/internal/format"
 	"robin2/internal/logger"
 	"robin2/internal/middleware"
 	"robin2/internal/pool"
+	"robin2/internal/prefetch"
+	"robin2/internal/scheduler"
 	"robin2/internal/store"
+	"robin2/internal/templrepo"
 	"robin2/internal/utils"
 
 	"github.com/joho/godotenv"
@@ -39,8 +42,15 @@ type App struct {
 	workDir       string
 	opCount       int64
 	config        config.Config
-	cache         cache.Cache
-	store         store.Store
+	backendMu     sync.RWMutex
+	switchMu      sync.Mutex
+	active        *backend
+	health        *store.HealthMonitor
+	prefetch      *prefetch.Prefetcher
+	hot           *prefetch.Tracker
+	templMu       sync.RWMutex
+	templates     templrepo.Repo
+	scheduler     *scheduler.Scheduler
 	template      *template.Template
 	formatterPool *format.FormatterPool
 	httpPool      *pool.WorkerPool
@@ -69,6 +79,7 @@ func NewApp() *App {
 	app.formatterPool = format.NewFormatterPool(10)
 	// logger := log.New(os.Stdout, "WorkerPool: ", log.Ldate|log.Ltime|log.Lmicroseconds)
 	app.httpPool = pool.NewWorkerPool(1000, nil)
+	app.hot = prefetch.NewTracker()
 	return &app
 }
 
@@ -114,25 +125,55 @@ func (a *App) Run() {
 }
 
 func (a *App) initDatabase() error {
-	// Инициализация кэша
-	cache, err := cache.New(a.config)
-	if err != nil {
-		return fmt.Errorf("failed to initialize cache: %w", err)
+	// Инициализация кэша и хранилища, подключение к БД. При ошибке прежние
+	// хранилище и монитор доступности продолжают работать
+	if err := a.switchBackend(a.config.CurrDBName, a.config.CurrCacheName, false); err != nil {
+		return err
 	}
-	a.cache = cache
 
-	// Инициализация хранилища
-	store, err := store.New(a.config)
-	if err != nil {
-		return fmt.Errorf("failed to initialize store: %w", err)
+	// Фоновый контроль доступности баз данных по новой конфигурации
+	a.switchMu.Lock()
+	health := store.NewHealthMonitor(a.config)
+	if b := a.current(); b != nil {
+		health.Watch(b.dbName, b.store, b.cache)
 	}
-	a.store = store
-
-	// Подключение к БД
-	if err := a.store.Connect("default", a.cache); err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+	if a.health != nil {
+		a.health.Stop()
+	}
+	a.health = health
+	a.health.Start()
+	a.switchMu.Unlock()
+
+	// Предварительная загрузка кэша при запуске и по расписанию. Прежний
+	// загрузчик останавливается в фоне
+	pf := prefetch.New(a.config.Prefetch, a.getStore, a.hot)
+	a.switchMu.Lock()
+	a.backendMu.Lock()
+	oldPrefetch := a.prefetch
+	a.prefetch = pf
+	a.backendMu.Unlock()
+	pf.Start()
+	a.switchMu.Unlock()
+	if oldPrefetch != nil {
+		go oldPrefetch.Stop()
 	}
 
+	// Хранилище шаблонов запросов
+	a.initTemplates()
+
+	// Выполнение шаблонов по расписаниям. Прежний планировщик дорабатывает
+	// выполняемые шаблоны в фоне, не задерживая перезагрузку
+	sched := scheduler.New(a.config.Scheduler, a.templRepo, a.execScheduled)
+	a.switchMu.Lock()
+	a.backendMu.Lock()
+	oldScheduler := a.scheduler
+	a.scheduler = sched
+	a.backendMu.Unlock()
+	sched.Start()
+	a.switchMu.Unlock()
+	if oldScheduler != nil {
+		go oldScheduler.Stop()
+	}
 	return nil
 }
 
@@ -141,35 +182,49 @@ func (a *App) initHTTPHandlers() http.Handler {
 	mux := http.NewServeMux()
 	// Define HTTP request handlers
 	handlers := map[string]func(http.ResponseWriter, *http.Request){
-		"/get/tag/":       a.handleAPIGetTag,
-		"/get/tag/list/":  a.handleAPIGetTagList,
-		"/get/tag/up/":    a.handleAPIGetTagUp,
-		"/get/tag/down/":  a.handleAPIGetTagDown,
-		"/api/info/":      a.handleAPIInfo,
-		"/api/reload/":    a.handleAPIReloadConfig,
-		"/api/log/":       a.handleAPIGetLog,
-		"/api/log/clear/": a.handleAPIClearLog,
-		"/api/status/":    a.handleAPIServerStatus,
-		"/favicon.ico":    a.handleFavicon,
-		"/logs/":          a.handlePageLog,
-		"/data/":          a.handlePageData,
-		"/tags/":          a.handlePageTags,
-		"/docs/":          a.handlePageDocs,
-		"/docs/view/":     a.handlePageDocView,
-		"/":               a.handlePageAny("home", map[string]interface{}{"descr": "Robin"}),
-		"/images/":        a.handleDirectory("images"),
-		"/scripts/":       a.handleDirectory("scripts"),
-		"/css/":           a.handleDirectory("css"),
-		"/api/swagger/":   swagger.Handler(swagger.URL("/api/swagger/doc.json")),
-		"/swagger/":       a.handlePageSwagger,
-		"/templ/list/":    a.handleTemplateList,
-		"/templ/add/":     a.handleTemplateAdd,
-		"/templ/get/":     a.handleTemplateGet,
-		"/templ/edit/":    a.handleTemplateEdit,
-		"/templ/delete/":  a.handleTemplateDelete,
-		"/templ/exec/":    a.handleTemplateExec,
-		"/tag/decode/":    a.handleTagDecode,
-		"/api/v2/get/":    a.handleAPIV2GetTagOnDate,
+		"/get/tag/":              a.handleAPIGetTag,
+		"/get/tag/list/":         a.handleAPIGetTagList,
+		"/get/tag/up/":           a.handleAPIGetTagUp,
+		"/get/tag/down/":         a.handleAPIGetTagDown,
+		"/api/info/":             a.handleAPIInfo,
+		"/api/reload/":           a.handleAPIReloadConfig,
+		"/api/log/":              a.handleAPIGetLog,
+		"/api/log/clear/":        a.handleAPIClearLog,
+		"/api/status/":           a.handleAPIServerStatus,
+		"/api/admin/db/":         a.handleAdminBackendList,
+		"/api/admin/switch/":     a.handleAdminBackendSwitch,
+		"/api/cache/stats/":      a.handleCacheStats,
+		"/api/cache/invalidate/": a.handleCacheInvalidate,
+		"/api/cache/flush/":      a.handleCacheFlush,
+		"/api/cache/prefetch/":   a.handleCachePrefetch,
+		"/favicon.ico":           a.handleFavicon,
+		"/logs/":                 a.handlePageLog,
+		"/data/":                 a.handlePageData,
+		"/tags/":                 a.handlePageTags,
+		"/docs/":                 a.handlePageDocs,
+		"/docs/view/":            a.handlePageDocView,
+		"/":                      a.handlePageAny("home", map[string]interface{}{"descr": "Robin"}),
+		"/images/":               a.handleDirectory("images"),
+		"/scripts/":              a.handleDirectory("scripts"),
+		"/css/":                  a.handleDirectory("css"),
+		"/api/swagger/":          swagger.Handler(swagger.URL("/api/swagger/doc.json")),
+		"/swagger/":              a.handlePageSwagger,
+		"/templ/list/":           a.handleTemplateList,
+		"/templ/add/":            a.handleTemplateAdd,
+		"/templ/get/":            a.handleTemplateGet,
+		"/templ/edit/":           a.handleTemplateEdit,
+		"/templ/delete/":         a.handleTemplateDelete,
+		"/templ/exec/":           a.handleTemplateExec,
+		"/templ/versions/":       a.handleTemplateVersions,
+		"/templ/diff/":           a.handleTemplateDiff,
+		"/templ/rollback/":       a.handleTemplateRollback,
+		"/templ/render/":         a.handleTemplateRender,
+		"/templ/export/":         a.handleTemplateExport,
+		"/templ/import/":         a.handleTemplateImport,
+		"/templ/schedule/":       a.handleTemplateSchedule,
+		"/templ/archive/":        a.handleTemplateArchive,
+		"/tag/decode/":           a.handleTagDecode,
+		"/api/v2/get/":           a.handleAPIV2GetTagOnDate,
 	}
 
 	// Register HTTP request handlers
@@ -193,7 +248,7 @@ func (a *App) initHTTPHandlers() http.Handler {
 		panic(err)
 	}
 
-	return middleware.Log(middleware.Timing(mux))
+	return middleware.Log(middleware.Timing(a.trackBackend(mux)))
 }
 
 func colorizeLogString(input string) template.HTML {
@@ -231,31 +286,45 @@ func formatDataString(input string) template.HTML {
 
 // getDbStatus возвращает статус базы данных.
 //
-// Он извлекает имя текущей базы данных из конфигурации приложения.
+// Он извлекает имя текущей базы данных из активного backend.
 // Затем он создает структуру dbstatus с именем, типом и статусом "green" по умолчанию.
-// Далее он вызывает метод GetStatus из хранилища, чтобы получить версию и время работы базы данных.
+// Если работает монитор доступности, статус, версия и время работы берутся из его последней проверки,
+// иначе вызывается метод GetStatus из хранилища.
 // Если происходит ошибка, статус устанавливается на "red".
-// Наконец, он преобразует строку времени работы в значение типа duration и устанавливает его в структуре dbstatus.
 //
 // Возвращает структуру dbstatus, содержащую статус, имя, тип, версию и время работы базы данных.
 func (a *App) getDbStatus() dbStatus {
-	dbName := a.config.CurrDB.Name
+	b := a.current()
+	// проверяем что store инициализирован
+	if b == nil {
+		return dbStatus{Status: "red", Name: a.config.CurrDBName, Version: "unknown"}
+	}
+
+	dbName := b.dbName
 	dbstatus := dbStatus{
 		Status: "green",
 		Name:   dbName,
-		Type:   a.config.CurrDB.Type,
+		Type:   b.dbType,
 	}
 
-	// проверяем что store инициализирован
-	if a.store == nil {
+	if b.store == nil {
 		dbstatus.Status = "red"
 		dbstatus.Version = "unknown"
 		dbstatus.Uptime = 0
 		return dbstatus
 	}
 
+	if a.health != nil {
+		if h, ok := a.health.Get(dbName); ok && h.Status != "unknown" {
+			dbstatus.Status = utils.ThenIf(h.Status == "green", "green", "red")
+			dbstatus.Version = h.Version
+			dbstatus.Uptime, _ = time.ParseDuration(h.Uptime)
+			return dbstatus
+		}
+	}
+
 	var err error
-	dbstatus.Version, dbstatus.Uptime, err = a.store.GetStatus()
+	dbstatus.Version, dbstatus.Uptime, err = b.store.GetStatus()
 	if err != nil {
 		dbstatus.Status = "red"
 	}
@@ -0,0 +1,161 @@
+package robin
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"robin2/internal/cache"
+	"robin2/internal/logger"
+	"robin2/internal/prefetch"
+	"robin2/internal/scheduler"
+	"robin2/internal/store"
+)
+
+// backend - активная пара хранилища и кэша.
+//
+// Каждый HTTP-запрос удерживает backend, актуальный на момент его начала,
+// поэтому при переключении старые хранилище и кэш закрываются только
+// после завершения начатых на них запросов.
+type backend struct {
+	store     store.Store
+	cache     cache.Cache
+	dbName    string
+	dbType    string
+	cacheName string
+	inflight  sync.WaitGroup
+}
+
+func (a *App) current() *backend {
+	a.backendMu.RLock()
+	defer a.backendMu.RUnlock()
+	return a.active
+}
+
+func (a *App) getStore() store.Store {
+	if b := a.current(); b != nil {
+		return b.store
+	}
+	return nil
+}
+
+func (a *App) getCache() cache.Cache {
+	if b := a.current(); b != nil {
+		return b.cache
+	}
+	return nil
+}
+
+func (a *App) getPrefetch() *prefetch.Prefetcher {
+	a.backendMu.RLock()
+	defer a.backendMu.RUnlock()
+	return a.prefetch
+}
+
+func (a *App) getScheduler() *scheduler.Scheduler {
+	a.backendMu.RLock()
+	defer a.backendMu.RUnlock()
+	return a.scheduler
+}
+
+// acquire отмечает начало запроса на активном backend.
+func (a *App) acquire() *backend {
+	a.backendMu.RLock()
+	defer a.backendMu.RUnlock()
+	if a.active != nil {
+		a.active.inflight.Add(1)
+	}
+	return a.active
+}
+
+// trackBackend удерживает активный backend на время обработки запроса.
+func (a *App) trackBackend(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b := a.acquire(); b != nil {
+			defer b.inflight.Done()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// switchBackend подключается к базе dbName и кэшу cacheName и атомарно делает
+// их активными. Новое подключение проверяется до переключения; при ошибке
+// активный backend не меняется. Если keepCache и имя кэша не изменилось,
+// текущий кэш переиспользуется. Переключения выполняются по одному: иначе
+// одновременные переключения закрыли бы один старый backend дважды.
+func (a *App) switchBackend(dbName, cacheName string, keepCache bool) error {
+	a.switchMu.Lock()
+	defer a.switchMu.Unlock()
+
+	cfg, err := a.config.WithDB(dbName)
+	if err != nil {
+		return fmt.Errorf("failed to select database %s: %w", dbName, err)
+	}
+	cfg, err = cfg.WithCache(cacheName)
+	if err != nil {
+		return fmt.Errorf("failed to select cache %s: %w", cacheName, err)
+	}
+
+	old := a.current()
+	var c cache.Cache
+	newCache := !keepCache || old == nil || old.cacheName != cacheName
+	if newCache {
+		if c, err = cache.New(cfg); err != nil {
+			return fmt.Errorf("failed to initialize cache: %w", err)
+		}
+	} else {
+		c = old.cache
+	}
+
+	st, err := store.New(cfg)
+	if err == nil {
+		err = st.Connect(dbName, c)
+		if err != nil {
+			_ = st.Close()
+		}
+	}
+	if err != nil {
+		if newCache {
+			if derr := c.Disconnect(); derr != nil {
+				logger.Error(derr.Error())
+			}
+		}
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// автомат отключения подключается до того, как хранилище начнёт
+	// обслуживать запросы
+	if a.health != nil {
+		a.health.Watch(dbName, st, c)
+	}
+	nb := &backend{
+		store:     st,
+		cache:     c,
+		dbName:    dbName,
+		dbType:    cfg.CurrDB.Type,
+		cacheName: cacheName,
+	}
+	a.backendMu.Lock()
+	a.active = nb
+	a.backendMu.Unlock()
+
+	if a.health != nil && old != nil && old.dbName != dbName {
+		a.health.Release(old.dbName)
+	}
+	logger.Info(fmt.Sprintf("active database: %s, cache: %s", dbName, cacheName))
+
+	if old != nil {
+		go func() {
+			old.inflight.Wait()
+			if err := old.store.Close(); err != nil {
+				logger.Error(err.Error())
+			}
+			if newCache {
+				if err := old.cache.Disconnect(); err != nil {
+					logger.Error(err.Error())
+				}
+			}
+		}()
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package robin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"robin2/internal/cache"
+	"robin2/internal/config"
+	"robin2/internal/store"
+)
+
+// switchStore - хранилище без базы данных, считающее закрытия.
+type switchStore struct {
+	store.Store
+	mu     *sync.Mutex
+	closes map[*switchStore]int
+	closed chan struct{}
+}
+
+func (s *switchStore) Connect(string, cache.Cache) error {
+	time.Sleep(time.Millisecond)
+	return nil
+}
+
+func (s *switchStore) Close() error {
+	s.mu.Lock()
+	s.closes[s]++
+	s.mu.Unlock()
+	s.closed <- struct{}{}
+	return nil
+}
+
+func TestSwitchBackendConcurrent(t *testing.T) {
+	const n = 8
+	var mu sync.Mutex
+	closes := make(map[*switchStore]int)
+	closed := make(chan struct{}, 2*n)
+	created := 0
+	store.Register("switchtest", func(config.Config) (store.Store, error) {
+		mu.Lock()
+		created++
+		mu.Unlock()
+		return &switchStore{mu: &mu, closes: closes, closed: closed}, nil
+	})
+
+	a := &App{config: config.Config{
+		DB:    []config.Database{{Name: "a", Type: "switchtest"}, {Name: "b", Type: "switchtest"}},
+		Cache: []config.CacheConfig{{Name: "mem", Type: "memory"}},
+	}}
+	if err := a.switchBackend("a", "mem", false); err != nil {
+		t.Fatal(err)
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := a.switchBackend([]string{"a", "b"}[i%2], "mem", true); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// каждое переключение закрывает ровно один предыдущий backend
+	for range n {
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("old backend is not closed")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if created != n+1 {
+		t.Fatalf("created %d stores, want %d", created, n+1)
+	}
+	for s, c := range closes {
+		if c != 1 {
+			t.Fatalf("store closed %d times", c)
+		}
+		if s == a.getStore() {
+			t.Fatal("active store is closed")
+		}
+	}
+}
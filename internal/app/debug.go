@@ -0,0 +1,53 @@
+package robin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"robin2/internal/logger"
+	"robin2/internal/store"
+	"robin2/internal/trace"
+)
+
+// traceStore возвращает хранилище для обработки запроса r. При debug=1
+// хранилище записывает SQL-запросы и обращения к кэшу в возвращаемую трассировку.
+func (a *App) traceStore(r *http.Request) (store.Store, *trace.Trace) {
+	st := a.getStore()
+	if st == nil || r.URL.Query().Get("debug") != "1" {
+		return st, nil
+	}
+	tr := trace.New()
+	return st.WithTrace(tr), tr
+}
+
+// debugResponse оборачивает ответ body в JSON-конверт {"data": ..., "debug": ...}.
+// dbName - база данных запроса, пустое значение - активная база.
+func (a *App) debugResponse(w http.ResponseWriter, body []byte, tr *trace.Trace, dbName string) []byte {
+	return envelope(w, body, map[string]interface{}{"debug": a.traceReport(tr, dbName)})
+}
+
+func (a *App) traceReport(tr *trace.Trace, dbName string) interface{} {
+	if dbName == "" {
+		if b := a.current(); b != nil {
+			dbName = b.dbName
+		}
+	}
+	return tr.Report(dbName)
+}
+
+// envelope оборачивает ответ body в JSON-конверт {"data": ...} с полями fields.
+// Ответ в формате json вкладывается как есть, остальные - строкой.
+func envelope(w http.ResponseWriter, body []byte, fields map[string]interface{}) []byte {
+	var payload interface{} = string(body)
+	if json.Valid(body) {
+		payload = json.RawMessage(body)
+	}
+	fields["data"] = payload
+	res, err := json.Marshal(fields)
+	if err != nil {
+		logger.Error(err.Error())
+		return body
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return res
+}
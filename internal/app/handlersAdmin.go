@@ -0,0 +1,92 @@
+package robin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"robin2/internal/logger"
+)
+
+// @Summary Список баз данных и кэшей
+// @Description Возвращает базы данных и кэши из конфигурации с отметкой активных
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /api/admin/db/ [get]
+func (a *App) handleAdminBackendList(w http.ResponseWriter, r *http.Request) {
+	b := a.current()
+	activeDB, activeCache := "", ""
+	if b != nil {
+		activeDB, activeCache = b.dbName, b.cacheName
+	}
+
+	dbs := make([]map[string]interface{}, 0, len(a.config.DB))
+	for _, db := range a.config.DB {
+		dbs = append(dbs, map[string]interface{}{
+			"name":   db.Name,
+			"type":   db.Type,
+			"host":   db.Host,
+			"hosts":  db.Endpoints(),
+			"active": db.Name == activeDB,
+		})
+	}
+	caches := make([]map[string]interface{}, 0, len(a.config.Cache))
+	for _, c := range a.config.Cache {
+		caches = append(caches, map[string]interface{}{
+			"name":   c.Name,
+			"type":   c.Type,
+			"active": c.Name == activeCache,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"databases": dbs,
+		"caches":    caches,
+	}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// @Summary Переключить базу данных и кэш
+// @Description Подключается к указанным базе данных и кэшу и делает их активными без перезапуска.
+// @Description Запросы, начатые до переключения, завершаются на прежнем подключении.
+// @Tags Admin
+// @Produce plain/text
+// @Success 200 {string} string
+// @Failure 400 {string} string
+// @Failure 502 {string} string
+// @Router /api/admin/switch/ [post]
+// @Param db query string false "Имя базы данных (по умолчанию - текущая)"
+// @Param cache query string false "Имя кэша (по умолчанию - текущий)"
+func (a *App) handleAdminBackendSwitch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b := a.current()
+	dbName := r.URL.Query().Get("db")
+	cacheName := r.URL.Query().Get("cache")
+	if dbName == "" && b != nil {
+		dbName = b.dbName
+	}
+	if cacheName == "" && b != nil {
+		cacheName = b.cacheName
+	}
+	if dbName == "" || cacheName == "" {
+		http.Error(w, "#Error: db or cache is empty", http.StatusBadRequest)
+		return
+	}
+
+	logger.Info(fmt.Sprintf("switching backend to db=%s cache=%s, remote: %s", dbName, cacheName, r.RemoteAddr))
+	if err := a.switchBackend(dbName, cacheName, true); err != nil {
+		logger.Error(err.Error())
+		http.Error(w, "#Error: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if _, err := w.Write([]byte(fmt.Sprintf("Switched to database %s, cache %s", dbName, cacheName))); err != nil {
+		logger.Error(fmt.Sprintf("Error writing response: %v", err))
+	}
+}
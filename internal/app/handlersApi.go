@@ -2,13 +2,16 @@ package robin
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"path/filepath"
 	"robin2/internal/data"
 	"robin2/internal/decode"
+	rerrors "robin2/internal/errors"
 	"robin2/internal/format"
 	"robin2/internal/logger"
+	"robin2/internal/store"
 	"robin2/internal/utils"
 	"strconv"
 	"strings"
@@ -75,10 +78,33 @@ func (a *App) handleAPIGetLog(w http.ResponseWriter, r *http.Request) {
 // @Param count query string false "Количество значений"
 // @Param round query string false "Округление, знаков после запятой (по умолчанию 2)"
 // @Param format query string false "Формат вывода (text - по умолчанию, json, raw)"
+// @Param debug query string false "Режим отладки (1 - вернуть ответ в JSON-конверте с SQL-запросами, обращениями к кэшу и длительностями)"
+// @Failure 422 {string} string "Превышен лимит запроса"
 func (a *App) handleAPIGetTag(w http.ResponseWriter, r *http.Request) {
 	var writer []byte
+	status := http.StatusOK
 
+	st, tr := a.traceStore(r)
+	st, sl := staleStore(st)
+	format := r.URL.Query().Get("format")
 	defer func() {
+		stale := status == http.StatusOK && sl.Stale()
+		if stale {
+			setStaleHeaders(w, sl)
+		}
+		if tr != nil || (stale && format == "json") {
+			fields := make(map[string]interface{})
+			if tr != nil {
+				fields["debug"] = a.traceReport(tr, "")
+			}
+			if stale {
+				fields["stale"] = true
+			}
+			writer = envelope(w, writer, fields)
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
 		if _, err := w.Write(writer); err != nil {
 			logger.Error(fmt.Sprintf("Ошибка при записи ответа: %v", err))
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -95,7 +121,7 @@ func (a *App) handleAPIGetTag(w http.ResponseWriter, r *http.Request) {
 	group := query.Get("group")
 	roundStr := query.Get("round")
 	count := query.Get("count")
-	format := query.Get("format")
+	a.hot.Record(tag)
 
 	//	round := utils.ThenIf(roundStr != "", a.getRound(roundStr), a.config.Round)
 	round := a.config.Round
@@ -105,40 +131,48 @@ func (a *App) handleAPIGetTag(w http.ResponseWriter, r *http.Request) {
 
 	type handlerFunc func() []byte
 
+	// queued выполняет f в пуле; ошибка хранилища возвращается телом "#Error: ..."
+	// и определяет код ответа
+	queued := func(f func() ([]byte, error)) []byte {
+		return a.httpPool.ProcessQueued(func() []byte {
+			b, err := f()
+			if err != nil {
+				status = errorStatus(err, http.StatusOK)
+				return []byte("#Error: " + err.Error())
+			}
+			return b
+		})
+	}
+
 	handlers := map[string]handlerFunc{
 		"tag_date": func() []byte {
 			tags := strings.Split(tag, ",")
 			for i := range tags {
 				tags[i] = strings.TrimSpace(tags[i])
 			}
-			if len(tags) > 1 {
-				return a.httpPool.ProcessQueued(func() []byte {
-					return a.getTagsOnDate(tags, date, format, round)
-				})
-			}
-			return a.httpPool.ProcessQueued(func() []byte {
-				return a.getTagsOnDate(tags, date, format, round)
+			return queued(func() ([]byte, error) {
+				return a.getTagsOnDate(st, tags, date, format, round)
 			})
 			// return a.getTagOnDate(tag, date, format, round)
 		},
 		"tag_from_to_count_group": func() []byte {
-			return a.httpPool.ProcessQueued(func() []byte {
-				return a.getTagFromToByCountWithGroup(tag, from, to, count, group, format, round)
+			return queued(func() ([]byte, error) {
+				return a.getTagFromToByCountWithGroup(st, tag, from, to, count, group, format, round)
 			})
 		},
 		"tag_from_to_count": func() []byte {
-			return a.httpPool.ProcessQueued(func() []byte {
-				return a.getTagByCount(tag, from, to, count, format, round)
+			return queued(func() ([]byte, error) {
+				return a.getTagByCount(st, tag, from, to, count, format, round)
 			})
 		},
 		"tag_from_to_group": func() []byte {
-			return a.httpPool.ProcessQueued(func() []byte {
-				return a.getTagFromToWithGroup(tag, from, to, group, format, round)
+			return queued(func() ([]byte, error) {
+				return a.getTagFromToWithGroup(st, tag, from, to, group, format, round)
 			})
 		},
 		"tag_from_to": func() []byte {
-			return a.httpPool.ProcessQueued(func() []byte {
-				return a.getTagFromTo(tag, from, to, format, round)
+			return queued(func() ([]byte, error) {
+				return a.getTagFromTo(st, tag, from, to, format, round)
 			})
 		},
 	}
@@ -192,9 +226,9 @@ func (a *App) handleAPIGetTagList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", fmt.Sprintf("application/%s", format))
 
 	// Получение списка тегов из хранилища
-	tags, err := a.store.GetTagList(like)
+	tags, err := a.getStore().GetTagList(like)
 	if err != nil {
-		http.Error(w, "Ошибка получения списка тегов: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Ошибка получения списка тегов: "+err.Error(), errorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -254,8 +288,9 @@ func (a *App) handleAPIGetTagDown(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	v, err := a.store.GetDownDates(tag, fromT, toT)
+	v, err := a.getStore().GetDownDates(tag, fromT, toT)
 	if err != nil {
+		w.WriteHeader(errorStatus(err, http.StatusOK))
 		if _, err = w.Write([]byte("#Error: " + err.Error())); err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
@@ -292,7 +327,11 @@ func (a *App) handleAPIGetTagDown(w http.ResponseWriter, r *http.Request) {
 // @Param count query string false "Номер включения после даты начала (0 - первое включение)"
 func (a *App) handleAPIGetTagUp(w http.ResponseWriter, r *http.Request) {
 	writer := []byte("#Error: unknown error")
+	status := http.StatusOK
 	defer func() {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
 		if _, err := w.Write(writer); err != nil {
 			logger.Error(fmt.Sprintf("Ошибка при записи ответа: %v", err))
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -325,8 +364,9 @@ func (a *App) handleAPIGetTagUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	v, err := a.store.GetUpDates(tag, fromT, toT)
+	v, err := a.getStore().GetUpDates(tag, fromT, toT)
 	if err != nil {
+		status = errorStatus(err, http.StatusOK)
 		writer = []byte("#Error: " + err.Error())
 		return
 	}
@@ -436,12 +476,32 @@ func (a *App) handleAPIReloadConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// @Summary Получить статус сервера
+// @Description Возвращает статус текущей базы данных и состояние всех баз из конфигурации
+// @Tags System
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /api/status/ [get]
 func (a *App) handleAPIServerStatus(w http.ResponseWriter, r *http.Request) {
 	dbs := a.getDbStatus()
 	appUptime := time.Since(a.startTime).Round(time.Second).String()
 
+	status := map[string]interface{}{
+		"dbserver":  dbs.Name,
+		"dbversion": dbs.Version,
+		"dbuptime":  dbs.Uptime.String(),
+		"dbstatus":  dbs.Status,
+		"appuptime": appUptime,
+		"dbtype":    dbs.Type,
+	}
+	if a.health != nil {
+		status["databases"] = a.health.Status()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"dbserver": "%s", "dbversion": "%s", "dbuptime": "%s", "dbstatus": "%s", "appuptime": "%s", "dbtype": "%s"}`, dbs.Name, dbs.Version, dbs.Uptime, dbs.Status, appUptime, dbs.Type)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Error(err.Error())
+	}
 }
 
 // func (a *App) getTagOnDate(tag, date, fmt string, round int) []byte {
@@ -449,7 +509,7 @@ func (a *App) handleAPIServerStatus(w http.ResponseWriter, r *http.Request) {
 // 	if err != nil {
 // 		return []byte("#Error: " + err.Error())
 // 	}
-// 	tagValue, err := a.store.GetTagDate(tag, dateTime)
+// 	tagValue, err := a.getStore().GetTagDate(tag, dateTime)
 // 	if err != nil {
 // 		return []byte("#Error: " + err.Error())
 // 	}
@@ -462,15 +522,21 @@ func (a *App) handleAPIServerStatus(w http.ResponseWriter, r *http.Request) {
 // 	return w
 // }
 
-func (a *App) getTagsOnDate(tags []string, date, fmt string, round int) []byte {
+// Функции get* возвращают ошибку только для ошибок хранилища, по которым
+// обработчик выбирает код ответа; остальные ошибки возвращаются в теле ответа.
+
+func (a *App) getTagsOnDate(st store.Store, tags []string, date, fmt string, round int) ([]byte, error) {
 	dateTime, err := utils.ExcelTimeToTime(date, a.config.DateFormats)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return []byte("#Error: " + err.Error()), nil
+	}
+	if err := st.CheckTags(store.KindTagDate, len(tags)); err != nil {
+		return nil, err
 	}
 
 	tagsVal := data.Tags{}
 	for _, tag := range tags {
-		tagValue, err := a.store.GetTagDate(tag, dateTime)
+		tagValue, err := st.GetTagDate(tag, dateTime)
 		if err != nil {
 			continue
 		}
@@ -478,100 +544,100 @@ func (a *App) getTagsOnDate(tags []string, date, fmt string, round int) []byte {
 	}
 	fmtr, err := format.New(fmt)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return []byte("#Error: " + err.Error()), nil
 	}
 	w := fmtr.SetRound(round).Process(tagsVal)
-	return w
+	return w, nil
 }
 
-func (a *App) getTagByCount(tag, from, to, count string, fmt string, round int) []byte {
+func (a *App) getTagByCount(st store.Store, tag, from, to, count string, fmt string, round int) ([]byte, error) {
 	fromT, err := utils.ExcelTimeToTime(from, a.config.DateFormats)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 	toT, err := utils.ExcelTimeToTime(to, a.config.DateFormats)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 	countT, err := strconv.Atoi(count)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
-	tagValue, err := a.store.GetTagCount(tag, fromT, toT, countT)
+	tagValue, err := st.GetTagCount(tag, fromT, toT, countT)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return nil, err
 	}
 
 	fmtr, err := format.New(fmt)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return []byte("#Error: " + err.Error()), nil
 	}
 	w := fmtr.SetRound(round).Process(tagValue)
-	return w
+	return w, nil
 }
 
-func (a *App) getTagFromToByCountWithGroup(tag, from, to, count string, group string, fmt string, round int) []byte {
+func (a *App) getTagFromToByCountWithGroup(st store.Store, tag, from, to, count string, group string, fmt string, round int) ([]byte, error) {
 
 	fromT, err := utils.ExcelTimeToTime(from, a.config.DateFormats)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 
 	toT, err := utils.ExcelTimeToTime(to, a.config.DateFormats)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 
 	countT, err := strconv.Atoi(count)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 
-	tagValue, err := a.store.GetTagCountGroup(tag, fromT, toT, countT, group)
+	tagValue, err := st.GetTagCountGroup(tag, fromT, toT, countT, group)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return nil, err
 	}
 
 	fmtr, err := format.New(fmt)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return []byte("#Error: " + err.Error()), nil
 	}
 	w := fmtr.SetRound(round).Process(tagValue)
-	return w
+	return w, nil
 }
 
-func (a *App) getTagFromTo(tag, from, to string, fmt string, round int) []byte {
+func (a *App) getTagFromTo(st store.Store, tag, from, to string, fmt string, round int) ([]byte, error) {
 	fromT, err := utils.ExcelTimeToTime(from, a.config.DateFormats)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 	toT, err := utils.ExcelTimeToTime(to, a.config.DateFormats)
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
-	tagValue, err := a.store.GetTagFromTo(tag, fromT, toT)
+	tagValue, err := st.GetTagFromTo(tag, fromT, toT)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return nil, err
 	}
 	fmtr, err := format.New(fmt)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return []byte("#Error: " + err.Error()), nil
 	}
 	w := fmtr.SetRound(round).Process(tagValue)
-	return w
+	return w, nil
 }
 
-func (a *App) getTagFromToWithGroup(tag, from, to, group string, fmt string, round int) []byte {
+func (a *App) getTagFromToWithGroup(st store.Store, tag, from, to, group string, fmt string, round int) ([]byte, error) {
 	fromT, err := utils.ExcelTimeToTime(from, a.config.DateFormats)
 
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 
 	toT, err := utils.ExcelTimeToTime(to, a.config.DateFormats)
 
 	if err != nil {
-		return []byte(err.Error())
+		return []byte(err.Error()), nil
 	}
 	tags := strings.Split(tag, ",")
 
@@ -586,29 +652,32 @@ func (a *App) getTagFromToWithGroup(tag, from, to, group string, fmt string, rou
 
 	// проверяем что есть валидные теги
 	if len(validTags) == 0 {
-		return []byte("#Error: no valid tags provided")
+		return []byte("#Error: no valid tags provided"), nil
+	}
+	if err := st.CheckTags(store.KindTagFromToGroup, len(validTags)); err != nil {
+		return nil, err
 	}
 
 	tdv := make(map[string]map[time.Time]float32)
 	for _, tag := range validTags {
 		tdv[tag] = make(map[time.Time]float32)
-		tdv[tag][toT], err = a.store.GetTagFromToGroup(tag, fromT, toT, group)
+		tdv[tag][toT], err = st.GetTagFromToGroup(tag, fromT, toT, group)
 		if err != nil {
-			return []byte("#Error: " + err.Error())
+			return nil, err
 		}
 
 	}
 	var w []byte
 	fmtr, err := format.New(fmt)
 	if err != nil {
-		return []byte("#Error: " + err.Error())
+		return []byte("#Error: " + err.Error()), nil
 	}
 	if len(tdv) == 1 {
 		w = fmtr.SetRound(round).Process(tdv[validTags[0]][toT])
 	} else {
 		w = fmtr.SetRound(round).Process(tdv)
 	}
-	return w
+	return w, nil
 }
 
 // @Summary Получить расшифровку имени тега
@@ -673,6 +742,21 @@ func (a *App) handleTagDecode(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errorStatus возвращает код ответа для ошибки хранилища: превышение лимита
+// запроса - 422, остальные ошибки - def.
+func errorStatus(err error, def int) int {
+	if errors.Is(err, rerrors.ErrLimitExceeded) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, rerrors.ErrTemplateParam) || errors.Is(err, rerrors.ErrInvalidSchedule) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, rerrors.ErrWriteQuery) || errors.Is(err, rerrors.ErrMultiStatement) || errors.Is(err, rerrors.ErrPrivilegeDenied) {
+		return http.StatusForbidden
+	}
+	return def
+}
+
 func (a *App) getRound(roundStr string) int {
 	r, err := strconv.Atoi(roundStr)
 	if err != nil {
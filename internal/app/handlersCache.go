@@ -0,0 +1,156 @@
+package robin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"robin2/internal/cache"
+	"robin2/internal/logger"
+	"robin2/internal/utils"
+	"time"
+)
+
+// activeCache возвращает активный кэш или пишет ошибку 503, если его нет.
+func (a *App) activeCache(w http.ResponseWriter) cache.Cache {
+	c := a.getCache()
+	if c == nil {
+		http.Error(w, "#Error: cache is not initialized", http.StatusServiceUnavailable)
+	}
+	return c
+}
+
+// @Summary Статистика кэша
+// @Description Возвращает попадания, промахи, количество значений и занимаемую память активного кэша.
+// @Description Для двухуровневого кэша в levels - статистика каждого уровня.
+// @Tags Cache
+// @Produce json
+// @Success 200 {object} cache.Stats
+// @Failure 503 {string} string
+// @Router /api/cache/stats/ [get]
+func (a *App) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	c := a.activeCache(w)
+	if c == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Stats()); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// @Summary Инвалидация кэша
+// @Description Удаляет из активного кэша значения тегов, подходящих под маску, за период [from, to).
+// @Description Пустые from и to не ограничивают период. Возвращает количество удалённых значений.
+// @Tags Cache
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Failure 503 {string} string
+// @Router /api/cache/invalidate/ [post]
+// @Param tag query string false "Маска тегов: * - любые символы, ? - один символ (по умолчанию - все)"
+// @Param from query string false "Начало периода"
+// @Param to query string false "Конец периода"
+func (a *App) handleCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var period [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		s := r.URL.Query().Get(name)
+		if s == "" {
+			continue
+		}
+		t, err := utils.ExcelTimeToTime(s, a.config.DateFormats)
+		if err != nil {
+			http.Error(w, "#Error: "+name+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		period[i] = t
+	}
+	c := a.activeCache(w)
+	if c == nil {
+		return
+	}
+
+	mask := r.URL.Query().Get("tag")
+	logger.Info(fmt.Sprintf("invalidating cache: tag=%q from=%s to=%s, remote: %s",
+		mask, r.URL.Query().Get("from"), r.URL.Query().Get("to"), r.RemoteAddr))
+	n, err := c.Invalidate(mask, period[0], period[1])
+	if err != nil {
+		logger.Error(err.Error())
+		http.Error(w, "#Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"removed": n}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// @Summary Очистка кэша
+// @Description Удаляет все значения из активного кэша.
+// @Tags Cache
+// @Produce plain/text
+// @Success 200 {string} string
+// @Failure 500 {string} string
+// @Failure 503 {string} string
+// @Router /api/cache/flush/ [post]
+func (a *App) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := a.activeCache(w)
+	if c == nil {
+		return
+	}
+	logger.Info(fmt.Sprintf("flushing cache, remote: %s", r.RemoteAddr))
+	if err := c.Flush(); err != nil {
+		logger.Error(err.Error())
+		http.Error(w, "#Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := w.Write([]byte("Cache flushed")); err != nil {
+		logger.Error(fmt.Sprintf("Error writing response: %v", err))
+	}
+}
+
+// @Summary Предварительная загрузка кэша
+// @Description GET возвращает результат последней загрузки, время следующей и горячие теги.
+// @Description POST запускает загрузку в фоне, не дожидаясь расписания.
+// @Tags Cache
+// @Produce json
+// @Success 200 {object} prefetch.Status
+// @Success 202 {object} prefetch.Status
+// @Failure 409 {string} string
+// @Failure 503 {string} string
+// @Router /api/cache/prefetch/ [get]
+// @Router /api/cache/prefetch/ [post]
+func (a *App) handleCachePrefetch(w http.ResponseWriter, r *http.Request) {
+	p := a.getPrefetch()
+	if p == nil {
+		http.Error(w, "#Error: prefetch is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !p.Trigger() {
+			http.Error(w, "#Error: prefetch is already running", http.StatusConflict)
+			return
+		}
+		logger.Info(fmt.Sprintf("starting prefetch, remote: %s", r.RemoteAddr))
+		status = http.StatusAccepted
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(p.Status()); err != nil {
+		logger.Error(err.Error())
+	}
+}
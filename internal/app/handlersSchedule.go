@@ -0,0 +1,149 @@
+package robin
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"robin2/internal/data"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+	"robin2/internal/scheduler"
+	"strconv"
+)
+
+// execScheduled выполняет текущую версию шаблона name по расписанию на
+// активном хранилище.
+func (a *App) execScheduled(name string, args map[string]string) (*data.Output, error) {
+	return a.execTemplate(a.getStore(), nil, name, "", args)
+}
+
+// @Summary Расписания шаблонов
+// @Description GET возвращает расписания шаблонов: последний и следующий запуск, результат и ошибку.
+// @Description POST выполняет расписание index шаблона name сейчас, не дожидаясь его времени.
+// @Tags Template
+// @Produce json
+// @Success 200 {array} scheduler.Job
+// @Success 202 {string} string
+// @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Failure 503 {string} string
+// @Router /templ/schedule/ [get]
+// @Router /templ/schedule/ [post]
+// @Param name query string false "Имя шаблона"
+// @Param index query int false "Номер расписания шаблона (для POST, по умолчанию 0)"
+func (a *App) handleTemplateSchedule(w http.ResponseWriter, r *http.Request) {
+	s := a.getScheduler()
+	if s == nil {
+		http.Error(w, "#Error: scheduler is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	switch r.Method {
+	case http.MethodGet:
+		jobs := s.Jobs()
+		if name != "" {
+			filtered := jobs[:0]
+			for _, job := range jobs {
+				if job.Template == name {
+					filtered = append(filtered, job)
+				}
+			}
+			jobs = filtered
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(jobs); err != nil {
+			logger.Error(err.Error())
+		}
+	case http.MethodPost:
+		index := 0
+		if v := r.URL.Query().Get("index"); v != "" {
+			var err error
+			if index, err = strconv.Atoi(v); err != nil {
+				http.Error(w, fmt.Sprintf("#Error: invalid index %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+		if name == "" {
+			http.Error(w, "#Error: name is empty", http.StatusBadRequest)
+			return
+		}
+		if err := s.Run(name, index); err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case stderrors.Is(err, errors.ErrJobRunning):
+				status = http.StatusConflict
+			case stderrors.Is(err, errors.ErrScheduleNotFound), stderrors.Is(err, errors.ErrTemplateNotFound):
+				status = http.StatusNotFound
+			case stderrors.Is(err, errors.ErrSchedulerStopped):
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, "#Error: "+err.Error(), status)
+			return
+		}
+		logger.Info(fmt.Sprintf("running template %s schedule %d, remote: %s", name, index, r.RemoteAddr))
+		w.WriteHeader(http.StatusAccepted)
+		if _, err := w.Write([]byte(fmt.Sprintf("Template %s schedule %d started", name, index))); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// @Summary Архив результатов шаблонов
+// @Description Без file возвращает список сохранённых по расписанию результатов шаблона name (или всех шаблонов), от новых к старым.
+// @Description С file возвращает файл результата.
+// @Tags Template
+// @Produce plain/text
+// @Success 200 {array} scheduler.Result
+// @Failure 404 {string} string
+// @Failure 503 {string} string
+// @Router /templ/archive/ [get]
+// @Param name query string false "Имя шаблона"
+// @Param file query string false "Имя файла результата"
+// @Param format query string false "Формат списка (text - по умолчанию, json)"
+func (a *App) handleTemplateArchive(w http.ResponseWriter, r *http.Request) {
+	s := a.getScheduler()
+	if s == nil {
+		http.Error(w, "#Error: scheduler is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	name, file := r.URL.Query().Get("name"), r.URL.Query().Get("file")
+	if file != "" {
+		path, err := s.Result(name, file)
+		if err != nil {
+			http.Error(w, "#Error: "+err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", scheduler.ContentType(file))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"_"+filepath.Base(path)))
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	results, err := s.Results(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if stderrors.Is(err, errors.ErrResultNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, "#Error: "+err.Error(), status)
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			logger.Error(err.Error())
+		}
+		return
+	}
+	res := fmt.Sprintf("Results of %s (%v)\n\n", name, len(results))
+	for _, item := range results {
+		res += fmt.Sprintf("%s\t%s\t%s\t%d\n", item.Time.Format("2006-01-02 15:04:05"), item.Template, item.File, item.Size)
+	}
+	if _, err := w.Write([]byte(res)); err != nil {
+		logger.Error(fmt.Sprintf("Error writing response: %v", err))
+	}
+}
@@ -1,10 +1,20 @@
 package robin
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"robin2/internal/data"
+	"robin2/internal/errors"
 	"robin2/internal/format"
 	"robin2/internal/logger"
+	"robin2/internal/scheduler"
+	"robin2/internal/store"
+	"robin2/internal/templrepo"
+	"robin2/internal/trace"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -19,7 +29,11 @@ func (a *App) handleTemplateList(w http.ResponseWriter, r *http.Request) {
 	logger.Trace("list templates")
 	like := r.URL.Query().Get("like")
 
-	b, err := a.store.TemplateList(like)
+	repo, err := a.templRepo()
+	var b []templrepo.Template
+	if err == nil {
+		b, err = repo.List(like)
+	}
 	if err != nil {
 		if _, err := w.Write([]byte("#Error: " + err.Error())); err != nil {
 			logger.Error(fmt.Sprintf("Error writing response: %v", err))
@@ -29,8 +43,8 @@ func (a *App) handleTemplateList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res := fmt.Sprintf("Templates like %s (%v)\n\n ", like, len(b))
-	for k, v := range b {
-		res += k + "\n " + v + "\n\n"
+	for _, t := range b {
+		res += t.Name + "\n " + t.Body + "\n\n"
 	}
 	if _, err := w.Write([]byte(res)); err != nil {
 		logger.Error(fmt.Sprintf("Error writing response: %v", err))
@@ -46,6 +60,12 @@ func (a *App) handleTemplateList(w http.ResponseWriter, r *http.Request) {
 // @Router /templ/add [get]
 // @Param name query string true "Имя шаблона"
 // @Param body query string true "Тело шаблона"
+// @Param description query string false "Описание шаблона"
+// @Param params query string false "Объявления параметров: JSON-массив [{\"name\",\"type\":\"date|number|tag|enum|string\",\"required\",\"default\",\"values\",\"description\"}]"
+// @Param schedules query string false "Расписания: JSON-массив [{\"cron\":\"5 8 * * *\",\"args\":{\"from\":\"today-1d\"},\"format\",\"db\"}]"
+// @Param privileged query string false "1 - привилегированный шаблон: может изменять данные и содержать несколько инструкций; только для имён из templates.privileged конфигурации"
+// @Param comment query string false "Комментарий к версии"
+// @Param author query string false "Автор версии (по умолчанию - заголовок X-Robin-User или адрес клиента)"
 func (a *App) handleTemplateAdd(w http.ResponseWriter, r *http.Request) {
 	logger.Trace("adding template")
 	name := r.URL.Query().Get("name")
@@ -54,6 +74,7 @@ func (a *App) handleTemplateAdd(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			logger.Error(fmt.Sprintf("Error writing response: %v", err))
 		}
+		return
 	}
 
 	body := r.URL.Query().Get("body")
@@ -65,12 +86,38 @@ func (a *App) handleTemplateAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.store.TemplateAdd(name, body)
+	if r.URL.Query().Get("privileged") == "1" {
+		if err := a.allowPrivileged(name); err != nil {
+			http.Error(w, "#Error: "+err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	repo, err := a.templRepo()
+	var params []templrepo.Param
+	var schedules []templrepo.Schedule
+	if err == nil && r.URL.Query().Get("params") != "" {
+		params, err = templateParams(r.URL.Query().Get("params"))
+	}
+	if err == nil && r.URL.Query().Get("schedules") != "" {
+		schedules, err = templateSchedules(r.URL.Query().Get("schedules"))
+	}
+	if err == nil {
+		err = repo.Add(templrepo.Template{Name: name, Body: body, Meta: templrepo.Meta{
+			Description: r.URL.Query().Get("description"),
+			Params:      params,
+			Schedules:   schedules,
+			Privileged:  r.URL.Query().Get("privileged") == "1",
+			Author:      author(r),
+			Comment:     r.URL.Query().Get("comment"),
+		}})
+	}
 	if err != nil {
 		_, err := w.Write([]byte("#Error: " + err.Error()))
 		if err != nil {
 			logger.Error(fmt.Sprintf("Error writing response: %v", err))
 		}
+		return
 	}
 
 	_, err = w.Write([]byte(fmt.Sprintf("Template %s added", name)))
@@ -80,12 +127,13 @@ func (a *App) handleTemplateAdd(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Получить тело шаблона
-// @Description Возвращает тело шаблона
+// @Description Возвращает тело шаблона: текущей или указанной версии
 // @Tags Template
 // @Produce plain/text
 // @Success 200 {array} string
 // @Router /templ/get [get]
 // @Param name query string true "Имя шаблона"
+// @Param version query int false "Номер версии (по умолчанию - текущая)"
 func (a *App) handleTemplateGet(w http.ResponseWriter, r *http.Request) {
 	logger.Trace("getting template")
 	name := r.URL.Query().Get("name")
@@ -97,7 +145,7 @@ func (a *App) handleTemplateGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	b, err := a.store.TemplateGet(name)
+	t, err := a.getTemplate(name, r.URL.Query().Get("version"))
 	if err != nil {
 		_, err := w.Write([]byte("#Error: " + err.Error()))
 		if err != nil {
@@ -105,7 +153,7 @@ func (a *App) handleTemplateGet(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	_, err = w.Write([]byte(b))
+	_, err = w.Write([]byte(t.Body))
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error writing response: %v", err))
 	}
@@ -119,6 +167,12 @@ func (a *App) handleTemplateGet(w http.ResponseWriter, r *http.Request) {
 // @Router /templ/edit [get]
 // @Param name query string true "Имя шаблона"
 // @Param body query string true "Тело шаблона"
+// @Param description query string false "Описание шаблона"
+// @Param params query string false "Объявления параметров: JSON-массив [{\"name\",\"type\":\"date|number|tag|enum|string\",\"required\",\"default\",\"values\",\"description\"}]"
+// @Param schedules query string false "Расписания: JSON-массив [{\"cron\":\"5 8 * * *\",\"args\":{\"from\":\"today-1d\"},\"format\",\"db\"}]"
+// @Param privileged query string false "1 - привилегированный шаблон: может изменять данные и содержать несколько инструкций; только для имён из templates.privileged конфигурации"
+// @Param comment query string false "Комментарий к версии"
+// @Param author query string false "Автор версии (по умолчанию - заголовок X-Robin-User или адрес клиента)"
 func (a *App) handleTemplateEdit(w http.ResponseWriter, r *http.Request) {
 	logger.Trace("editing template")
 	name := r.URL.Query().Get("name")
@@ -139,7 +193,43 @@ func (a *App) handleTemplateEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.store.TemplateSet(name, body)
+	if r.URL.Query().Get("privileged") == "1" {
+		if err := a.allowPrivileged(name); err != nil {
+			http.Error(w, "#Error: "+err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	repo, err := a.templRepo()
+	var t templrepo.Template
+	if err == nil {
+		t, err = repo.Get(name)
+	}
+	if err == nil {
+		t.Body = body
+		t.Author, t.Comment = author(r), r.URL.Query().Get("comment")
+		if descr, ok := r.URL.Query()["description"]; ok {
+			t.Description = descr[0]
+		}
+		if params, ok := r.URL.Query()["params"]; ok {
+			t.Params = nil
+			if params[0] != "" {
+				t.Params, err = templateParams(params[0])
+			}
+		}
+		if privileged, ok := r.URL.Query()["privileged"]; ok {
+			t.Privileged = privileged[0] == "1"
+		}
+		if schedules, ok := r.URL.Query()["schedules"]; ok && err == nil {
+			t.Schedules = nil
+			if schedules[0] != "" {
+				t.Schedules, err = templateSchedules(schedules[0])
+			}
+		}
+	}
+	if err == nil {
+		err = repo.Set(t)
+	}
 	if err != nil {
 		_, err = w.Write([]byte("#Error: " + err.Error()))
 		if err != nil {
@@ -172,7 +262,10 @@ func (a *App) handleTemplateDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.store.TemplateDel(name)
+	repo, err := a.templRepo()
+	if err == nil {
+		err = repo.Del(name)
+	}
 	if err != nil {
 		_, err := w.Write([]byte("#Error: " + err.Error()))
 		if err != nil {
@@ -194,14 +287,25 @@ func (a *App) handleTemplateDelete(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {array} string
 // @Router /templ/exec [get]
 // @Param name query string true "Имя шаблона"
+// @Param version query int false "Номер версии (по умолчанию - текущая)"
 // @Param db query string false "Имя базы данных"
 // @Param format query string false "Формат вывода (text - по умолчанию, json, raw)"
-// @Param args query array false "Список аргументов"
+// @Param args query array false "Список аргументов k1=v1,k2=v2"
+// @Param arg.{name} query string false "Аргумент name; значение может содержать запятые"
+// @Param debug query string false "Режим отладки (1 - вернуть ответ в JSON-конверте с SQL-запросами и длительностями)"
 // @x-try-it-out-enabled false
 func (a *App) handleTemplateExec(w http.ResponseWriter, r *http.Request) {
 	logger.Trace("executing template")
 	writer := []byte("#Error: unknown error")
+	status := http.StatusOK
+	st, tr := a.traceStore(r)
 	defer func() {
+		if tr != nil {
+			writer = a.debugResponse(w, writer, tr, r.URL.Query().Get("db"))
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
 		_, err := w.Write(writer)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Error writing response: %v", err))
@@ -214,29 +318,537 @@ func (a *App) handleTemplateExec(w http.ResponseWriter, r *http.Request) {
 	}
 
 	formatStr := r.URL.Query().Get("format")
-	params := make(map[string]string)
-	args := r.URL.Query().Get("args")
-	for _, arg := range strings.Split(args, ",") {
-		kv := strings.Split(arg, "=")
-		if len(kv) != 2 {
-			continue
+	b, err := a.execTemplate(st, tr, name, r.URL.Query().Get("version"), templateArgs(r))
+	if err != nil {
+		status = errorStatus(err, http.StatusOK)
+		writer = []byte("#Error: " + err.Error())
+		return
+	}
+
+	fmtr, err := format.New(formatStr)
+	if err != nil {
+		writer = []byte("#Error: " + err.Error())
+		return
+	}
+	writer = fmtr.Process(b)
+}
+
+// @Summary Проверить шаблон
+// @Description Связывает шаблон с аргументами, не выполняя его: возвращает итоговый SQL-запрос с параметрами
+// @Description и их значениями, запрос со значениями-литералами, базу выполнения и все ошибки
+// @Description (неизвестные подстановки, отсутствующие параметры, ошибки типов, запрос не только на чтение).
+// @Description К базе данных не обращается.
+// @Tags Template
+// @Produce plain/text
+// @Success 200 {object} templateRender
+// @Router /templ/render [get]
+// @Param name query string true "Имя шаблона"
+// @Param version query int false "Номер версии (по умолчанию - текущая)"
+// @Param db query string false "Имя базы данных"
+// @Param args query array false "Список аргументов k1=v1,k2=v2"
+// @Param arg.{name} query string false "Аргумент name; значение может содержать запятые"
+// @Param format query string false "Формат вывода (text - по умолчанию, json)"
+func (a *App) handleTemplateRender(w http.ResponseWriter, r *http.Request) {
+	logger.Trace("rendering template")
+	writer := []byte("#Error: unknown error")
+	defer func() {
+		if _, err := w.Write(writer); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
 		}
-		params[kv[0]] = kv[1]
+	}()
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writer = []byte("#Error: name is empty")
+		return
+	}
+	t, err := a.getTemplate(name, r.URL.Query().Get("version"))
+	if err != nil {
+		writer = []byte("#Error: " + err.Error())
+		return
 	}
 
-	db := r.URL.Query().Get("db")
-	params["db"] = db
+	res := templateRender{Template: t.Name, Version: t.Version, DB: r.URL.Query().Get("db")}
+	var errs []error
+	if res.DB, res.DBType, err = a.templateDB(res.DB); err != nil {
+		errs = append(errs, err)
+	}
+	rendered := templrepo.Render(t, templateArgs(r), a.config.DateFormats, res.DBType)
+	res.Query, res.Args, res.Preview = rendered.Query, rendered.Args, rendered.Preview
+	if !t.Privileged {
+		if err := store.CheckReadOnly(rendered.Preview); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, err := range append(errs, rendered.Errors...) {
+		res.Errors = append(res.Errors, err.Error())
+	}
 
-	b, err := a.store.TemplateExec(name, params)
+	if r.URL.Query().Get("format") == "json" {
+		if writer, err = json.Marshal(res); err != nil {
+			writer = []byte("#Error: " + err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return
+	}
+	var sb strings.Builder
+	for _, e := range res.Errors {
+		sb.WriteString("#Error: " + e + "\n")
+	}
+	fmt.Fprintf(&sb, "-- template %s version %d, database %s (%s)\n%s\n", res.Template, res.Version, res.DB, res.DBType, res.Query)
+	for i, v := range res.Args {
+		fmt.Fprintf(&sb, "-- %d: %v\n", i+1, v)
+	}
+	if len(res.Args) > 0 {
+		sb.WriteString("\n" + res.Preview + "\n")
+	}
+	writer = []byte(sb.String())
+}
+
+// templateRender - ответ /templ/render/.
+type templateRender struct {
+	Template string   `json:"template"`
+	Version  int      `json:"version"`
+	DB       string   `json:"db"`
+	DBType   string   `json:"db_type"`
+	Query    string   `json:"query"`
+	Args     []any    `json:"args"`
+	Preview  string   `json:"preview"`
+	Errors   []string `json:"errors"`
+}
+
+// @Summary Версии шаблона
+// @Description Возвращает версии шаблона: номер, время, автора и комментарий
+// @Tags Template
+// @Produce plain/text
+// @Success 200 {array} string
+// @Router /templ/versions [get]
+// @Param name query string true "Имя шаблона"
+// @Param format query string false "Формат вывода (text - по умолчанию, json - версии с телами)"
+func (a *App) handleTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	logger.Trace("listing template versions")
+	writer := []byte("#Error: unknown error")
+	defer func() {
+		if _, err := w.Write(writer); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
+		}
+	}()
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writer = []byte("#Error: name is empty")
+		return
+	}
+	repo, err := a.templRepo()
+	var versions []templrepo.Template
+	if err == nil {
+		versions, err = repo.Versions(name)
+	}
 	if err != nil {
 		writer = []byte("#Error: " + err.Error())
 		return
 	}
 
-	fmtr, err := format.New(formatStr)
+	if r.URL.Query().Get("format") == "json" {
+		if writer, err = json.Marshal(versions); err != nil {
+			writer = []byte("#Error: " + err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return
+	}
+	res := fmt.Sprintf("Template %s versions (%v)\n\n", name, len(versions))
+	for _, v := range versions {
+		res += fmt.Sprintf("%d\t%s\t%s\t%s\n", v.Version, v.Updated.Format("2006-01-02 15:04:05"), v.Author, v.Comment)
+	}
+	writer = []byte(res)
+}
+
+// @Summary Разница версий шаблона
+// @Description Возвращает построчную разницу тел двух версий шаблона в формате unified diff
+// @Tags Template
+// @Produce plain/text
+// @Success 200 {array} string
+// @Router /templ/diff [get]
+// @Param name query string true "Имя шаблона"
+// @Param from query int true "Номер исходной версии"
+// @Param to query int false "Номер новой версии (по умолчанию - текущая)"
+// @Param context query int false "Число неизменных строк вокруг изменений (по умолчанию 3)"
+func (a *App) handleTemplateDiff(w http.ResponseWriter, r *http.Request) {
+	logger.Trace("diffing template versions")
+	writer := []byte("#Error: unknown error")
+	defer func() {
+		if _, err := w.Write(writer); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
+		}
+	}()
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" || query.Get("from") == "" {
+		writer = []byte("#Error: name or from is empty")
+		return
+	}
+	from, err := a.getTemplate(name, query.Get("from"))
 	if err != nil {
 		writer = []byte("#Error: " + err.Error())
 		return
 	}
-	writer = fmtr.Process(b)
+	to, err := a.getTemplate(name, query.Get("to"))
+	if err != nil {
+		writer = []byte("#Error: " + err.Error())
+		return
+	}
+	context := 3
+	if s := query.Get("context"); s != "" {
+		if context, err = strconv.Atoi(s); err != nil || context < 0 {
+			writer = []byte(fmt.Sprintf("#Error: invalid context %q", s))
+			return
+		}
+	}
+	writer = []byte(templrepo.Diff(from, to, context))
+}
+
+// @Summary Откатить шаблон
+// @Description Делает текущей копию указанной версии шаблона; сохраняется новая версия, история не изменяется
+// @Tags Template
+// @Produce plain/text
+// @Success 200 {array} string
+// @Router /templ/rollback [get]
+// @Param name query string true "Имя шаблона"
+// @Param version query int true "Номер версии"
+// @Param author query string false "Автор версии (по умолчанию - заголовок X-Robin-User или адрес клиента)"
+func (a *App) handleTemplateRollback(w http.ResponseWriter, r *http.Request) {
+	logger.Trace("rolling back template")
+	writer := []byte("#Error: unknown error")
+	defer func() {
+		if _, err := w.Write(writer); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
+		}
+	}()
+	name := r.URL.Query().Get("name")
+	v, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if name == "" || err != nil {
+		writer = []byte("#Error: name or version is empty")
+		return
+	}
+	repo, err := a.templRepo()
+	var t templrepo.Template
+	if err == nil {
+		t, err = templrepo.Rollback(repo, name, v, author(r))
+	}
+	if err != nil {
+		writer = []byte("#Error: " + err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("template %s rolled back to version %d as version %d, remote: %s", name, v, t.Version, r.RemoteAddr))
+	writer = []byte(fmt.Sprintf("Template %s rolled back to version %d (version %d)", name, v, t.Version))
+}
+
+// @Summary Выгрузить шаблоны
+// @Description Возвращает набор шаблонов, подходящих к маске, с описаниями, параметрами и расписаниями
+// @Description для загрузки в другой экземпляр Robin (/templ/import/). История версий не выгружается.
+// @Tags Template
+// @Produce json
+// @Success 200 {object} templrepo.Bundle
+// @Router /templ/export [get]
+// @Param like query string false "Маска имён шаблонов (% - любые символы, _ - один символ)"
+// @Param format query string false "Формат набора (json - по умолчанию, zip - архив файлов {name}.sql и {name}.json)"
+func (a *App) handleTemplateExport(w http.ResponseWriter, r *http.Request) {
+	logger.Trace("exporting templates")
+	repo, err := a.templRepo()
+	var b templrepo.Bundle
+	if err == nil {
+		b, err = templrepo.Export(repo, r.URL.Query().Get("like"))
+	}
+	if err != nil {
+		if _, err := w.Write([]byte("#Error: " + err.Error())); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
+		}
+		return
+	}
+	logger.Info(fmt.Sprintf("exporting %d templates, remote: %s", len(b.Templates), r.RemoteAddr))
+
+	file := "templates_" + b.Exported.Format("20060102T150405")
+	switch f := r.URL.Query().Get("format"); f {
+	case "", "json":
+		raw, err := json.MarshalIndent(b, "", "  ")
+		if err != nil {
+			http.Error(w, "#Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file+".json"))
+		if _, err := w.Write(raw); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
+		}
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file+".zip"))
+		if err := b.WriteZip(w); err != nil {
+			logger.Error(fmt.Sprintf("Error writing response: %v", err))
+		}
+	default:
+		http.Error(w, fmt.Sprintf("#Error: unknown format %q", f), http.StatusBadRequest)
+	}
+}
+
+// maxBundleSize - наибольший размер набора, загружаемого в /templ/import/.
+const maxBundleSize = 32 << 20
+
+// @Summary Загрузить шаблоны
+// @Description Загружает набор шаблонов (JSON или ZIP из /templ/export/) из тела запроса. Шаблон, совпадающий
+// @Description с существующим, не изменяется; если имя занято другим шаблоном, это конфликт, который решает политика:
+// @Description skip - пропустить, overwrite - сохранить новой версией, rename - добавить под свободным именем {name}_N.
+// @Description Привилегированными могут быть только шаблоны с именами из templates.privileged конфигурации.
+// @Description Возвращает действие по каждому шаблону.
+// @Tags Template
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string
+// @Router /templ/import [post]
+// @Param policy query string false "Политика при конфликте (skip - по умолчанию, overwrite, rename)"
+// @Param dry_run query string false "1 - только проверить набор и показать действия, ничего не изменяя"
+// @Param author query string false "Автор версий (по умолчанию - заголовок X-Robin-User или адрес клиента)"
+func (a *App) handleTemplateImport(w http.ResponseWriter, r *http.Request) {
+	logger.Trace("importing templates")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repo, err := a.templRepo()
+	if err != nil {
+		http.Error(w, "#Error: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBundleSize))
+	if err != nil {
+		http.Error(w, "#Error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := templrepo.ReadBundle(raw)
+	if err != nil {
+		http.Error(w, "#Error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := templrepo.ImportOptions{
+		Policy:     r.URL.Query().Get("policy"),
+		DryRun:     r.URL.Query().Get("dry_run") == "1",
+		Privileged: a.config.Templates.Privileged,
+		Author:     author(r),
+	}
+	if opts.Policy == "" {
+		opts.Policy = templrepo.PolicySkip
+	}
+	results, err := templrepo.Import(repo, b, opts)
+	if err != nil {
+		http.Error(w, "#Error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !opts.DryRun {
+		logger.Info(fmt.Sprintf("imported %d templates with policy %s, remote: %s", len(results), opts.Policy, r.RemoteAddr))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": opts.DryRun, "templates": results}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// templRepo возвращает хранилище шаблонов (см. config.Templates).
+func (a *App) templRepo() (templrepo.Repo, error) {
+	a.templMu.RLock()
+	defer a.templMu.RUnlock()
+	if a.templates == nil {
+		return nil, errors.ErrTemplatesUnavailable
+	}
+	return a.templates, nil
+}
+
+// initTemplates открывает хранилище шаблонов из конфигурации вместо текущего.
+func (a *App) initTemplates() {
+	repo, err := templrepo.New(a.config)
+	if err != nil {
+		logger.Error(fmt.Sprintf("templates: %v", err))
+	}
+	a.templMu.Lock()
+	old := a.templates
+	a.templates = repo
+	a.templMu.Unlock()
+	if old != nil {
+		if err := old.Close(); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
+// getTemplate возвращает версию version шаблона name; пустая version - текущую.
+func (a *App) getTemplate(name, version string) (templrepo.Template, error) {
+	repo, err := a.templRepo()
+	if err != nil {
+		return templrepo.Template{}, err
+	}
+	if version == "" {
+		return repo.Get(name)
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return templrepo.Template{}, fmt.Errorf("invalid version %q", version)
+	}
+	return repo.Version(name, v)
+}
+
+// allowPrivileged проверяет, что шаблон name может быть привилегированным:
+// его имя перечислено в templates.privileged конфигурации.
+func (a *App) allowPrivileged(name string) error {
+	if !slices.Contains(a.config.Templates.Privileged, name) {
+		return fmt.Errorf("%w: %s", errors.ErrPrivilegeDenied, name)
+	}
+	return nil
+}
+
+// author возвращает автора изменения шаблона: параметр author, заголовок
+// X-Robin-User или адрес клиента.
+func author(r *http.Request) string {
+	if s := r.URL.Query().Get("author"); s != "" {
+		return s
+	}
+	if s := r.Header.Get("X-Robin-User"); s != "" {
+		return s
+	}
+	return r.RemoteAddr
+}
+
+// execTemplate связывает версию version шаблона name с аргументами params
+// (см. templrepo.Bind) и выполняет его в базе params["db"]; пустое значение -
+// база хранилища st.
+func (a *App) execTemplate(st store.Store, tr *trace.Trace, name, version string, params map[string]string) (*data.Output, error) {
+	t, err := a.getTemplate(name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	dbName, dbType, err := a.templateDB(params[templrepo.ReservedParam])
+	if err != nil {
+		return nil, err
+	}
+	if t.Privileged {
+		if err := a.allowPrivileged(t.Name); err != nil {
+			return nil, err
+		}
+		// аргументы вставлялись бы в непроверяемый запрос текстом
+		if templrepo.Legacy(t) {
+			return nil, fmt.Errorf("%w: privileged template %s must declare its parameters", errors.ErrTemplateParam, t.Name)
+		}
+	}
+	query, args, err := templrepo.Bind(t, params, a.config.DateFormats, dbType)
+	if err != nil {
+		return nil, err
+	}
+	if t.Privileged {
+		logger.Warn(fmt.Sprintf("executing privileged template %s version %d in %s", t.Name, t.Version, dbName))
+	}
+	if b := a.current(); b != nil && dbName == b.dbName {
+		if st == nil {
+			return nil, errors.ErrDbConnectionFailed
+		}
+		return execQuery(st, t, query, args)
+	}
+	cfg, err := a.config.WithDB(dbName)
+	if err != nil {
+		return nil, err
+	}
+	storedb, err := store.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := storedb.Connect(dbName, nil); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := storedb.Close(); err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	if tr != nil {
+		storedb = storedb.WithTrace(tr)
+	}
+	return execQuery(storedb, t, query, args)
+}
+
+// execQuery выполняет запрос шаблона t: привилегированного - без ограничений,
+// остальных - только на чтение.
+func execQuery(st store.Store, t templrepo.Template, query string, args []any) (*data.Output, error) {
+	if t.Privileged {
+		return st.ExecPrivileged(query, args...)
+	}
+	return st.ExecQuery(query, args...)
+}
+
+// templateArgs возвращает аргументы выполнения шаблона: из списка
+// args=k1=v1,k2=v2 и из отдельных параметров arg.k=v. Отдельные параметры
+// имеют приоритет и допускают запятые в значении.
+func templateArgs(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	if args := r.URL.Query().Get("args"); args != "" {
+		for _, arg := range strings.Split(args, ",") {
+			k, v, ok := strings.Cut(arg, "=")
+			if !ok || k == "" {
+				continue
+			}
+			params[k] = v
+		}
+	}
+	for k, v := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(k, "arg."); ok && name != "" {
+			params[name] = v[0]
+		}
+	}
+	if db := r.URL.Query().Get("db"); db != "" {
+		params[templrepo.ReservedParam] = db
+	}
+	return params
+}
+
+// templateParams разбирает объявления параметров шаблона из JSON-массива s.
+func templateParams(s string) ([]templrepo.Param, error) {
+	var params []templrepo.Param
+	if err := json.Unmarshal([]byte(s), &params); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrTemplateParam, err)
+	}
+	return params, nil
+}
+
+// templateSchedules разбирает расписания шаблона из JSON-массива s и
+// проверяет их выражения cron и форматы результата.
+func templateSchedules(s string) ([]templrepo.Schedule, error) {
+	var schedules []templrepo.Schedule
+	if err := json.Unmarshal([]byte(s), &schedules); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInvalidSchedule, err)
+	}
+	for _, sch := range schedules {
+		if _, err := scheduler.ParseCron(sch.Cron); err != nil {
+			return nil, err
+		}
+		if sch.Format != "" {
+			if _, err := format.New(sch.Format); err != nil {
+				return nil, fmt.Errorf("%w: %v", errors.ErrInvalidSchedule, err)
+			}
+		}
+	}
+	return schedules, nil
+}
+
+// templateDB возвращает имя и тип базы выполнения шаблона: dbName или,
+// если оно пустое, текущей базы.
+func (a *App) templateDB(dbName string) (string, string, error) {
+	if b := a.current(); b != nil && (dbName == "" || dbName == b.dbName) {
+		return b.dbName, b.dbType, nil
+	}
+	if dbName == "" {
+		return "", "", errors.ErrDbConnectionFailed
+	}
+	cfg, err := a.config.WithDB(dbName)
+	if err != nil {
+		return dbName, "", fmt.Errorf("%w: %s", err, dbName)
+	}
+	return dbName, cfg.CurrDB.Type, nil
 }
@@ -0,0 +1,39 @@
+package robin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"robin2/internal/config"
+	"robin2/internal/templrepo"
+)
+
+func TestTemplatePrivileged(t *testing.T) {
+	cfg := config.Config{Templates: config.Templates{Dir: t.TempDir(), Privileged: []string{"cleanup"}}}
+	repo, err := templrepo.NewFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{config: cfg, templates: repo}
+
+	add := func(name string) int {
+		q := url.Values{"name": {name}, "body": {"delete from t"}, "privileged": {"1"}}
+		w := httptest.NewRecorder()
+		a.handleTemplateAdd(w, httptest.NewRequest(http.MethodGet, "/templ/add?"+q.Encode(), nil))
+		return w.Code
+	}
+	if code := add("report"); code != http.StatusForbidden {
+		t.Fatalf("privileged=1 for a name outside the allowlist: got %d", code)
+	}
+	if _, err := repo.Get("report"); err == nil {
+		t.Fatal("template must not be added")
+	}
+	if code := add("cleanup"); code != http.StatusOK {
+		t.Fatalf("allowlisted name: got %d", code)
+	}
+	if tm, err := repo.Get("cleanup"); err != nil || !tm.Privileged {
+		t.Fatalf("unexpected template %+v (%v)", tm, err)
+	}
+}
@@ -262,8 +262,9 @@ func (a *App) handlePageData(w http.ResponseWriter, r *http.Request) {
 			to, _ := time.Parse("2006-01-02T15:04", q.Get("to"))
 			countStr, _ := strconv.Atoi(q.Get("count"))
 			count := int(countStr)
-			// tags, err = a.store.GetTagFromTo(q.Get("tag"), from, to)
-			tagsValues, err = a.store.GetTagCountGroup(q.Get("tag"), from, to, count, "avg")
+			// tags, err = a.getStore().GetTagFromTo(q.Get("tag"), from, to)
+			st, _ := staleStore(a.getStore())
+			tagsValues, err = st.GetTagCountGroup(q.Get("tag"), from, to, count, "avg")
 			if err != nil {
 				fmt.Println("Ошибка при чтении ответа:", err)
 				return
@@ -300,7 +301,7 @@ func (a *App) handlePageTags(w http.ResponseWriter, r *http.Request) {
 	like := r.URL.Query().Get("like")
 	if like != "" {
 		if tagsList == nil {
-			tags, err := a.store.GetTagList(like)
+			tags, err := a.getStore().GetTagList(like)
 			if err != nil {
 				_, err := w.Write([]byte("#Error: " + err.Error()))
 				if err != nil {
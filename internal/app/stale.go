@@ -0,0 +1,33 @@
+package robin
+
+import (
+	"net/http"
+
+	"robin2/internal/logger"
+	"robin2/internal/store"
+)
+
+// staleHeader отмечает ответ, собранный из просроченного кэша при
+// недоступной базе данных (stale_if_error).
+const staleHeader = "X-Robin-Stale"
+
+// staleStore возвращает копию хранилища для запроса, которая при недоступной
+// базе отвечает из просроченного кэша, и отметку об этом. Используется
+// запросами значений тегов, которые кэшируются: /get/tag/ и страница /data/.
+// Списки тегов, фронты и шаблоны не кэшируются и при недоступной базе
+// завершаются ошибкой.
+func staleStore(st store.Store) (store.Store, *store.Staleness) {
+	if st == nil {
+		return nil, nil
+	}
+	sl := &store.Staleness{}
+	return st.WithStale(sl), sl
+}
+
+// setStaleHeaders добавляет к ответу заголовки X-Robin-Stale и Warning (RFC 7234).
+func setStaleHeaders(w http.ResponseWriter, sl *store.Staleness) {
+	w.Header().Set(staleHeader, "1")
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.Header().Add("Warning", `111 - "Revalidation Failed"`)
+	logger.Debug("stale response: " + sl.Reason())
+}
@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"hash/fnv"
 	"robin2/internal/config"
 	"robin2/internal/errors"
 	"robin2/internal/logger"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,10 +22,100 @@ func Register(name string, f func(config.Config) (Cache, error)) {
 type Cache interface {
 	Connect() error
 	Disconnect() error
-	Get(tag string, date time.Time) (float32, error)
-	Set(tag string, date time.Time, value float32) error
-	GetStr(tag string, field string) (float32, error)
-	SetStr(tag string, field string, value float32) error
+	Get(key Key, date time.Time) (float32, error)
+	Set(key Key, date time.Time, value float32) error
+	GetAggregate(key AggregateKey) (float32, error)
+	SetAggregate(key AggregateKey, value float32) error
+	// GetMany возвращает значения тега на даты dates за одно обращение к кэшу;
+	// found[i] сообщает, найдено ли значение на dates[i].
+	GetMany(key Key, dates []time.Time) (values []float32, found []bool, err error)
+	// SetMany сохраняет значения тега на даты points за одно обращение к кэшу.
+	SetMany(key Key, points []Point) error
+	// GetAggregates и SetAggregates - пакетные варианты GetAggregate и SetAggregate.
+	GetAggregates(keys []AggregateKey) (values []float32, found []bool, err error)
+	SetAggregates(keys []AggregateKey, values []float32) error
+	// GetSeries возвращает известные кэшу значения тега за [from, to)
+	// и интервалы, которые кэш не покрывает и которые нужно запросить из базы.
+	GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error)
+	// SetSeries сохраняет значения тега за [from, to) и отмечает интервал загруженным.
+	SetSeries(key Key, from, to time.Time, points []Point) error
+	// Stats возвращает количество попаданий, промахов, значений и занятый объём.
+	Stats() Stats
+	// Invalidate удаляет значения тегов, подходящих под маску mask (* и ?),
+	// за период [from, to); нулевые границы не ограничивают период.
+	// Возвращает количество удалённых значений.
+	Invalidate(mask string, from, to time.Time) (int, error)
+	// Flush удаляет все значения кэша.
+	Flush() error
+	// Stale возвращает представление кэша для ответа при недоступной базе
+	// данных: чтение через него отдаёт и просроченные значения, которые ещё
+	// хранятся в течение stale_grace. Запись работает как обычно.
+	Stale() Cache
+}
+
+// Виды значений в кэше. Значения разных видов одного тега хранятся
+// раздельно. Вид значений на дату дополняется отпечатком запроса, которым
+// они получены (см. PointKind): точное и интерполированное значение на одну
+// дату не совпадают.
+const (
+	KindPoint     = "point"  // значение на дату
+	KindSeries    = "series" // сырые значения за период
+	KindAggregate = "agg"    // агрегат за период
+)
+
+// PointKind возвращает вид значений на дату, полученных запросом query:
+// после изменения запроса в конфигурации прежние значения не читаются.
+func PointKind(query string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(query))
+	return KindPoint + ":" + strconv.FormatUint(uint64(h.Sum32()), 16)
+}
+
+// Key - пространство имён значений тега в кэше: база данных, из которой
+// получены значения, и вид запроса. Значения хранятся без округления -
+// округление применяется при выдаче ответа, поэтому от него ключ не зависит.
+type Key struct {
+	Database string `json:"database"`
+	Kind     string `json:"kind"`
+	Tag      string `json:"tag"`
+}
+
+func (k Key) String() string {
+	return k.Database + "|" + k.Kind + "|" + k.Tag
+}
+
+// ParseKey разбирает строку, полученную из Key.String.
+func ParseKey(s string) (Key, bool) {
+	parts := strings.SplitN(s, "|", 3)
+	if len(parts) != 3 {
+		return Key{}, false
+	}
+	return Key{Database: parts[0], Kind: parts[1], Tag: parts[2]}, true
+}
+
+// AggregateKey - ключ агрегированного значения тега (avg, sum, count и т.д.)
+// за период from-to в базе данных Database.
+type AggregateKey struct {
+	Database string
+	Tag      string
+	From     time.Time
+	To       time.Time
+	Group    string
+}
+
+// Key возвращает пространство имён агрегатов тега.
+func (k AggregateKey) Key() Key {
+	return Key{Database: k.Database, Kind: KindAggregate, Tag: k.Tag}
+}
+
+// Field возвращает ключ агрегата внутри пространства имён Key -
+// для хранилищ, группирующих значения по ключу тега.
+func (k AggregateKey) Field() string {
+	return k.From.Format("2006-01-02 15:04:05") + "|" + k.To.Format("2006-01-02 15:04:05") + "|" + k.Group
+}
+
+func (k AggregateKey) String() string {
+	return k.Key().String() + "|" + k.Field()
 }
 
 func New(cfg config.Config) (Cache, error) {
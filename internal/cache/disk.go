@@ -0,0 +1,853 @@
+package cache
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"robin2/internal/config"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("disk", NewDisk)
+}
+
+const (
+	defaultDiskDir = "cache"
+	diskIndexFile  = "index.json"
+	diskSegmentExt = ".json.gz"
+	diskDayLayout  = "2006-01-02"
+	diskDay        = 24 * time.Hour
+	// diskLockStripes - число блокировок сегментов
+	diskLockStripes = 64
+)
+
+// Disk - кэш на локальном диске, который сохраняется между перезапусками
+// сервиса. Значения хранятся сжатыми сегментами - по файлу на тег и сутки
+// (UTC) в каталоге dir. Индекс сегментов (тег, сутки, размер, время записи
+// и последнего обращения) хранится в index.json; по нему применяются время
+// жизни из CacheConfig и ограничение max_bytes с вытеснением давно не
+// использованных сегментов. Просроченные сегменты хранятся ещё stale_grace
+// для чтения через Stale.
+//
+// mu защищает только индекс: сегмент читается, изменяется и сжимается под
+// своей блокировкой (см. segLock), поэтому запись одного сегмента не
+// задерживает обращения к остальным.
+type Disk struct {
+	mu       sync.Mutex
+	locks    [diskLockStripes]sync.Mutex
+	dir      string
+	config   config.Config
+	ttl      time.Duration
+	grace    time.Duration
+	maxBytes int64
+	cleanup  time.Duration
+	index    map[string]*diskEntry
+	bytes    int64
+	dirty    bool
+	stop     chan struct{}
+	stats    counters
+}
+
+// diskEntry - запись индекса о сегменте; ключ индекса - путь сегмента
+// относительно dir. Until - конец данных сегмента (см. diskSegment.until),
+// Series - сжатие его блока серии.
+type diskEntry struct {
+	Key      Key            `json:"key"`
+	Day      string         `json:"day"`
+	Until    time.Time      `json:"until,omitempty"`
+	Series   *EncodingStats `json:"series,omitempty"`
+	Size     int64          `json:"size"`
+	Updated  time.Time      `json:"updated"`
+	Accessed time.Time      `json:"accessed"`
+}
+
+// diskSegment - значения ключа тега за сутки: значения на даты (ключ - время
+// в наносекундах), агрегаты, начинающиеся в эти сутки, и часть серии.
+// Точки серии хранятся в файле блоком Series, сжатым encodeSeries;
+// Legacy - точки в прежнем формате, читаются для совместимости.
+type diskSegment struct {
+	Key        Key                      `json:"key"`
+	Day        string                   `json:"day"`
+	Values     map[int64]float32        `json:"values,omitempty"`
+	Aggregates map[string]diskAggregate `json:"aggregates,omitempty"`
+	Series     []byte                   `json:"series,omitempty"`
+	Legacy     []Point                  `json:"points,omitempty"`
+	Coverage   []Interval               `json:"coverage,omitempty"`
+	Points     []Point                  `json:"-"`
+}
+
+type diskAggregate struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Value float32   `json:"value"`
+}
+
+// until возвращает конец данных сегмента суток d: конец суток или конец
+// самого длинного из начинающихся в них агрегатов.
+func (s *diskSegment) until(d time.Time) time.Time {
+	end := d.Add(diskDay)
+	for _, a := range s.Aggregates {
+		if a.To.After(end) {
+			end = a.To
+		}
+	}
+	return end
+}
+
+// encoding возвращает сжатие блока серии сжатого сегмента или nil.
+func (s *diskSegment) encoding() *EncodingStats {
+	var e EncodingStats
+	if len(s.Series) > 0 {
+		e.add(s.Points, len(s.Series))
+	}
+	return e.result()
+}
+
+func (s *diskSegment) empty() bool {
+	return len(s.Values) == 0 && len(s.Aggregates) == 0 && len(s.Points) == 0 && len(s.Coverage) == 0
+}
+
+// decode распаковывает точки серии прочитанного сегмента.
+func (s *diskSegment) decode() error {
+	s.Points, s.Legacy = s.Legacy, nil
+	if len(s.Series) == 0 {
+		return nil
+	}
+	points, err := decodeSeries(s.Series)
+	if err != nil {
+		return err
+	}
+	s.Points, s.Series = points, nil
+	return nil
+}
+
+// encode сжимает точки серии перед записью сегмента.
+func (s *diskSegment) encode() {
+	s.Series, s.Legacy = nil, nil
+	if len(s.Points) > 0 {
+		s.Series = encodeSeries(s.Points)
+	}
+}
+
+func NewDisk(cfg config.Config) (Cache, error) {
+	cc := cfg.CurrCache
+	t := &Disk{
+		dir:      cc.Dir,
+		config:   cfg,
+		ttl:      cc.Expiration(),
+		grace:    cc.Grace(),
+		maxBytes: cc.MaxBytes,
+		cleanup:  defaultMemoryCleanup,
+		index:    make(map[string]*diskEntry),
+	}
+	if t.dir == "" {
+		t.dir = defaultDiskDir
+	}
+	if cc.CleanupInterval > 0 {
+		t.cleanup = time.Duration(cc.CleanupInterval) * time.Second
+	}
+	err := t.Connect()
+	if err != nil {
+		logger.Error(err.Error())
+		return t, err
+	}
+	logger.Trace("NewDiskCache")
+	return t, nil
+}
+
+// Connect загружает индекс, сверяет его с файлами каталога и запускает
+// фоновое удаление просроченных сегментов и сохранение индекса.
+func (c *Disk) Connect() error {
+	logger.Info(fmt.Sprintf("cache connecting to disk %s", c.dir))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	if err := c.loadIndex(); err != nil {
+		return err
+	}
+	c.removeExpired(time.Now())
+	c.evict()
+	c.stop = make(chan struct{})
+	go c.janitor(c.stop)
+	return nil
+}
+
+// Disconnect останавливает фоновую очистку и сохраняет индекс.
+func (c *Disk) Disconnect() error {
+	logger.Trace("cache disconnecting from disk")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return c.saveIndex()
+}
+
+func (c *Disk) janitor(stop chan struct{}) {
+	ticker := time.NewTicker(c.cleanup)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			c.removeExpired(now)
+			if err := c.saveIndex(); err != nil {
+				logger.Error(err.Error())
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *Disk) Get(key Key, date time.Time) (float32, error) {
+	return c.get(key, date, false)
+}
+
+func (c *Disk) get(key Key, date time.Time, stale bool) (float32, error) {
+	var v float32
+	var ok bool
+	err := c.read(key, dayOf(date), stale, func(seg *diskSegment) {
+		v, ok = seg.Values[date.UnixNano()]
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.stats.hit(ok)
+	if !ok {
+		return 0, errors.ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (c *Disk) Set(key Key, date time.Time, value float32) error {
+	return c.SetMany(key, []Point{{Date: date, Value: value}})
+}
+
+func (c *Disk) GetAggregate(key AggregateKey) (float32, error) {
+	return c.getAggregate(key, false)
+}
+
+func (c *Disk) getAggregate(key AggregateKey, stale bool) (float32, error) {
+	values, found, err := c.getAggregates([]AggregateKey{key}, stale)
+	if err != nil {
+		return 0, err
+	}
+	if !found[0] {
+		return 0, errors.ErrKeyNotFound
+	}
+	return values[0], nil
+}
+
+func (c *Disk) SetAggregate(key AggregateKey, value float32) error {
+	return c.SetAggregates([]AggregateKey{key}, []float32{value})
+}
+
+// GetMany читает каждый сегмент суток один раз.
+func (c *Disk) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	return c.getMany(key, dates, false)
+}
+
+func (c *Disk) getMany(key Key, dates []time.Time, stale bool) ([]float32, []bool, error) {
+	values, found := make([]float32, len(dates)), make([]bool, len(dates))
+	days := make(map[time.Time][]int)
+	for i, d := range dates {
+		days[dayOf(d)] = append(days[dayOf(d)], i)
+	}
+	for d, idx := range days {
+		err := c.read(key, d, stale, func(seg *diskSegment) {
+			for _, i := range idx {
+				values[i], found[i] = seg.Values[dates[i].UnixNano()]
+				c.stats.hit(found[i])
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return values, found, nil
+}
+
+// SetMany записывает каждый сегмент суток один раз.
+func (c *Disk) SetMany(key Key, points []Point) error {
+	days := make(map[time.Time][]Point)
+	for _, p := range points {
+		days[dayOf(p.Date)] = append(days[dayOf(p.Date)], p)
+	}
+	for d, pts := range days {
+		err := c.update(key, d, func(seg *diskSegment) {
+			if seg.Values == nil {
+				seg.Values = make(map[int64]float32)
+			}
+			for _, p := range pts {
+				seg.Values[p.Date.UnixNano()] = p.Value
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diskSegmentID - ключ тега и сутки сегмента.
+type diskSegmentID struct {
+	key Key
+	day time.Time
+}
+
+// aggregateSegments группирует номера агрегатов keys по сегментам, в которых
+// они хранятся: сутки начала агрегата.
+func aggregateSegments(keys []AggregateKey) map[diskSegmentID][]int {
+	segs := make(map[diskSegmentID][]int)
+	for i, k := range keys {
+		id := diskSegmentID{key: k.Key(), day: dayOf(k.From)}
+		segs[id] = append(segs[id], i)
+	}
+	return segs
+}
+
+func (c *Disk) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
+	return c.getAggregates(keys, false)
+}
+
+func (c *Disk) getAggregates(keys []AggregateKey, stale bool) ([]float32, []bool, error) {
+	values, found := make([]float32, len(keys)), make([]bool, len(keys))
+	for id, idx := range aggregateSegments(keys) {
+		err := c.read(id.key, id.day, stale, func(seg *diskSegment) {
+			for _, i := range idx {
+				var a diskAggregate
+				a, found[i] = seg.Aggregates[keys[i].Field()]
+				values[i] = a.Value
+				c.stats.hit(found[i])
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return values, found, nil
+}
+
+func (c *Disk) SetAggregates(keys []AggregateKey, values []float32) error {
+	for id, idx := range aggregateSegments(keys) {
+		err := c.update(id.key, id.day, func(seg *diskSegment) {
+			if seg.Aggregates == nil {
+				seg.Aggregates = make(map[string]diskAggregate)
+			}
+			for _, i := range idx {
+				k := keys[i]
+				seg.Aggregates[k.Field()] = diskAggregate{From: k.From, To: k.To, Value: values[i]}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSeries собирает серию из сегментов всех суток интервала.
+func (c *Disk) GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error) {
+	return c.getSeries(key, from, to, false)
+}
+
+func (c *Disk) getSeries(key Key, from, to time.Time, stale bool) ([]Point, []Interval, error) {
+	var points []Point
+	var covered []Interval
+	for d := dayOf(from); d.Before(to); d = d.Add(diskDay) {
+		err := c.read(key, d, stale, func(seg *diskSegment) {
+			sr := series{points: seg.Points, coverage: seg.Coverage}
+			p, _ := sr.get(from, to)
+			for _, pt := range p {
+				points = append(points, Point{Date: pt.Date.In(from.Location()), Value: pt.Value})
+			}
+			covered = append(covered, seg.Coverage...)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	missing := Missing(covered, Interval{From: from, To: to})
+	c.stats.hit(len(missing) == 0)
+	return points, missing, nil
+}
+
+// SetSeries делит интервал по суткам и обновляет серию в сегменте каждых суток.
+func (c *Disk) SetSeries(key Key, from, to time.Time, points []Point) error {
+	for d := dayOf(from); d.Before(to); d = d.Add(diskDay) {
+		lo, hi := from, to
+		if lo.Before(d) {
+			lo = d
+		}
+		if hi.After(d.Add(diskDay)) {
+			hi = d.Add(diskDay)
+		}
+		err := c.update(key, d, func(seg *diskSegment) {
+			sr := series{points: seg.Points, coverage: seg.Coverage}
+			sr.set(lo, hi, points)
+			seg.Points, seg.Coverage = sr.points, sr.coverage
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encoding суммирует сжатие серий всех сегментов индекса. Вызывается под
+// блокировкой.
+func (c *Disk) encoding() *EncodingStats {
+	var e EncodingStats
+	for _, entry := range c.index {
+		if s := entry.Series; s != nil {
+			e.Points += s.Points
+			e.EncodedBytes += s.EncodedBytes
+			e.LegacyBytes += s.LegacyBytes
+		}
+	}
+	return e.result()
+}
+
+// Stats возвращает количество сегментов и их суммарный размер на диске.
+func (c *Disk) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Name:     c.config.CurrCacheName,
+		Type:     "disk",
+		Hits:     c.stats.hits.Load(),
+		Misses:   c.stats.misses.Load(),
+		Entries:  int64(len(c.index)),
+		Bytes:    c.bytes,
+		Encoding: c.encoding(),
+	}
+}
+
+// Invalidate удаляет значения тегов по маске за период. Агрегат хранится в
+// сегменте суток своего начала, но может продолжаться после них, поэтому
+// сегмент проверяется до конца своих данных (Until; для записей индекса без
+// Until - всегда).
+func (c *Disk) Invalidate(mask string, from, to time.Time) (int, error) {
+	match := MatchMask(mask)
+	var ids []diskSegmentID
+	c.mu.Lock()
+	for _, e := range c.index {
+		d, err := time.Parse(diskDayLayout, e.Day)
+		if err != nil || !match(e.Key.Tag) {
+			continue
+		}
+		until := e.Until
+		if until.IsZero() {
+			until = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		if affects(d, until, from, to) {
+			ids = append(ids, diskSegmentID{key: e.Key, day: d})
+		}
+	}
+	c.mu.Unlock()
+
+	n := 0
+	for _, id := range ids {
+		err := c.modify(id.key, id.day, true, func(seg *diskSegment) {
+			for ns := range seg.Values {
+				if t := time.Unix(0, ns); affects(t, t, from, to) {
+					delete(seg.Values, ns)
+					n++
+				}
+			}
+			for f, a := range seg.Aggregates {
+				if affects(a.From, a.To, from, to) {
+					delete(seg.Aggregates, f)
+					n++
+				}
+			}
+			sr := series{points: seg.Points, coverage: seg.Coverage}
+			n += sr.invalidate(from, to)
+			seg.Points, seg.Coverage = sr.points, sr.coverage
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Flush удаляет все сегменты и индекс; прочие файлы каталога не трогает.
+func (c *Disk) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.index {
+		c.remove(path)
+	}
+	c.dirty = false
+	if err := os.Remove(filepath.Join(c.dir, diskIndexFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Stale возвращает представление кэша, читающее и просроченные сегменты.
+func (c *Disk) Stale() Cache {
+	if c.grace <= 0 {
+		return c
+	}
+	return diskStale{c}
+}
+
+// diskStale - см. Disk.Stale.
+type diskStale struct {
+	*Disk
+}
+
+func (c diskStale) Stale() Cache {
+	return c
+}
+
+func (c diskStale) Get(key Key, date time.Time) (float32, error) {
+	return c.get(key, date, true)
+}
+
+func (c diskStale) GetAggregate(key AggregateKey) (float32, error) {
+	return c.getAggregate(key, true)
+}
+
+func (c diskStale) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	return c.getMany(key, dates, true)
+}
+
+func (c diskStale) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
+	return c.getAggregates(keys, true)
+}
+
+func (c diskStale) GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error) {
+	return c.getSeries(key, from, to, true)
+}
+
+// dayOf возвращает начало суток (UTC), в сегменте которых хранится значение на момент t.
+func dayOf(t time.Time) time.Time {
+	return t.UTC().Truncate(diskDay)
+}
+
+// segmentPath возвращает путь сегмента относительно dir: каталог - хэш
+// ключа тега (имена тегов могут содержать любые символы), файл - сутки.
+func segmentPath(key Key, d time.Time) string {
+	h := sha1.Sum([]byte(key.String()))
+	return filepath.Join(hex.EncodeToString(h[:]), d.Format(diskDayLayout)+diskSegmentExt)
+}
+
+// segLock возвращает блокировку сегмента path.
+func (c *Disk) segLock(path string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	return &c.locks[h.Sum32()%diskLockStripes]
+}
+
+// read читает сегмент ключа key за сутки d под его блокировкой и передаёт его f.
+func (c *Disk) read(key Key, d time.Time, stale bool, f func(seg *diskSegment)) error {
+	mu := c.segLock(segmentPath(key, d))
+	mu.Lock()
+	defer mu.Unlock()
+	seg, err := c.load(key, d, stale)
+	if err != nil {
+		return err
+	}
+	f(seg)
+	return nil
+}
+
+// update изменяет сегмент ключа key за сутки d функцией f и записывает его.
+func (c *Disk) update(key Key, d time.Time, f func(seg *diskSegment)) error {
+	return c.modify(key, d, false, f)
+}
+
+func (c *Disk) modify(key Key, d time.Time, stale bool, f func(seg *diskSegment)) error {
+	mu := c.segLock(segmentPath(key, d))
+	mu.Lock()
+	defer mu.Unlock()
+	seg, err := c.load(key, d, stale)
+	if err != nil {
+		return err
+	}
+	f(seg)
+	return c.save(seg)
+}
+
+// load читает сегмент ключа за сутки d. Если сегмента нет или он просрочен,
+// возвращает пустой сегмент; если stale - просроченный, но ещё хранящийся
+// сегмент читается. Вызывается под блокировкой сегмента.
+func (c *Disk) load(key Key, d time.Time, stale bool) (*diskSegment, error) {
+	seg := &diskSegment{Key: key, Day: d.Format(diskDayLayout)}
+	path := segmentPath(key, d)
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.index[path]
+	if ok && c.gone(e, now) {
+		c.remove(path)
+		ok = false
+	}
+	ok = ok && (stale || !c.expired(e, now))
+	c.mu.Unlock()
+	if !ok {
+		return seg, nil
+	}
+
+	err := readSegment(filepath.Join(c.dir, path), seg)
+	if err == nil {
+		err = seg.decode()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if os.IsNotExist(err) {
+		// сегмент вытеснен после чтения индекса
+		return &diskSegment{Key: key, Day: seg.Day}, nil
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("disk cache: segment %s: %v", path, err))
+		c.remove(path)
+		return &diskSegment{Key: key, Day: seg.Day}, nil
+	}
+	if e, ok := c.index[path]; ok {
+		e.Accessed = now
+		c.dirty = true
+	}
+	return seg, nil
+}
+
+// save сжимает сегмент во временный файл, затем под блокировкой индекса
+// заменяет им сегмент и обновляет индекс; пустой сегмент удаляется. После
+// записи вытесняются сегменты сверх max_bytes. Вызывается под блокировкой
+// сегмента.
+func (c *Disk) save(seg *diskSegment) error {
+	d, err := time.Parse(diskDayLayout, seg.Day)
+	if err != nil {
+		return err
+	}
+	path := segmentPath(seg.Key, d)
+	if seg.empty() {
+		c.mu.Lock()
+		c.remove(path)
+		c.mu.Unlock()
+		return nil
+	}
+	full := filepath.Join(c.dir, path)
+	seg.encode()
+	tmp, size, err := writeSegment(full, seg)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp, full); err != nil {
+		return err
+	}
+	now := time.Now()
+	if e, ok := c.index[path]; ok {
+		c.bytes -= e.Size
+	}
+	c.index[path] = &diskEntry{Key: seg.Key, Day: seg.Day, Until: seg.until(d), Series: seg.encoding(), Size: size, Updated: now, Accessed: now}
+	c.bytes += size
+	c.dirty = true
+	c.evict()
+	return nil
+}
+
+// remove удаляет файл сегмента и его запись в индексе.
+func (c *Disk) remove(path string) {
+	if e, ok := c.index[path]; ok {
+		c.bytes -= e.Size
+		delete(c.index, path)
+		c.dirty = true
+	}
+	full := filepath.Join(c.dir, path)
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		logger.Error(err.Error())
+	}
+	// каталог тега удаляется, только если он пуст
+	_ = os.Remove(filepath.Dir(full))
+}
+
+func (c *Disk) expired(e *diskEntry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(e.Updated) > c.ttl
+}
+
+// gone сообщает, что сегмент просрочен и больше не хранится для чтения через Stale.
+func (c *Disk) gone(e *diskEntry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(e.Updated) > c.ttl+c.grace
+}
+
+func (c *Disk) removeExpired(now time.Time) {
+	for path, e := range c.index {
+		if c.gone(e, now) {
+			c.remove(path)
+		}
+	}
+}
+
+// evict удаляет давно не использованные сегменты, пока размер кэша больше
+// max_bytes. Сегменты упорядочиваются по времени использования один раз за
+// вызов.
+func (c *Disk) evict() {
+	if c.maxBytes <= 0 || c.bytes <= c.maxBytes {
+		return
+	}
+	paths := make([]string, 0, len(c.index))
+	for path := range c.index {
+		paths = append(paths, path)
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return c.index[a].Accessed.Compare(c.index[b].Accessed)
+	})
+	for _, path := range paths {
+		if c.bytes <= c.maxBytes {
+			break
+		}
+		c.remove(path)
+	}
+}
+
+// loadIndex читает index.json и сверяет его с файлами каталога: сегменты без
+// записи в индексе (например, после аварийной остановки) добавляются в него,
+// записи без файлов удаляются.
+func (c *Disk) loadIndex() error {
+	index := make(map[string]*diskEntry)
+	raw, err := os.ReadFile(filepath.Join(c.dir, diskIndexFile))
+	if err == nil {
+		if err := json.Unmarshal(raw, &index); err != nil {
+			logger.Error(fmt.Sprintf("disk cache: index is corrupted, rebuilding: %v", err))
+			index = make(map[string]*diskEntry)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	c.index = make(map[string]*diskEntry, len(index))
+	c.bytes = 0
+	err = filepath.WalkDir(c.dir, func(full string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() || !strings.HasSuffix(full, diskSegmentExt) {
+			return nil
+		}
+		path, err := filepath.Rel(c.dir, full)
+		if err != nil {
+			return err
+		}
+		info, err := de.Info()
+		if err != nil {
+			return err
+		}
+		e, ok := index[path]
+		if !ok {
+			var seg diskSegment
+			if err := readSegment(full, &seg); err != nil {
+				logger.Error(fmt.Sprintf("disk cache: segment %s: %v", path, err))
+				return nil
+			}
+			e = &diskEntry{Key: seg.Key, Day: seg.Day, Updated: info.ModTime(), Accessed: info.ModTime()}
+			if d, err := time.Parse(diskDayLayout, seg.Day); err == nil {
+				e.Until = seg.until(d)
+			}
+			if size := len(seg.Series); size > 0 && seg.decode() == nil {
+				var es EncodingStats
+				es.add(seg.Points, size)
+				e.Series = es.result()
+			}
+		}
+		e.Size = info.Size()
+		c.index[path] = e
+		c.bytes += e.Size
+		return nil
+	})
+	c.dirty = len(c.index) != len(index)
+	return err
+}
+
+// saveIndex записывает индекс, если он изменился. Вызывается под блокировкой.
+func (c *Disk) saveIndex() error {
+	if !c.dirty {
+		return nil
+	}
+	raw, err := json.Marshal(c.index)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, diskIndexFile)
+	if err := os.WriteFile(path+".tmp", raw, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+func readSegment(path string, seg *diskSegment) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return json.NewDecoder(zr).Decode(seg)
+}
+
+// writeSegment записывает сегмент во временный файл рядом с path, чтобы при
+// сбое не оставить частично записанный сегмент. Возвращает имя временного
+// файла и его размер; заменяет им сегмент вызывающий.
+func writeSegment(path string, seg *diskSegment) (string, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".segment-*")
+	if os.IsNotExist(err) {
+		// каталог тега ещё не создан или удалён вместе с последним сегментом
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			tmp, err = os.CreateTemp(filepath.Dir(path), ".segment-*")
+		}
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	zw := gzip.NewWriter(tmp)
+	err = json.NewEncoder(zw).Encode(seg)
+	if err == nil {
+		err = zw.Close()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = tmp.Stat()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, err
+	}
+	return tmp.Name(), info.Size(), nil
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+	rerrors "robin2/internal/errors"
+)
+
+func newTestDisk(t *testing.T, cc config.CacheConfig) *Disk {
+	t.Helper()
+	c, err := NewDisk(config.Config{CurrCache: &cc})
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Disconnect() })
+	return c.(*Disk)
+}
+
+func TestDiskRestart(t *testing.T) {
+	dir := t.TempDir()
+	date := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	key := AggregateKey{Database: "db", Tag: "a/b", From: date, To: date.Add(time.Hour), Group: "avg"}
+
+	c := newTestDisk(t, config.CacheConfig{Dir: dir})
+	_ = c.Set(testPoint("a/b"), date, 1)
+	_ = c.SetAggregate(key, 2)
+	_ = c.SetSeries(testSeries("a/b"), date, date.Add(2*time.Hour), []Point{{Date: date, Value: 3}, {Date: date.Add(90 * time.Minute), Value: 4}})
+	if err := c.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+
+	c = newTestDisk(t, config.CacheConfig{Dir: dir})
+	if v, err := c.Get(testPoint("a/b"), date); err != nil || v != 1 {
+		t.Fatalf("value: expected 1, got %v (%v)", v, err)
+	}
+	if v, err := c.GetAggregate(key); err != nil || v != 2 {
+		t.Fatalf("aggregate: expected 2, got %v (%v)", v, err)
+	}
+	points, missing, err := c.GetSeries(testSeries("a/b"), date, date.Add(2*time.Hour))
+	if err != nil || len(points) != 2 || len(missing) != 0 {
+		t.Fatalf("series spanning two days: got %v, missing %v (%v)", points, missing, err)
+	}
+	if st := c.Stats(); st.Entries != 4 || st.Bytes == 0 {
+		t.Fatalf("expected 4 segments (value, aggregate and two days of series), got %+v", st)
+	}
+
+	if n, err := c.Invalidate("a*", date.Add(time.Hour), time.Time{}); err != nil || n != 1 {
+		t.Fatalf("invalidate: expected 1 removed, got %d (%v)", n, err)
+	}
+	if err := c.Flush(); err != nil || len(c.index) != 0 {
+		t.Fatalf("flush: %d segments left (%v)", len(c.index), err)
+	}
+	if _, err := c.Get(testPoint("a/b"), date); !errors.Is(err, rerrors.ErrKeyNotFound) {
+		t.Fatalf("flushed value must miss, got %v", err)
+	}
+}
+
+func TestDiskMaxBytes(t *testing.T) {
+	c := newTestDisk(t, config.CacheConfig{Dir: t.TempDir(), MaxBytes: 1})
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_ = c.Set(testPoint("a"), date, 1)
+	if c.bytes > c.maxBytes || len(c.index) != 0 {
+		t.Fatalf("segments over max_bytes must be evicted, %d bytes in %d segments", c.bytes, len(c.index))
+	}
+
+	// вытесняется давно не использованный сегмент
+	c.maxBytes = 0
+	for _, tag := range []string{"a", "b", "c"} {
+		_ = c.Set(testPoint(tag), date, 1)
+	}
+	paths := slices.Sorted(maps.Keys(c.index))
+	for i, path := range paths {
+		c.index[path].Accessed = date.Add(-time.Duration(i) * time.Hour)
+	}
+	c.mu.Lock()
+	c.maxBytes = c.bytes - 1
+	c.evict()
+	c.mu.Unlock()
+	if _, ok := c.index[paths[2]]; ok || len(c.index) != 2 {
+		t.Fatalf("least recently used segment must be evicted, left %v", slices.Collect(maps.Keys(c.index)))
+	}
+}
+
+func TestDiskInvalidateAggregate(t *testing.T) {
+	c := newTestDisk(t, config.CacheConfig{Dir: t.TempDir()})
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := AggregateKey{Database: "db", Tag: "a", From: from, To: from.Add(9 * diskDay), Group: "avg"}
+	_ = c.SetAggregate(key, 1)
+
+	day := from.Add(4 * diskDay)
+	if n, err := c.Invalidate("a", day, day.Add(diskDay)); err != nil || n != 1 {
+		t.Fatalf("invalidate: expected 1 removed, got %d (%v)", n, err)
+	}
+	if _, err := c.GetAggregate(key); !errors.Is(err, rerrors.ErrKeyNotFound) {
+		t.Fatalf("aggregate covering the invalidated day must miss, got %v", err)
+	}
+}
+
+func TestDiskEncodingStats(t *testing.T) {
+	c := newTestDisk(t, config.CacheConfig{Dir: t.TempDir()})
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []Point{{Date: date, Value: 1}, {Date: date.Add(time.Minute), Value: 2}}
+
+	// перезапись того же блока не увеличивает статистику
+	for range 2 {
+		_ = c.SetSeries(testSeries("a"), date, date.Add(time.Hour), points)
+	}
+	enc := c.Stats().Encoding
+	if enc == nil || enc.Points != 2 || enc.EncodedBytes == 0 || enc.LegacyBytes != legacyPointSize(points[0])+legacyPointSize(points[1]) {
+		t.Fatalf("unexpected encoding stats %+v", enc)
+	}
+}
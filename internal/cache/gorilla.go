@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+
+	"robin2/internal/errors"
+)
+
+// Блоки серий сжимаются по схеме Gorilla (Facebook, 2015): время точки -
+// разностью второго порядка (delta-of-delta) в миллисекундах, значение -
+// XOR с предыдущим значением, из которого пишутся только значащие биты.
+// Для равномерных рядов с медленно меняющимися значениями это 1-2 бита на
+// время и несколько бит на значение вместо 12 байт без сжатия.
+//
+// Формат блока: количество точек (uvarint); время первой точки (64 бита, мс)
+// и её значение (32 бита); далее для каждой точки время и значение:
+//
+//	время:    0                  - delta-of-delta = 0
+//	          10   + 7 бит       - [-63, 64]
+//	          110  + 9 бит       - [-255, 256]
+//	          1110 + 12 бит      - [-2047, 2048]
+//	          1111 + 64 бита     - любое
+//	значение: 0                  - равно предыдущему
+//	          10 + значащие биты - значащие биты XOR в окне предыдущего XOR
+//	          11 + 5 бит ведущих нулей + 5 бит (длина-1) + значащие биты
+//
+// Точность времени - миллисекунды.
+
+// rawPointSize - размер точки без сжатия: время в мс (8 байт) и значение (4 байта).
+const rawPointSize = 12
+
+// seriesBlock - длина блока серии в Redis: серия тега хранится блоками по
+// часу (UTC), чтобы чтение короткого интервала не распаковывало всю серию.
+const seriesBlock = time.Hour
+
+type bitWriter struct {
+	buf  []byte
+	used uint8 // занятые биты последнего байта, 0 - байт заполнен или его нет
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.used == 0 {
+		w.buf = append(w.buf, 0)
+		w.used = 8
+	}
+	w.used--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.used
+	}
+}
+
+// writeBits записывает младшие n бит v, начиная со старшего.
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		n--
+		w.writeBit(v>>uint(n)&1 == 1)
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos int // номер следующего бита
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, errors.ErrInvalidBlock
+	}
+	bit := r.buf[r.pos/8]>>(7-uint(r.pos%8))&1 == 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for ; n > 0; n-- {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+// dodBuckets - диапазоны delta-of-delta: префикс, его длина, число бит значения и смещение.
+var dodBuckets = []struct {
+	prefix uint64
+	plen   int
+	bits   int
+	offset int64
+}{
+	{0b10, 2, 7, 63},
+	{0b110, 3, 9, 255},
+	{0b1110, 4, 12, 2047},
+}
+
+// encodeSeries сжимает точки, отсортированные по времени.
+func encodeSeries(points []Point) []byte {
+	// заголовок занимает целые байты, биты точек начинаются со следующего байта
+	w := &bitWriter{buf: binary.AppendUvarint(nil, uint64(len(points)))}
+	if len(points) == 0 {
+		return w.buf
+	}
+
+	ts := points[0].Date.UnixMilli()
+	val := math.Float32bits(points[0].Value)
+	w.writeBits(uint64(ts), 64)
+	w.writeBits(uint64(val), 32)
+
+	var delta int64
+	lead, trail := -1, 0
+	for _, p := range points[1:] {
+		t := p.Date.UnixMilli()
+		d := t - ts
+		dod := d - delta
+		ts, delta = t, d
+		writeDod(w, dod)
+
+		v := math.Float32bits(p.Value)
+		x := v ^ val
+		val = v
+		if x == 0 {
+			w.writeBit(false)
+			continue
+		}
+		w.writeBit(true)
+		l, tr := bits.LeadingZeros32(x), bits.TrailingZeros32(x)
+		if l > 31 {
+			l = 31
+		}
+		if lead >= 0 && l >= lead && tr >= trail {
+			w.writeBit(false)
+			w.writeBits(uint64(x>>uint(trail)), 32-lead-trail)
+			continue
+		}
+		lead, trail = l, tr
+		sig := 32 - lead - trail
+		w.writeBit(true)
+		w.writeBits(uint64(lead), 5)
+		w.writeBits(uint64(sig-1), 5)
+		w.writeBits(uint64(x>>uint(trail)), sig)
+	}
+	return w.buf
+}
+
+func writeDod(w *bitWriter, dod int64) {
+	if dod == 0 {
+		w.writeBit(false)
+		return
+	}
+	for _, b := range dodBuckets {
+		if dod >= -b.offset && dod <= b.offset+1 {
+			w.writeBits(b.prefix, b.plen)
+			w.writeBits(uint64(dod+b.offset), b.bits)
+			return
+		}
+	}
+	w.writeBits(0b1111, 4)
+	w.writeBits(uint64(dod), 64)
+}
+
+// decodeSeries распаковывает блок, сжатый encodeSeries.
+func decodeSeries(buf []byte) ([]Point, error) {
+	n, k := binary.Uvarint(buf)
+	if k <= 0 {
+		return nil, errors.ErrInvalidBlock
+	}
+	if n > uint64(len(buf))*8 {
+		return nil, fmt.Errorf("%w: %d points", errors.ErrInvalidBlock, n)
+	}
+	points := make([]Point, 0, n)
+	if n == 0 {
+		return points, nil
+	}
+	r := &bitReader{buf: buf[k:]}
+	t, err := r.readBits(64)
+	if err != nil {
+		return nil, err
+	}
+	v, err := r.readBits(32)
+	if err != nil {
+		return nil, err
+	}
+	ts, val := int64(t), uint32(v)
+	points = append(points, Point{Date: time.UnixMilli(ts), Value: math.Float32frombits(val)})
+
+	var delta int64
+	lead, trail := 0, 0
+	for i := uint64(1); i < n; i++ {
+		dod, err := decodeDod(r)
+		if err != nil {
+			return nil, err
+		}
+		delta += dod
+		ts += delta
+
+		bit, err := r.readBit()
+		if err != nil {
+			return nil, err
+		}
+		if bit {
+			if bit, err = r.readBit(); err != nil {
+				return nil, err
+			}
+			if bit {
+				l, err := r.readBits(5)
+				if err != nil {
+					return nil, err
+				}
+				sig, err := r.readBits(5)
+				if err != nil {
+					return nil, err
+				}
+				lead = int(l)
+				trail = 32 - lead - int(sig) - 1
+				if trail < 0 {
+					return nil, errors.ErrInvalidBlock
+				}
+			}
+			x, err := r.readBits(32 - lead - trail)
+			if err != nil {
+				return nil, err
+			}
+			val ^= uint32(x) << uint(trail)
+		}
+		points = append(points, Point{Date: time.UnixMilli(ts), Value: math.Float32frombits(val)})
+	}
+	return points, nil
+}
+
+func decodeDod(r *bitReader) (int64, error) {
+	// количество единиц префикса: 0 - ноль, 1-3 - диапазон dodBuckets, 4 - 64 бита
+	ones := 0
+	for ones < 4 {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		ones++
+	}
+	switch ones {
+	case 0:
+		return 0, nil
+	case 4:
+		v, err := r.readBits(64)
+		return int64(v), err
+	}
+	b := dodBuckets[ones-1]
+	v, err := r.readBits(b.bits)
+	return int64(v) - b.offset, err
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestGorillaRoundTrip(t *testing.T) {
+	start := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+	regular := make([]Point, 3600)
+	irregular := make([]Point, 1000)
+	for i := range regular {
+		regular[i] = Point{Date: start.Add(time.Duration(i) * time.Second), Value: 50 + float32(i/60)*0.5}
+	}
+	at := start
+	for i := range irregular {
+		at = at.Add(time.Duration(rnd.Int63n(int64(3 * time.Hour))).Truncate(time.Millisecond))
+		irregular[i] = Point{Date: at, Value: rnd.Float32()*2000 - 1000}
+	}
+	cases := map[string][]Point{
+		"empty":     {},
+		"single":    {{Date: start, Value: -1}},
+		"regular":   regular,
+		"irregular": irregular,
+		"special": {
+			{Date: start, Value: 0},
+			{Date: start, Value: float32(math.Inf(1))},
+			{Date: start.Add(time.Millisecond), Value: math.MaxFloat32},
+			{Date: start.AddDate(10, 0, 0), Value: -math.SmallestNonzeroFloat32},
+			{Date: start.AddDate(10, 0, 0).Add(time.Second), Value: 1},
+		},
+	}
+	for name, points := range cases {
+		got, err := decodeSeries(encodeSeries(points))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(points) {
+			t.Fatalf("%s: expected %d points, got %d", name, len(points), len(got))
+		}
+		for i := range points {
+			if !got[i].Date.Equal(points[i].Date) || math.Float32bits(got[i].Value) != math.Float32bits(points[i].Value) {
+				t.Fatalf("%s: point %d: expected %v, got %v", name, i, points[i], got[i])
+			}
+		}
+	}
+
+	if size := len(encodeSeries(regular)); size*4 > len(regular)*rawPointSize {
+		t.Fatalf("regular series must compress at least 4x, got %d bytes for %d points", size, len(regular))
+	}
+	if _, err := decodeSeries(encodeSeries(regular)[:100]); err == nil {
+		t.Fatal("truncated block must not decode")
+	}
+}
@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"container/list"
+	"hash/fnv"
 	"robin2/internal/config"
 	"robin2/internal/errors"
 	"robin2/internal/logger"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -12,20 +15,88 @@ func init() {
 	Register("memory", NewMemory)
 }
 
-var MemoryCacheLock = &sync.Mutex{}
+const (
+	defaultMemoryMaxEntries = 1000000
+	defaultMemoryShards     = 16
+	defaultMemoryCleanup    = time.Minute
+	// entryOverhead - оценка накладных расходов на одно значение (элемент списка, map, структура)
+	entryOverhead = 96
+)
+
+// entry - значение кэша. tag, from и to нужны для инвалидации: у значения
+// на дату from == to, у агрегата - его период, у серии не используются.
+type entry struct {
+	key     string
+	tag     string
+	from    time.Time
+	to      time.Time
+	value   float32
+	series  *series
+	expires time.Time
+}
+
+func (e *entry) size() int64 {
+	n := int64(len(e.key) + entryOverhead)
+	if e.series != nil {
+		n += e.series.size()
+	}
+	return n
+}
 
-type Memcache map[string]map[time.Time]float32
+// shard - часть кэша со своей блокировкой и LRU-списком.
+type shard struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+	grace      time.Duration
+}
 
+// Memory - кэш в памяти процесса с ограничением по количеству значений
+// и объёму, вытеснением давно не использованных значений (LRU) и временем
+// жизни из CacheConfig. Просроченные значения хранятся ещё stale_grace для
+// чтения через Stale, затем их удаляет фоновая горутина.
 type Memory struct {
-	// Cache
-	cache  Memcache
-	config config.Config
+	shards  []*shard
+	config  config.Config
+	ttl     time.Duration
+	grace   time.Duration
+	cleanup time.Duration
+	mu      sync.Mutex
+	stop    chan struct{}
+	stats   counters
 }
 
 func NewMemory(cfg config.Config) (Cache, error) {
-	t := Memory{
-		cache:  make(Memcache),
-		config: cfg,
+	cc := cfg.CurrCache
+	n := cc.Shards
+	if n <= 0 {
+		n = defaultMemoryShards
+	}
+	maxEntries := cc.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = defaultMemoryMaxEntries
+	}
+	t := &Memory{
+		shards:  make([]*shard, n),
+		config:  cfg,
+		ttl:     cc.Expiration(),
+		grace:   cc.Grace(),
+		cleanup: defaultMemoryCleanup,
+	}
+	if cc.CleanupInterval > 0 {
+		t.cleanup = time.Duration(cc.CleanupInterval) * time.Second
+	}
+	for i := range t.shards {
+		t.shards[i] = &shard{
+			items:      make(map[string]*list.Element),
+			lru:        list.New(),
+			maxEntries: perShard(maxEntries, n),
+			maxBytes:   int64(perShard(int(cc.MaxBytes), n)),
+			grace:      t.grace,
+		}
 	}
 	err := t.Connect()
 	if err != nil {
@@ -36,43 +107,403 @@ func NewMemory(cfg config.Config) (Cache, error) {
 	return t, nil
 }
 
-func (c Memory) Connect() error {
+// perShard делит лимит между шардами; ноль и отрицательные значения - без ограничения.
+func perShard(max, n int) int {
+	if max <= 0 {
+		return 0
+	}
+	return (max + n - 1) / n
+}
+
+// Connect запускает фоновую очистку просроченных значений.
+func (c *Memory) Connect() error {
 	logger.Trace("cache connecting to memory")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil || c.ttl <= 0 {
+		return nil
+	}
+	c.stop = make(chan struct{})
+	go c.janitor(c.stop)
 	return nil
 }
 
-func (c Memory) Disconnect() error {
+func (c *Memory) Disconnect() error {
 	logger.Trace("cache disconnecting to memory")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
 	return nil
 }
 
-func (c Memory) Get(tag string, date time.Time) (float32, error) {
-	MemoryCacheLock.Lock()
-	defer MemoryCacheLock.Unlock()
-	t, ok := c.cache[tag][date]
-	if !ok {
+func (c *Memory) janitor(stop chan struct{}) {
+	ticker := time.NewTicker(c.cleanup)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, s := range c.shards {
+				s.removeExpired(now)
+			}
+		}
+	}
+}
+
+func (c *Memory) Get(key Key, date time.Time) (float32, error) {
+	return c.get(dateKey(key, date), false)
+}
+
+func (c *Memory) Set(key Key, date time.Time, value float32) error {
+	c.set(dateKey(key, date), key.Tag, date, date, value)
+	return nil
+}
+
+func (c *Memory) GetAggregate(key AggregateKey) (float32, error) {
+	return c.get(aggregateKey(key), false)
+}
+
+func (c *Memory) SetAggregate(key AggregateKey, value float32) error {
+	c.set(aggregateKey(key), key.Tag, key.From, key.To, value)
+	return nil
+}
+
+func (c *Memory) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	return c.getDates(key, dates, false)
+}
+
+func (c *Memory) getDates(key Key, dates []time.Time, stale bool) ([]float32, []bool, error) {
+	keys := make([]string, len(dates))
+	for i, d := range dates {
+		keys[i] = dateKey(key, d)
+	}
+	values, found := c.getMany(keys, stale)
+	return values, found, nil
+}
+
+func (c *Memory) SetMany(key Key, points []Point) error {
+	items := make([]memoryItem, len(points))
+	for i, p := range points {
+		items[i] = memoryItem{key: dateKey(key, p.Date), tag: key.Tag, from: p.Date, to: p.Date, value: p.Value}
+	}
+	c.setMany(items)
+	return nil
+}
+
+func (c *Memory) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
+	return c.getAggregates(keys, false)
+}
+
+func (c *Memory) getAggregates(keys []AggregateKey, stale bool) ([]float32, []bool, error) {
+	skeys := make([]string, len(keys))
+	for i, k := range keys {
+		skeys[i] = aggregateKey(k)
+	}
+	values, found := c.getMany(skeys, stale)
+	return values, found, nil
+}
+
+func (c *Memory) SetAggregates(keys []AggregateKey, values []float32) error {
+	items := make([]memoryItem, len(keys))
+	for i, k := range keys {
+		items[i] = memoryItem{key: aggregateKey(k), tag: k.Tag, from: k.From, to: k.To, value: values[i]}
+	}
+	c.setMany(items)
+	return nil
+}
+
+func (c *Memory) GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error) {
+	return c.getSeries(key, from, to, false)
+}
+
+func (c *Memory) getSeries(key Key, from, to time.Time, stale bool) ([]Point, []Interval, error) {
+	skey := seriesKey(key)
+	s := c.shard(skey)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(skey, stale)
+	if e == nil || e.series == nil {
+		c.stats.hit(false)
+		return nil, []Interval{{From: from, To: to}}, nil
+	}
+	points, missing := e.series.get(from, to)
+	c.stats.hit(len(missing) == 0)
+	return points, missing, nil
+}
+
+func (c *Memory) SetSeries(key Key, from, to time.Time, points []Point) error {
+	c.put(seriesKey(key), func(e *entry) {
+		e.tag = key.Tag
+		if e.series == nil {
+			e.series = &series{}
+		}
+		e.series.set(from, to, points)
+	})
+	return nil
+}
+
+func (c *Memory) Stats() Stats {
+	st := Stats{Name: c.config.CurrCacheName, Type: "memory", Hits: c.stats.hits.Load(), Misses: c.stats.misses.Load()}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		st.Entries += int64(s.lru.Len())
+		st.Bytes += s.bytes
+		s.mu.Unlock()
+	}
+	return st
+}
+
+// Invalidate удаляет значения тегов по маске за период [from, to); из серий
+// вырезается только этот период.
+func (c *Memory) Invalidate(mask string, from, to time.Time) (int, error) {
+	match := MatchMask(mask)
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for el := s.lru.Front(); el != nil; {
+			next := el.Next()
+			e := el.Value.(*entry)
+			switch {
+			case !match(e.tag):
+			case e.series != nil:
+				before := e.size()
+				n += e.series.invalidate(from, to)
+				s.bytes += e.size() - before
+				if e.series.empty() {
+					s.remove(el)
+				}
+			case affects(e.from, e.to, from, to):
+				s.remove(el)
+				n++
+			}
+			el = next
+		}
+		s.mu.Unlock()
+	}
+	return n, nil
+}
+
+func (c *Memory) Flush() error {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = make(map[string]*list.Element)
+		s.lru.Init()
+		s.bytes = 0
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Stale возвращает представление кэша, читающее и просроченные значения.
+func (c *Memory) Stale() Cache {
+	if c.grace <= 0 {
+		return c
+	}
+	return memoryStale{c}
+}
+
+// memoryStale - см. Memory.Stale.
+type memoryStale struct {
+	*Memory
+}
+
+func (c memoryStale) Stale() Cache {
+	return c
+}
+
+func (c memoryStale) Get(key Key, date time.Time) (float32, error) {
+	return c.get(dateKey(key, date), true)
+}
+
+func (c memoryStale) GetAggregate(key AggregateKey) (float32, error) {
+	return c.get(aggregateKey(key), true)
+}
+
+func (c memoryStale) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	return c.getDates(key, dates, true)
+}
+
+func (c memoryStale) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
+	return c.getAggregates(keys, true)
+}
+
+func (c memoryStale) GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error) {
+	return c.getSeries(key, from, to, true)
+}
+
+// Len возвращает количество значений в кэше, включая ещё не удалённые просроченные.
+func (c *Memory) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func dateKey(key Key, date time.Time) string {
+	return key.String() + "|" + strconv.FormatInt(date.UnixNano(), 10)
+}
+
+// aggregateKey и seriesKey не пересекаются с dateKey благодаря префиксам.
+func aggregateKey(key AggregateKey) string {
+	return "agg|" + key.String()
+}
+
+func seriesKey(key Key) string {
+	return "series|" + key.String()
+}
+
+func (c *Memory) shard(key string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+func (c *Memory) get(key string, stale bool) (float32, error) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key, stale)
+	c.stats.hit(e != nil)
+	if e == nil {
 		return 0, errors.ErrKeyNotFound
 	}
-	return t, nil
+	return e.value, nil
+}
+
+func (c *Memory) set(key, tag string, from, to time.Time, value float32) {
+	c.put(key, func(e *entry) {
+		e.tag, e.from, e.to, e.value = tag, from, to, value
+	})
+}
+
+// memoryItem - значение для пакетной записи setMany.
+type memoryItem struct {
+	key   string
+	tag   string
+	from  time.Time
+	to    time.Time
+	value float32
+}
+
+// byShard группирует индексы ключей по шардам, чтобы блокировать каждый шард один раз.
+func (c *Memory) byShard(n int, key func(i int) string) map[*shard][]int {
+	res := make(map[*shard][]int)
+	for i := 0; i < n; i++ {
+		s := c.shard(key(i))
+		res[s] = append(res[s], i)
+	}
+	return res
+}
+
+func (c *Memory) getMany(keys []string, stale bool) ([]float32, []bool) {
+	values := make([]float32, len(keys))
+	found := make([]bool, len(keys))
+	for s, idx := range c.byShard(len(keys), func(i int) string { return keys[i] }) {
+		s.mu.Lock()
+		for _, i := range idx {
+			if e := s.lookup(keys[i], stale); e != nil {
+				values[i], found[i] = e.value, true
+			}
+			c.stats.hit(found[i])
+		}
+		s.mu.Unlock()
+	}
+	return values, found
+}
+
+func (c *Memory) setMany(items []memoryItem) {
+	expires := c.expires()
+	for s, idx := range c.byShard(len(items), func(i int) string { return items[i].key }) {
+		s.mu.Lock()
+		for _, i := range idx {
+			it := items[i]
+			s.put(it.key, expires, func(e *entry) {
+				e.tag, e.from, e.to, e.value = it.tag, it.from, it.to, it.value
+			})
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (c *Memory) expires() time.Time {
+	if c.ttl > 0 {
+		return time.Now().Add(c.ttl)
+	}
+	return time.Time{}
+}
+
+// put создаёт или обновляет значение key функцией update, продлевает
+// его время жизни и вытесняет давно не использованные значения сверх лимитов.
+func (c *Memory) put(key string, update func(e *entry)) {
+	expires := c.expires()
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, expires, update)
 }
 
-func (c Memory) Set(tag string, date time.Time, value float32) error {
-	MemoryCacheLock.Lock()
-	defer MemoryCacheLock.Unlock()
-	if t, ok := c.cache[tag]; ok {
-		t[date] = value
+// put - см. Memory.put. Вызывается под блокировкой шарда.
+func (s *shard) put(key string, expires time.Time, update func(e *entry)) {
+	var e *entry
+	if el, ok := s.items[key]; ok {
+		e = el.Value.(*entry)
+		s.bytes -= e.size()
+		s.lru.MoveToFront(el)
 	} else {
-		c.cache[tag] = make(map[time.Time]float32)
-		c.cache[tag][date] = value
+		e = &entry{key: key}
+		s.items[key] = s.lru.PushFront(e)
+	}
+	update(e)
+	e.expires = expires
+	s.bytes += e.size()
+	for s.lru.Len() > 0 && ((s.maxEntries > 0 && s.lru.Len() > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes)) {
+		s.remove(s.lru.Back())
 	}
-	return nil
 }
 
-func (c Memory) GetStr(tag string, field string) (float32, error) {
-	v, err := c.Get(tag, time.Now())
-	return v, err
+// lookup возвращает непросроченное значение key и отмечает его использование.
+// Если stale, возвращается и просроченное значение, которое ещё хранится в
+// течение grace. Вызывается под блокировкой шарда.
+func (s *shard) lookup(key string, stale bool) *entry {
+	el, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*entry)
+	if now := time.Now(); !e.expires.IsZero() && now.After(e.expires) {
+		if now.After(e.expires.Add(s.grace)) {
+			s.remove(el)
+			return nil
+		}
+		if !stale {
+			return nil
+		}
+	}
+	s.lru.MoveToFront(el)
+	return e
 }
 
-func (c Memory) SetStr(tag string, field string, value float32) error {
-	return c.Set(tag, time.Now(), value)
+func (s *shard) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*entry)
+	delete(s.items, e.key)
+	s.bytes -= e.size()
+}
+
+func (s *shard) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for el := s.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if e := el.Value.(*entry); !e.expires.IsZero() && now.After(e.expires.Add(s.grace)) {
+			s.remove(el)
+		}
+		el = prev
+	}
 }
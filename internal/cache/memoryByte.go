@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"crypto/md5"
 	"fmt"
 	"robin2/internal/config"
 	"robin2/internal/errors"
@@ -17,16 +18,33 @@ var MemoryCacheByteLock = &sync.Mutex{}
 
 type hash [16]byte
 
+func hashOf(key string) hash {
+	return md5.Sum([]byte(key))
+}
+
+// keyMeta - тег и период значения по хэшу ключа, нужны для инвалидации по маске.
+type keyMeta struct {
+	tag  string
+	from time.Time
+	to   time.Time
+}
+
 type MemoryByte struct {
 	// Cache
 	cache  map[hash]float32
+	meta   map[hash]keyMeta
+	series map[Key]*series
 	config config.Config
+	stats  *counters
 }
 
 func NewMemoryByte(cfg config.Config) (Cache, error) {
 	t := MemoryByte{
 		cache:  make(map[hash]float32),
+		meta:   make(map[hash]keyMeta),
+		series: make(map[Key]*series),
 		config: cfg,
+		stats:  &counters{},
 	}
 	logger.Debug("NewMemoryCacheByte")
 	return &t, nil
@@ -63,6 +81,7 @@ func (c MemoryByte) RemoveHash(key hash) error {
 	MemoryCacheByteLock.Lock()
 	defer MemoryCacheByteLock.Unlock()
 	delete(c.cache, key)
+	delete(c.meta, key)
 	return nil
 }
 
@@ -70,32 +89,173 @@ func (c *MemoryByte) RemoveAll() error {
 	MemoryCacheByteLock.Lock()
 	defer MemoryCacheByteLock.Unlock()
 	c.cache = make(map[hash]float32)
+	c.meta = make(map[hash]keyMeta)
+	c.series = make(map[Key]*series)
+	return nil
+}
+
+func (c MemoryByte) Get(key Key, date time.Time) (float32, error) {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	t, ok := c.cache[hashOf(key.String()+"|"+date.Format("2006-01-02 15:04:05"))]
+	c.stats.hit(ok)
+	if !ok {
+		return 0, errors.ErrKeyNotFound
+	}
+	return t, nil
+}
+
+func (c MemoryByte) Set(key Key, date time.Time, value float32) error {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	h := hashOf(key.String() + "|" + date.Format("2006-01-02 15:04:05"))
+	c.cache[h] = value
+	c.meta[h] = keyMeta{tag: key.Tag, from: date, to: date}
 	return nil
 }
 
-func (c MemoryByte) Get(tag string, date time.Time) (float32, error) {
+func (c MemoryByte) GetAggregate(key AggregateKey) (float32, error) {
 	MemoryCacheByteLock.Lock()
 	defer MemoryCacheByteLock.Unlock()
-	t, ok := c.cache[hash([]byte(tag+date.Format("2006-01-02 15:04:05")))]
+	t, ok := c.cache[hashOf("agg|"+key.String())]
+	c.stats.hit(ok)
 	if !ok {
 		return 0, errors.ErrKeyNotFound
 	}
 	return t, nil
 }
 
-func (c MemoryByte) Set(tag string, date time.Time, value float32) error {
+func (c MemoryByte) SetAggregate(key AggregateKey, value float32) error {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	h := hashOf("agg|" + key.String())
+	c.cache[h] = value
+	c.meta[h] = keyMeta{tag: key.Tag, from: key.From, to: key.To}
+	return nil
+}
+
+func (c MemoryByte) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	values := make([]float32, len(dates))
+	found := make([]bool, len(dates))
+	for i, d := range dates {
+		values[i], found[i] = c.cache[hashOf(key.String()+"|"+d.Format("2006-01-02 15:04:05"))]
+		c.stats.hit(found[i])
+	}
+	return values, found, nil
+}
+
+func (c MemoryByte) SetMany(key Key, points []Point) error {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	for _, p := range points {
+		h := hashOf(key.String() + "|" + p.Date.Format("2006-01-02 15:04:05"))
+		c.cache[h] = p.Value
+		c.meta[h] = keyMeta{tag: key.Tag, from: p.Date, to: p.Date}
+	}
+	return nil
+}
+
+func (c MemoryByte) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
 	MemoryCacheByteLock.Lock()
 	defer MemoryCacheByteLock.Unlock()
-	c.cache[hash([]byte(tag+date.Format("2006-01-02 15:04:05")))] = value
+	values := make([]float32, len(keys))
+	found := make([]bool, len(keys))
+	for i, k := range keys {
+		values[i], found[i] = c.cache[hashOf("agg|"+k.String())]
+		c.stats.hit(found[i])
+	}
+	return values, found, nil
+}
+
+func (c MemoryByte) SetAggregates(keys []AggregateKey, values []float32) error {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	for i, k := range keys {
+		h := hashOf("agg|" + k.String())
+		c.cache[h] = values[i]
+		c.meta[h] = keyMeta{tag: k.Tag, from: k.From, to: k.To}
+	}
 	return nil
 }
 
-func (c MemoryByte) GetStr(tag string, field string) (float32, error) {
-	logger.Trace("MemoryCacheByteImpl.GetStr unimplemented")
-	return 0, nil
+func (c MemoryByte) GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error) {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	sr, ok := c.series[key]
+	if !ok {
+		c.stats.hit(false)
+		return nil, []Interval{{From: from, To: to}}, nil
+	}
+	points, missing := sr.get(from, to)
+	c.stats.hit(len(missing) == 0)
+	return points, missing, nil
+}
+
+func (c MemoryByte) SetSeries(key Key, from, to time.Time, points []Point) error {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	sr, ok := c.series[key]
+	if !ok {
+		sr = &series{}
+		c.series[key] = sr
+	}
+	sr.set(from, to, points)
+	return nil
 }
 
-func (c MemoryByte) SetStr(tag string, field string, value float32) error {
-	logger.Trace("MemoryCacheByteImpl.SetStr unimplemented")
+func (c MemoryByte) Stats() Stats {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	st := Stats{
+		Name:    c.config.CurrCacheName,
+		Type:    "memoryByte",
+		Hits:    c.stats.hits.Load(),
+		Misses:  c.stats.misses.Load(),
+		Entries: int64(len(c.cache) + len(c.series)),
+		Bytes:   int64(len(c.cache)) * entryOverhead,
+	}
+	for key, sr := range c.series {
+		st.Bytes += int64(len(key.String())+entryOverhead) + sr.size()
+	}
+	return st
+}
+
+func (c MemoryByte) Invalidate(mask string, from, to time.Time) (int, error) {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	match := MatchMask(mask)
+	n := 0
+	for h, m := range c.meta {
+		if match(m.tag) && affects(m.from, m.to, from, to) {
+			delete(c.cache, h)
+			delete(c.meta, h)
+			n++
+		}
+	}
+	for key, sr := range c.series {
+		if !match(key.Tag) {
+			continue
+		}
+		n += sr.invalidate(from, to)
+		if sr.empty() {
+			delete(c.series, key)
+		}
+	}
+	return n, nil
+}
+
+// Stale возвращает сам кэш: значения memoryByte не истекают.
+func (c *MemoryByte) Stale() Cache {
+	return c
+}
+
+func (c MemoryByte) Flush() error {
+	MemoryCacheByteLock.Lock()
+	defer MemoryCacheByteLock.Unlock()
+	clear(c.cache)
+	clear(c.meta)
+	clear(c.series)
 	return nil
 }
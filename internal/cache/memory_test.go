@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+	rerrors "robin2/internal/errors"
+)
+
+func testPoint(tag string) Key {
+	return Key{Database: "db", Kind: KindPoint, Tag: tag}
+}
+
+func testSeries(tag string) Key {
+	return Key{Database: "db", Kind: KindSeries, Tag: tag}
+}
+
+func newTestMemory(t *testing.T, cc config.CacheConfig) *Memory {
+	t.Helper()
+	c, err := NewMemory(config.Config{CurrCache: &cc})
+	if err != nil {
+		t.Fatalf("NewMemory: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Disconnect() })
+	return c.(*Memory)
+}
+
+func TestMemoryLRU(t *testing.T) {
+	c := newTestMemory(t, config.CacheConfig{MaxEntries: 2, Shards: 1})
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_ = c.Set(testPoint("a"), date, 1)
+	_ = c.Set(testPoint("b"), date, 2)
+	if _, err := c.Get(testPoint("a"), date); err != nil {
+		t.Fatalf("a must be cached, got %v", err)
+	}
+	_ = c.Set(testPoint("c"), date, 3)
+
+	if _, err := c.Get(testPoint("b"), date); !errors.Is(err, rerrors.ErrKeyNotFound) {
+		t.Fatalf("least recently used b must be evicted, got %v", err)
+	}
+	if v, err := c.Get(testPoint("a"), date); err != nil || v != 1 {
+		t.Fatalf("a: expected 1, got %v (%v)", v, err)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestMemoryTTL(t *testing.T) {
+	c := newTestMemory(t, config.CacheConfig{TTLSeconds: 1, Shards: 2})
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	key := AggregateKey{Database: "db", Tag: "a", From: date, To: date.Add(time.Hour), Group: "avg"}
+	_ = c.SetAggregate(key, 5)
+	if v, err := c.GetAggregate(key); err != nil || v != 5 {
+		t.Fatalf("expected 5, got %v (%v)", v, err)
+	}
+	key.Group = "sum"
+	if _, err := c.GetAggregate(key); !errors.Is(err, rerrors.ErrKeyNotFound) {
+		t.Fatalf("other group must miss, got %v", err)
+	}
+	_ = c.Set(testPoint("a"), date, 1)
+
+	for _, s := range c.shards {
+		s.removeExpired(time.Now().Add(2 * time.Second))
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expired entries must be removed, got %d", c.Len())
+	}
+}
+
+func TestMemoryInvalidate(t *testing.T) {
+	c := newTestMemory(t, config.CacheConfig{Shards: 2})
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_ = c.Set(testPoint("boiler.t1"), day, 1)
+	_ = c.Set(testPoint("boiler.t1"), day.Add(48*time.Hour), 2)
+	_ = c.Set(testPoint("pump.t1"), day, 3)
+	_ = c.SetSeries(testSeries("boiler.t2"), day, day.Add(3*time.Hour), []Point{
+		{Date: day, Value: 1}, {Date: day.Add(time.Hour), Value: 2}, {Date: day.Add(2 * time.Hour), Value: 3},
+	})
+
+	n, err := c.Invalidate("boiler.*", day, day.Add(2*time.Hour))
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 removed, got %d (%v)", n, err)
+	}
+	if _, err := c.Get(testPoint("boiler.t1"), day); !errors.Is(err, rerrors.ErrKeyNotFound) {
+		t.Fatalf("boiler.t1 on day must be invalidated, got %v", err)
+	}
+	if _, err := c.Get(testPoint("boiler.t1"), day.Add(48*time.Hour)); err != nil {
+		t.Fatalf("boiler.t1 outside period must stay, got %v", err)
+	}
+	if _, err := c.Get(testPoint("pump.t1"), day); err != nil {
+		t.Fatalf("pump.t1 must stay, got %v", err)
+	}
+	points, missing, _ := c.GetSeries(testSeries("boiler.t2"), day, day.Add(3*time.Hour))
+	if len(points) != 1 || len(missing) != 1 || !missing[0].To.Equal(day.Add(2*time.Hour)) {
+		t.Fatalf("series must keep only last hour, got %v, missing %v", points, missing)
+	}
+
+	if err := c.Flush(); err != nil || c.Len() != 0 {
+		t.Fatalf("flush: %d entries left (%v)", c.Len(), err)
+	}
+}
+
+func TestMemoryMany(t *testing.T) {
+	c := newTestMemory(t, config.CacheConfig{Shards: 4})
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	dates := []time.Time{day, day.Add(time.Hour), day.Add(2 * time.Hour)}
+
+	_ = c.SetMany(testPoint("a"), []Point{{Date: dates[0], Value: 1}, {Date: dates[2], Value: 3}})
+	values, found, err := c.GetMany(testPoint("a"), dates)
+	if err != nil || !found[0] || found[1] || !found[2] || values[0] != 1 || values[2] != 3 {
+		t.Fatalf("unexpected batch result %v %v (%v)", values, found, err)
+	}
+
+	keys := []AggregateKey{
+		{Database: "db", Tag: "a", From: dates[0], To: dates[1], Group: "avg"},
+		{Database: "db", Tag: "b", From: dates[1], To: dates[2], Group: "avg"},
+	}
+	_ = c.SetAggregates(keys[1:], []float32{7})
+	values, found, _ = c.GetAggregates(keys)
+	if found[0] || !found[1] || values[1] != 7 {
+		t.Fatalf("unexpected aggregates %v %v", values, found)
+	}
+}
+
+func TestMemoryNamespaces(t *testing.T) {
+	c := newTestMemory(t, config.CacheConfig{})
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_ = c.Set(testPoint("a"), date, 1)
+	for _, key := range []Key{
+		{Database: "other", Kind: KindPoint, Tag: "a"},
+		{Database: "db", Kind: PointKind("select interp"), Tag: "a"},
+	} {
+		if _, err := c.Get(key, date); !errors.Is(err, rerrors.ErrKeyNotFound) {
+			t.Fatalf("%s must not see value of %s, got %v", key, testPoint("a"), err)
+		}
+	}
+}
+
+func TestMemoryStale(t *testing.T) {
+	c := newTestMemory(t, config.CacheConfig{TTLSeconds: 1, StaleGrace: 60, Shards: 1})
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_ = c.Set(testPoint("a"), date, 1)
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := c.Get(testPoint("a"), date); !errors.Is(err, rerrors.ErrKeyNotFound) {
+		t.Fatalf("expired value must miss, got %v", err)
+	}
+	if v, err := c.Stale().Get(testPoint("a"), date); err != nil || v != 1 {
+		t.Fatalf("stale read within grace: expected 1, got %v (%v)", v, err)
+	}
+
+	for _, s := range c.shards {
+		s.removeExpired(time.Now().Add(time.Minute))
+	}
+	if _, err := c.Stale().Get(testPoint("a"), date); !errors.Is(err, rerrors.ErrKeyNotFound) {
+		t.Fatalf("value past grace must be removed, got %v", err)
+	}
+}
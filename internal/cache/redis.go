@@ -2,10 +2,13 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"robin2/internal/config"
+	"robin2/internal/errors"
 	"robin2/internal/logger"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -25,11 +28,17 @@ type Redis struct {
 	rds    *redis.Client
 	config config.Config
 	ttl    time.Duration
+	grace  time.Duration
+	prefix string
+	stats  *counters
+	// stale - представление Stale: читаются и просроченные значения
+	stale bool
 }
 
 func NewRedis(cfg config.Config) (Cache, error) {
 	t := Redis{
 		config: cfg,
+		stats:  &counters{},
 	}
 	err := t.Connect()
 	if err != nil {
@@ -46,7 +55,9 @@ func (c *Redis) Connect() error {
 	port := c.config.CurrCache.Port
 	password := c.config.CurrCache.Password
 	db := c.config.CurrCache.DB
-	c.ttl = time.Duration(c.config.CurrCache.TTL) * time.Hour
+	c.ttl = c.config.CurrCache.Expiration()
+	c.grace = c.config.CurrCache.Grace()
+	c.prefix = c.config.CurrCache.Prefix
 	logger.Trace("RedisCacheImpl.Connect")
 	c.rds = redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", host, port),
@@ -72,29 +83,691 @@ func (c Redis) Disconnect() error {
 	return c.rds.Close()
 }
 
-func (c Redis) Get(tag string, date time.Time) (float32, error) {
+const redisDateLayout = "2006-01-02 15:04:05"
+
+// hashKey возвращает имя хэша значений тега: префикс кэша и пространство имён key.
+func (c Redis) hashKey(key Key) string {
+	return c.prefix + key.String()
+}
+
+func (c Redis) Get(key Key, date time.Time) (float32, error) {
 	logger.Trace("RedisCacheImpl.Get")
-	c.rds.Expire(context.Background(), tag, c.ttl)
-	return c.rds.HGet(context.Background(), tag, date.Format("2006-01-02 15:04:05")).Float32()
+	values, found, err := c.hmget([]string{c.hashKey(key)}, []string{date.Format(redisDateLayout)})
+	if err != nil {
+		return 0, err
+	}
+	if !found[0] {
+		return 0, errors.ErrKeyNotFound
+	}
+	return values[0], nil
 }
 
-func (c Redis) Set(tag string, date time.Time, value float32) error {
+func (c Redis) Set(key Key, date time.Time, value float32) error {
 	logger.Trace("RedisCacheImpl.Set")
-	c.rds.Expire(context.Background(), tag, c.ttl)
-	c.rds.HSet(context.Background(), tag, date.Format("2006-01-02 15:04:05"), value)
-	return nil
+	return c.hset([]string{c.hashKey(key)}, []string{date.Format(redisDateLayout)}, []float32{value})
+}
+
+// GetAggregate читает агрегированное значение из хэша агрегатов тега; поле - период и группировка.
+func (c Redis) GetAggregate(key AggregateKey) (float32, error) {
+	logger.Trace("RedisCacheImpl.GetAggregate")
+	values, found, err := c.hmget([]string{c.hashKey(key.Key())}, []string{key.Field()})
+	if err != nil {
+		return 0, err
+	}
+	if !found[0] {
+		return 0, errors.ErrKeyNotFound
+	}
+	return values[0], nil
+}
+
+func (c Redis) SetAggregate(key AggregateKey, value float32) error {
+	logger.Trace("RedisCacheImpl.SetAggregate")
+	return c.hset([]string{c.hashKey(key.Key())}, []string{key.Field()}, []float32{value})
+}
+
+func (c Redis) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	logger.Trace("RedisCacheImpl.GetMany")
+	hash := c.hashKey(key)
+	keys, fields := make([]string, len(dates)), make([]string, len(dates))
+	for i, d := range dates {
+		keys[i], fields[i] = hash, d.Format(redisDateLayout)
+	}
+	return c.hmget(keys, fields)
+}
+
+func (c Redis) SetMany(key Key, points []Point) error {
+	logger.Trace("RedisCacheImpl.SetMany")
+	hash := c.hashKey(key)
+	keys, fields, values := make([]string, len(points)), make([]string, len(points)), make([]float32, len(points))
+	for i, p := range points {
+		keys[i], fields[i], values[i] = hash, p.Date.Format(redisDateLayout), p.Value
+	}
+	return c.hset(keys, fields, values)
+}
+
+func (c Redis) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
+	logger.Trace("RedisCacheImpl.GetAggregates")
+	tags, fields := make([]string, len(keys)), make([]string, len(keys))
+	for i, k := range keys {
+		tags[i], fields[i] = c.hashKey(k.Key()), k.Field()
+	}
+	return c.hmget(tags, fields)
+}
+
+func (c Redis) SetAggregates(keys []AggregateKey, values []float32) error {
+	logger.Trace("RedisCacheImpl.SetAggregates")
+	tags, fields := make([]string, len(keys)), make([]string, len(keys))
+	for i, k := range keys {
+		tags[i], fields[i] = c.hashKey(k.Key()), k.Field()
+	}
+	return c.hset(tags, fields, values)
+}
+
+// byKey группирует индексы полей по хэшам тегов в порядке первого появления.
+func byKey(keys []string) ([]string, map[string][]int) {
+	var order []string
+	idx := make(map[string][]int)
+	for i, k := range keys {
+		if _, ok := idx[k]; !ok {
+			order = append(order, k)
+		}
+		idx[k] = append(idx[k], i)
+	}
+	return order, idx
+}
+
+// hmget читает поля fields[i] хэшей keys[i] одним конвейером: по одному
+// HMGET на хэш и продление времени жизни хэша. Поля просроченного хэша
+// (см. expired) считаются промахами.
+func (c Redis) hmget(keys, fields []string) ([]float32, []bool, error) {
+	ctx := context.Background()
+	values := make([]float32, len(keys))
+	found := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return values, found, nil
+	}
+	order, idx := byKey(keys)
+	pipe := c.rds.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(order))
+	ttls := make([]*redis.DurationCmd, len(order))
+	for n, key := range order {
+		f := make([]string, len(idx[key]))
+		for j, i := range idx[key] {
+			f[j] = fields[i]
+		}
+		if c.checkExpired() {
+			ttls[n] = pipe.PTTL(ctx, key)
+		}
+		cmds[n] = pipe.HMGet(ctx, key, f...)
+		c.touch(ctx, pipe, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+	for n, key := range order {
+		expired := ttls[n] != nil && c.expired(ttls[n].Val())
+		for j, v := range cmds[n].Val() {
+			i := idx[key][j]
+			if str, ok := v.(string); ok && !expired {
+				if f, err := strconv.ParseFloat(str, 32); err == nil {
+					values[i], found[i] = float32(f), true
+				}
+			}
+			c.stats.hit(found[i])
+		}
+	}
+	return values, found, nil
+}
+
+// hset записывает значения values[i] в поля fields[i] хэшей keys[i] одним конвейером.
+func (c Redis) hset(keys, fields []string, values []float32) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	order, idx := byKey(keys)
+	pipe := c.rds.Pipeline()
+	for _, key := range order {
+		m := make(map[string]interface{}, len(idx[key]))
+		for _, i := range idx[key] {
+			m[fields[i]] = values[i]
+		}
+		pipe.HSet(ctx, key, m)
+		c.extend(ctx, pipe, key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// touchScript продлевает время жизни ключа KEYS[1] до ARGV[2] мс, если
+// осталось больше ARGV[1] мс: просроченные значения, хранящиеся для Stale,
+// не должны снова становиться свежими.
+const touchScript = `if redis.call('PTTL', KEYS[1]) > tonumber(ARGV[1]) then return redis.call('PEXPIRE', KEYS[1], ARGV[2]) end return 0`
+
+// touch продлевает время жизни хэша тега при чтении в том же конвейере, что
+// и обращение к нему. При stale_grace продлевается только непросроченный
+// хэш - на ttl и stale_grace, как при записи.
+func (c Redis) touch(ctx context.Context, pipe redis.Pipeliner, key string) {
+	switch {
+	case c.ttl <= 0:
+	case c.grace <= 0:
+		pipe.Expire(ctx, key, c.ttl)
+	default:
+		pipe.Eval(ctx, touchScript, []string{key}, c.grace.Milliseconds(), c.keep().Milliseconds())
+	}
+}
+
+// extend продлевает время жизни ключа при записи: на ttl и stale_grace, в
+// течение которого просроченные значения доступны через Stale.
+func (c Redis) extend(ctx context.Context, pipe redis.Pipeliner, key string) {
+	if c.ttl > 0 {
+		pipe.Expire(ctx, key, c.keep())
+	}
+}
+
+func (c Redis) keep() time.Duration {
+	if c.ttl <= 0 {
+		return 0
+	}
+	return c.ttl + c.grace
+}
+
+// checkExpired - при чтении нужно проверять оставшееся время жизни ключей.
+func (c Redis) checkExpired() bool {
+	return c.ttl > 0 && c.grace > 0 && !c.stale
+}
+
+// expired сообщает, что ключ с оставшимся временем жизни pttl просрочен:
+// он хранится только в пределах stale_grace.
+func (c Redis) expired(pttl time.Duration) bool {
+	return pttl >= 0 && pttl <= c.grace
+}
+
+// Stale возвращает представление кэша, читающее и просроченные значения.
+func (c Redis) Stale() Cache {
+	if c.grace <= 0 {
+		return &c
+	}
+	c.stale = true
+	return &c
+}
+
+// Серия тега хранится хэшем с именем ключа серии: поле - начало блока
+// seriesBlock (мс UTC), значение - точки блока, сжатые encodeSeries (см.
+// gorilla.go); загруженные интервалы - JSON-списком в "{ключ}:coverage".
+func (c Redis) seriesKeys(key Key) (string, string) {
+	return c.hashKey(key), c.hashKey(key) + redisCoverageSuffix
+}
+
+const (
+	redisCoverageSuffix = ":coverage"
+	// redisSeriesRetries - попытки записи серии, если её одновременно изменил другой клиент
+	redisSeriesRetries = 3
+)
+
+// seriesFields возвращает поля блоков, пересекающихся с [from, to), и начало первого блока.
+func seriesFields(from, to time.Time) ([]string, time.Time) {
+	start := from.UTC().Truncate(seriesBlock)
+	var fields []string
+	for b := start; b.Before(to); b = b.Add(seriesBlock) {
+		fields = append(fields, strconv.FormatInt(b.UnixMilli(), 10))
+	}
+	return fields, start
+}
+
+// isWrongType сообщает, что ключ серии хранится в прежнем формате (sorted set).
+func isWrongType(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+// dropLegacy удаляет серию в прежнем формате вместе с её покрытием: она
+// будет загружена заново.
+func (c Redis) dropLegacy(ctx context.Context, key, covKey string) error {
+	logger.Info(fmt.Sprintf("redis cache: dropping series %s in legacy format", key))
+	return c.rds.Del(ctx, key, covKey).Err()
+}
+
+func (c Redis) coverage(ctx context.Context, rds redis.Cmdable, key string) ([]Interval, error) {
+	var covered []Interval
+	raw, err := rds.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &covered); err != nil {
+		return nil, err
+	}
+	return covered, nil
+}
+
+// block распаковывает значение поля блока; повреждённый блок считается пустым.
+func block(key string, v interface{}) []Point {
+	raw, ok := v.(string)
+	if !ok {
+		return nil
+	}
+	points, err := decodeSeries([]byte(raw))
+	if err != nil {
+		logger.Error(fmt.Sprintf("redis cache: series %s: %v", key, err))
+		return nil
+	}
+	return points
+}
+
+func (c Redis) GetSeries(skey Key, from, to time.Time) ([]Point, []Interval, error) {
+	logger.Trace("RedisCacheImpl.GetSeries")
+	ctx := context.Background()
+	key, covKey := c.seriesKeys(skey)
+	covered, err := c.coverage(ctx, c.rds, covKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.checkExpired() && len(covered) > 0 {
+		pttl, err := c.rds.PTTL(ctx, covKey).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.expired(pttl) {
+			covered = nil
+		}
+	}
+	missing := Missing(covered, Interval{From: from, To: to})
+	if len(covered) == 0 {
+		c.stats.hit(false)
+		return nil, missing, nil
+	}
+
+	fields, _ := seriesFields(from, to)
+	if len(fields) == 0 {
+		return nil, missing, nil
+	}
+	blocks, err := c.rds.HMGet(ctx, key, fields...).Result()
+	if isWrongType(err) {
+		c.stats.hit(false)
+		return nil, []Interval{{From: from, To: to}}, c.dropLegacy(ctx, key, covKey)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	c.stats.hit(len(missing) == 0)
+	var points []Point
+	for _, b := range blocks {
+		for _, p := range block(key, b) {
+			if !p.Date.Before(from) && p.Date.Before(to) {
+				points = append(points, Point{Date: p.Date.In(from.Location()), Value: p.Value})
+			}
+		}
+	}
+	return points, missing, nil
+}
+
+// SetSeries заменяет значения за [from, to) в затронутых блоках и обновляет
+// список загруженных интервалов. Блоки и покрытие изменяются в транзакции
+// с WATCH: одновременная запись другого процесса повторяется.
+func (c Redis) SetSeries(skey Key, from, to time.Time, points []Point) error {
+	logger.Trace("RedisCacheImpl.SetSeries")
+	ctx := context.Background()
+	key, covKey := c.seriesKeys(skey)
+	var err error
+	for i := 0; i < redisSeriesRetries; i++ {
+		err = c.rds.Watch(ctx, func(tx *redis.Tx) error {
+			return c.setSeries(ctx, tx, key, covKey, from, to, points)
+		}, key, covKey)
+		if isWrongType(err) {
+			if err = c.dropLegacy(ctx, key, covKey); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return err
+}
+
+func (c Redis) setSeries(ctx context.Context, tx *redis.Tx, key, covKey string, from, to time.Time, points []Point) error {
+	covered, err := c.coverage(ctx, tx, covKey)
+	if err != nil {
+		return err
+	}
+	covJSON, err := json.Marshal(Cover(covered, Interval{From: from, To: to}))
+	if err != nil {
+		return err
+	}
+	fields, start := seriesFields(from, to)
+	old := make([]interface{}, len(fields))
+	if len(fields) > 0 {
+		if old, err = tx.HMGet(ctx, key, fields...).Result(); err != nil {
+			return err
+		}
+	}
+
+	var set []interface{}
+	var del []string
+	for i, f := range fields {
+		lo, hi := start.Add(time.Duration(i)*seriesBlock), start.Add(time.Duration(i+1)*seriesBlock)
+		if lo.Before(from) {
+			lo = from
+		}
+		if hi.After(to) {
+			hi = to
+		}
+		sr := series{points: block(key, old[i])}
+		sr.set(lo, hi, points)
+		if len(sr.points) == 0 {
+			if old[i] != nil {
+				del = append(del, f)
+			}
+			continue
+		}
+		set = append(set, f, encodeSeries(sr.points))
+	}
+
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(del) > 0 {
+			pipe.HDel(ctx, key, del...)
+		}
+		if len(set) > 0 {
+			pipe.HSet(ctx, key, set...)
+		}
+		pipe.Set(ctx, covKey, covJSON, c.keep())
+		c.extend(ctx, pipe, key)
+		return nil
+	})
+	return err
+}
+
+// invalidateSeries удаляет точки за iv из блоков серии и снимает отметку о
+// загрузке интервала. Возвращает количество удалённых точек. Как и
+// SetSeries, изменяет блоки и покрытие в транзакции с WATCH.
+func (c Redis) invalidateSeries(ctx context.Context, key Key, iv Interval) (int, error) {
+	name, covName := c.seriesKeys(key)
+	var n int
+	var err error
+	for i := 0; i < redisSeriesRetries; i++ {
+		err = c.rds.Watch(ctx, func(tx *redis.Tx) error {
+			var terr error
+			n, terr = c.uncoverSeries(ctx, tx, name, covName, iv)
+			return terr
+		}, name, covName)
+		if isWrongType(err) {
+			return 0, c.dropLegacy(ctx, name, covName)
+		}
+		if err != redis.TxFailedErr {
+			if err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+	}
+	return 0, err
 }
 
-func (c Redis) GetStr(tag string, field string) (float32, error) {
-	logger.Trace("RedisCacheImpl.GetStr")
-	c.rds.Expire(context.Background(), tag, c.ttl)
-	return c.rds.HGet(context.Background(), tag, field).Float32()
+func (c Redis) uncoverSeries(ctx context.Context, tx *redis.Tx, name, covName string, iv Interval) (int, error) {
+	blocks, err := tx.HGetAll(ctx, name).Result()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	var set []interface{}
+	var del []string
+	for f, raw := range blocks {
+		ms, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			continue
+		}
+		if b := time.UnixMilli(ms); !b.Before(iv.To) || !b.Add(seriesBlock).After(iv.From) {
+			continue
+		}
+		sr := series{points: block(name, raw)}
+		removed := sr.invalidate(iv.From, iv.To)
+		if removed == 0 {
+			continue
+		}
+		n += removed
+		if len(sr.points) == 0 {
+			del = append(del, f)
+		} else {
+			set = append(set, f, encodeSeries(sr.points))
+		}
+	}
+
+	covered, err := c.coverage(ctx, tx, covName)
+	if err != nil {
+		return 0, err
+	}
+	covered = Uncover(covered, iv)
+	var covJSON []byte
+	if len(covered) > 0 {
+		if covJSON, err = json.Marshal(covered); err != nil {
+			return 0, err
+		}
+	}
 
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(del) > 0 {
+			pipe.HDel(ctx, name, del...)
+		}
+		if len(set) > 0 {
+			pipe.HSet(ctx, name, set...)
+		}
+		if covJSON == nil {
+			pipe.Del(ctx, covName)
+		} else {
+			pipe.Set(ctx, covName, covJSON, redis.KeepTTL)
+		}
+		return nil
+	})
+	return n, err
 }
 
-func (c Redis) SetStr(tag string, field string, value float32) error {
-	logger.Trace("RedisCacheImpl.SetStr")
-	c.rds.Expire(context.Background(), tag, c.ttl)
-	c.rds.HSet(context.Background(), tag, field, value)
+const (
+	// redisEncodingSample - наибольшее число серий, по блокам которых
+	// считается сжатие в Redis
+	redisEncodingSample = 100
+	// redisEncodingScans - наибольшее число шагов SCAN при поиске серий
+	redisEncodingScans = 10
+)
+
+// encoding оценивает сжатие хранящихся в Redis блоков серий кэша по выборке
+// не более чем из redisEncodingSample серий: статистика не должна читать весь
+// кэш. Блоки выборки читаются одним конвейером.
+func (c Redis) encoding(ctx context.Context) (*EncodingStats, error) {
+	match := redisPattern(c.prefix) + "*|" + KindSeries + "|*"
+	var names []string
+	var cursor uint64
+	// skipped - серии, не вошедшие в выборку
+	skipped := false
+	for i := 0; i < redisEncodingScans; i++ {
+		keys, next, err := c.rds.Scan(ctx, cursor, match, 1000).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range keys {
+			key, ok := ParseKey(strings.TrimPrefix(name, c.prefix))
+			if !ok || key.Kind != KindSeries || strings.HasSuffix(name, redisCoverageSuffix) {
+				continue
+			}
+			if len(names) == redisEncodingSample {
+				skipped = true
+				break
+			}
+			names = append(names, name)
+		}
+		if cursor = next; cursor == 0 || skipped {
+			break
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(names))
+	_, err := c.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, name := range names {
+			cmds[i] = pipe.HGetAll(ctx, name)
+		}
+		return nil
+	})
+	if err != nil && !isWrongType(err) {
+		return nil, err
+	}
+	var e EncodingStats
+	if skipped || cursor != 0 {
+		e.Sampled = int64(len(names))
+	}
+	for i, cmd := range cmds {
+		blocks, err := cmd.Result()
+		if err != nil {
+			continue
+		}
+		for _, raw := range blocks {
+			e.add(block(names[i], raw), len(raw))
+		}
+	}
+	return e.result(), nil
+}
+
+// Stats возвращает счётчики этого процесса, оценку сжатия серий по выборке
+// (см. encoding) и размер базы Redis: количество ключей (с префиксом кэша,
+// если он задан) и used_memory сервера.
+func (c Redis) Stats() Stats {
+	ctx := context.Background()
+	st := Stats{Name: c.config.CurrCacheName, Type: "redis", Hits: c.stats.hits.Load(), Misses: c.stats.misses.Load()}
+	if enc, err := c.encoding(ctx); err == nil {
+		st.Encoding = enc
+	} else {
+		logger.Error(err.Error())
+	}
+	if c.prefix == "" {
+		if n, err := c.rds.DBSize(ctx).Result(); err == nil {
+			st.Entries = n
+		} else {
+			logger.Error(err.Error())
+		}
+	} else if keys, err := c.scan(ctx, redisPattern(c.prefix)+"*"); err == nil {
+		st.Entries = int64(len(keys))
+	} else {
+		logger.Error(err.Error())
+	}
+	if info, err := c.rds.Info(ctx, "memory").Result(); err == nil {
+		for _, line := range strings.Split(info, "\n") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(line), "used_memory:"); ok {
+				st.Bytes, _ = strconv.ParseInt(v, 10, 64)
+				break
+			}
+		}
+	} else {
+		logger.Error(err.Error())
+	}
+	return st
+}
+
+// Invalidate удаляет из хэшей тегов значения на даты и агрегаты, попадающие
+// в период, и вырезает период из серий тегов.
+func (c Redis) Invalidate(mask string, from, to time.Time) (int, error) {
+	ctx := context.Background()
+	loc := time.UTC
+	if !from.IsZero() {
+		loc = from.Location()
+	} else if !to.IsZero() {
+		loc = to.Location()
+	}
+	match := MatchMask(mask)
+	n := 0
+
+	keys, err := c.scan(ctx, redisPattern(c.prefix)+"*")
+	if err != nil {
+		return n, err
+	}
+	series := map[Key]bool{}
+	for _, name := range keys {
+		key, ok := ParseKey(strings.TrimSuffix(strings.TrimPrefix(name, c.prefix), redisCoverageSuffix))
+		if !ok || !match(key.Tag) {
+			continue
+		}
+		if key.Kind == KindSeries {
+			series[key] = true
+			continue
+		}
+		fields, err := c.rds.HKeys(ctx, name).Result()
+		if err != nil {
+			return n, err
+		}
+		var del []string
+		for _, f := range fields {
+			if fFrom, fTo, ok := parseField(f, loc); ok && affects(fFrom, fTo, from, to) {
+				del = append(del, f)
+			}
+		}
+		if len(del) > 0 {
+			if err := c.rds.HDel(ctx, name, del...).Err(); err != nil {
+				return n, err
+			}
+			n += len(del)
+		}
+	}
+
+	iv := fullRange(from, to)
+	for key := range series {
+		removed, err := c.invalidateSeries(ctx, key, iv)
+		n += removed
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Flush очищает базу Redis, выбранную в конфигурации кэша. Если задан
+// префикс, удаляются только ключи с этим префиксом: база может быть общей.
+func (c Redis) Flush() error {
+	ctx := context.Background()
+	if c.prefix == "" {
+		return c.rds.FlushDB(ctx).Err()
+	}
+	keys, err := c.scan(ctx, redisPattern(c.prefix)+"*")
+	if err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		n := min(len(keys), 1000)
+		if err := c.rds.Del(ctx, keys[:n]...).Err(); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
 	return nil
 }
+
+func (c Redis) scan(ctx context.Context, match string) ([]string, error) {
+	var keys []string
+	iter := c.rds.Scan(ctx, 0, match, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// redisPattern экранирует спецсимволы шаблона SCAN MATCH в строке s.
+func redisPattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "?", `\?`).Replace(s)
+}
+
+// parseField возвращает период поля хэша: дату значения или период агрегата.
+func parseField(field string, loc *time.Location) (time.Time, time.Time, bool) {
+	parts := strings.Split(field, "|")
+	switch len(parts) {
+	case 1:
+		d, err := time.ParseInLocation(redisDateLayout, field, loc)
+		return d, d, err == nil
+	case 3:
+		f, err1 := time.ParseInLocation(redisDateLayout, parts[0], loc)
+		t, err2 := time.ParseInLocation(redisDateLayout, parts[1], loc)
+		return f, t, err1 == nil && err2 == nil
+	}
+	return time.Time{}, time.Time{}, false
+}
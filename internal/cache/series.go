@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// Interval - полуинтервал времени [From, To).
+type Interval struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Point - значение тега в момент времени.
+type Point struct {
+	Date  time.Time
+	Value float32
+}
+
+// Missing возвращает части интервала want, не покрытые covered.
+// covered должен быть отсортирован и не содержать пересечений (см. Cover).
+func Missing(covered []Interval, want Interval) []Interval {
+	var res []Interval
+	cur := want.From
+	for _, iv := range covered {
+		if !iv.To.After(cur) {
+			continue
+		}
+		if !iv.From.Before(want.To) {
+			break
+		}
+		if iv.From.After(cur) {
+			res = append(res, Interval{From: cur, To: iv.From})
+		}
+		cur = iv.To
+		if !cur.Before(want.To) {
+			return res
+		}
+	}
+	if cur.Before(want.To) {
+		res = append(res, Interval{From: cur, To: want.To})
+	}
+	return res
+}
+
+// Cover добавляет интервал iv к покрытию covered, объединяя пересекающиеся
+// и смежные интервалы. Результат отсортирован по началу.
+func Cover(covered []Interval, iv Interval) []Interval {
+	if !iv.From.Before(iv.To) {
+		return covered
+	}
+	res := make([]Interval, 0, len(covered)+1)
+	for _, c := range covered {
+		switch {
+		case c.To.Before(iv.From):
+			res = append(res, c)
+		case iv.To.Before(c.From):
+			res = append(res, iv)
+			iv = c
+		default:
+			if c.From.Before(iv.From) {
+				iv.From = c.From
+			}
+			if c.To.After(iv.To) {
+				iv.To = c.To
+			}
+		}
+	}
+	return append(res, iv)
+}
+
+// series - загруженные значения тега и интервалы, загруженные полностью.
+type series struct {
+	points   []Point
+	coverage []Interval
+}
+
+// get возвращает точки за [from, to) и непокрытые части интервала.
+func (s *series) get(from, to time.Time) ([]Point, []Interval) {
+	i := sort.Search(len(s.points), func(i int) bool { return !s.points[i].Date.Before(from) })
+	j := sort.Search(len(s.points), func(i int) bool { return !s.points[i].Date.Before(to) })
+	points := make([]Point, j-i)
+	copy(points, s.points[i:j])
+	return points, Missing(s.coverage, Interval{From: from, To: to})
+}
+
+// set заменяет точки за [from, to) на points и отмечает интервал загруженным.
+func (s *series) set(from, to time.Time, points []Point) {
+	i := sort.Search(len(s.points), func(i int) bool { return !s.points[i].Date.Before(from) })
+	j := sort.Search(len(s.points), func(i int) bool { return !s.points[i].Date.Before(to) })
+	in := make([]Point, 0, len(points))
+	for _, p := range points {
+		if !p.Date.Before(from) && p.Date.Before(to) {
+			in = append(in, p)
+		}
+	}
+	sort.Slice(in, func(a, b int) bool { return in[a].Date.Before(in[b].Date) })
+
+	merged := make([]Point, 0, len(s.points)-(j-i)+len(in))
+	merged = append(merged, s.points[:i]...)
+	merged = append(merged, in...)
+	merged = append(merged, s.points[j:]...)
+	s.points = merged
+	s.coverage = Cover(s.coverage, Interval{From: from, To: to})
+}
+
+// invalidate удаляет точки за [from, to) и снимает отметку о загрузке интервала.
+// Возвращает количество удалённых точек.
+func (s *series) invalidate(from, to time.Time) int {
+	iv := fullRange(from, to)
+	i := sort.Search(len(s.points), func(i int) bool { return !s.points[i].Date.Before(iv.From) })
+	j := sort.Search(len(s.points), func(i int) bool { return !s.points[i].Date.Before(iv.To) })
+	s.points = append(s.points[:i], s.points[j:]...)
+	s.coverage = Uncover(s.coverage, iv)
+	return j - i
+}
+
+func (s *series) empty() bool {
+	return len(s.points) == 0 && len(s.coverage) == 0
+}
+
+func (s *series) size() int64 {
+	return int64(len(s.points)*32 + len(s.coverage)*48)
+}
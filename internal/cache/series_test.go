@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMissingAndCover(t *testing.T) {
+	h := func(n int) time.Time { return time.Date(2024, 1, 1, n, 0, 0, 0, time.UTC) }
+
+	var covered []Interval
+	covered = Cover(covered, Interval{h(2), h(4)})
+	covered = Cover(covered, Interval{h(6), h(8)})
+	covered = Cover(covered, Interval{h(4), h(5)})
+	if len(covered) != 2 || !covered[0].From.Equal(h(2)) || !covered[0].To.Equal(h(5)) {
+		t.Fatalf("adjacent intervals must merge, got %v", covered)
+	}
+
+	missing := Missing(covered, Interval{h(1), h(9)})
+	expected := []Interval{{h(1), h(2)}, {h(5), h(6)}, {h(8), h(9)}}
+	if len(missing) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, missing)
+	}
+	for i := range expected {
+		if !missing[i].From.Equal(expected[i].From) || !missing[i].To.Equal(expected[i].To) {
+			t.Fatalf("expected %v, got %v", expected, missing)
+		}
+	}
+	if m := Missing(covered, Interval{h(2), h(5)}); len(m) != 0 {
+		t.Fatalf("covered interval must not be missing, got %v", m)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	h := func(n int) time.Time { return time.Date(2024, 1, 1, n, 0, 0, 0, time.UTC) }
+	s := &series{}
+	s.set(h(0), h(2), []Point{{h(0), 1}, {h(1), 2}})
+	s.set(h(1), h(3), []Point{{h(1), 5}, {h(2), 3}})
+
+	points, missing := s.get(h(0), h(4))
+	if len(points) != 3 || points[1].Value != 5 {
+		t.Fatalf("newer points must replace overlapping ones, got %v", points)
+	}
+	if len(missing) != 1 || !missing[0].From.Equal(h(3)) {
+		t.Fatalf("expected [3h, 4h) missing, got %v", missing)
+	}
+}
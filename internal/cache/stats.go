@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats - статистика кэша для /api/cache/stats/. Для составных кэшей
+// Levels содержит статистику уровней.
+type Stats struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int64  `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	// Encoding - сжатие хранящихся блоков серий
+	Encoding *EncodingStats `json:"encoding,omitempty"`
+	Levels   []Stats        `json:"levels,omitempty"`
+}
+
+// EncodingStats - сколько точек серий хранится сжатыми блоками, размер
+// блоков и размер тех же точек в прежнем формате серий (см. legacyPointSize).
+// Sampled - число серий выборки, если статистика посчитана не по всем сериям.
+type EncodingStats struct {
+	Points       int64   `json:"points"`
+	LegacyBytes  int64   `json:"legacy_bytes"`
+	EncodedBytes int64   `json:"encoded_bytes"`
+	SavedBytes   int64   `json:"saved_bytes"`
+	Ratio        float64 `json:"ratio"`
+	Sampled      int64   `json:"sampled,omitempty"`
+}
+
+// add учитывает хранящийся блок из points размером size байт.
+func (e *EncodingStats) add(points []Point, size int) {
+	e.Points += int64(len(points))
+	e.EncodedBytes += int64(size)
+	for _, p := range points {
+		e.LegacyBytes += legacyPointSize(p)
+	}
+}
+
+// result дополняет статистику экономией; nil - блоков нет.
+func (e *EncodingStats) result() *EncodingStats {
+	if e.Points == 0 {
+		return nil
+	}
+	e.SavedBytes = e.LegacyBytes - e.EncodedBytes
+	e.Ratio = float64(e.LegacyBytes) / float64(max(e.EncodedBytes, 1))
+	return e
+}
+
+// legacyPointSize - размер точки в прежнем формате серий Redis: член
+// сортированного множества "мс:значение" и его оценка (8 байт), без
+// накладных расходов самого Redis.
+func legacyPointSize(p Point) int64 {
+	ms := strconv.FormatInt(p.Date.UnixMilli(), 10)
+	v := strconv.FormatFloat(float64(p.Value), 'g', -1, 32)
+	return int64(len(ms)+1+len(v)) + 8
+}
+
+// counters считает попадания и промахи чтения.
+type counters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (c *counters) hit(ok bool) {
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// MatchMask возвращает функцию сопоставления имени тега с маской,
+// в которой * - любая последовательность символов, ? - один символ.
+func MatchMask(mask string) func(string) bool {
+	if mask == "" || mask == "*" {
+		return func(string) bool { return true }
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range mask {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	re := regexp.MustCompile(b.String())
+	return re.MatchString
+}
+
+// affects сообщает, попадает ли значение за [aFrom, aTo) (или в момент aFrom,
+// если aFrom == aTo) в период инвалидации [from, to). Нулевые границы
+// периода не ограничивают его.
+func affects(aFrom, aTo, from, to time.Time) bool {
+	if aFrom.Equal(aTo) {
+		return (from.IsZero() || !aFrom.Before(from)) && (to.IsZero() || aFrom.Before(to))
+	}
+	return (to.IsZero() || aFrom.Before(to)) && (from.IsZero() || aTo.After(from))
+}
+
+// Uncover исключает интервал iv из покрытия covered.
+func Uncover(covered []Interval, iv Interval) []Interval {
+	res := make([]Interval, 0, len(covered)+1)
+	for _, c := range covered {
+		if !c.From.Before(iv.To) || !c.To.After(iv.From) {
+			res = append(res, c)
+			continue
+		}
+		if c.From.Before(iv.From) {
+			res = append(res, Interval{From: c.From, To: iv.From})
+		}
+		if c.To.After(iv.To) {
+			res = append(res, Interval{From: iv.To, To: c.To})
+		}
+	}
+	return res
+}
+
+// fullRange возвращает период инвалидации с заменой нулевых границ на крайние значения.
+func fullRange(from, to time.Time) Interval {
+	if from.IsZero() {
+		from = time.Unix(0, 0)
+	}
+	if to.IsZero() {
+		to = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return Interval{From: from, To: to}
+}
@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"fmt"
+	"robin2/internal/config"
+	"robin2/internal/logger"
+	"time"
+)
+
+func init() {
+	Register("tiered", NewTiered)
+}
+
+// Tiered - двухуровневый кэш: быстрый L1 (обычно memory) перед общим L2
+// (обычно redis). Чтение идёт сначала из L1, промах читается из L2 и
+// сохраняется в L1; запись выполняется в оба уровня.
+//
+// В конфигурации l1 и l2 - имена других кэшей из списка cache,
+// l1_ttl - время жизни значений в L1 в секундах.
+type Tiered struct {
+	l1     Cache
+	l2     Cache
+	config config.Config
+	stats  *counters
+}
+
+func NewTiered(cfg config.Config) (Cache, error) {
+	cc := cfg.CurrCache
+	l1cfg, err := tierConfig(cfg, cc.L1)
+	if err != nil {
+		return nil, err
+	}
+	if cc.L1TTL > 0 {
+		l1cfg.CurrCache.TTLSeconds = cc.L1TTL
+	}
+	l2cfg, err := tierConfig(cfg, cc.L2)
+	if err != nil {
+		return nil, err
+	}
+
+	l1, err := New(l1cfg)
+	if err != nil {
+		return nil, err
+	}
+	l2, err := New(l2cfg)
+	if err != nil {
+		if derr := l1.Disconnect(); derr != nil {
+			logger.Error(derr.Error())
+		}
+		return nil, err
+	}
+	logger.Trace("NewTieredCache")
+	return &Tiered{l1: l1, l2: l2, config: cfg, stats: &counters{}}, nil
+}
+
+func tierConfig(cfg config.Config, name string) (config.Config, error) {
+	tcfg, err := cfg.WithCache(name)
+	if err != nil {
+		return tcfg, fmt.Errorf("tiered cache %s: level %q: %w", cfg.CurrCacheName, name, err)
+	}
+	if tcfg.CurrCache.Type == "tiered" {
+		return tcfg, fmt.Errorf("tiered cache %s: level %q must not be tiered", cfg.CurrCacheName, name)
+	}
+	return tcfg, nil
+}
+
+func (c *Tiered) Connect() error {
+	if err := c.l1.Connect(); err != nil {
+		return err
+	}
+	return c.l2.Connect()
+}
+
+func (c *Tiered) Disconnect() error {
+	err := c.l1.Disconnect()
+	if err2 := c.l2.Disconnect(); err2 != nil {
+		err = err2
+	}
+	return err
+}
+
+func (c *Tiered) Get(key Key, date time.Time) (float32, error) {
+	if v, err := c.l1.Get(key, date); err == nil {
+		c.stats.hit(true)
+		return v, nil
+	}
+	v, err := c.l2.Get(key, date)
+	c.stats.hit(err == nil)
+	if err != nil {
+		return v, err
+	}
+	c.fill(c.l1.Set(key, date, v))
+	return v, nil
+}
+
+func (c *Tiered) Set(key Key, date time.Time, value float32) error {
+	if err := c.l2.Set(key, date, value); err != nil {
+		return err
+	}
+	return c.l1.Set(key, date, value)
+}
+
+func (c *Tiered) GetAggregate(key AggregateKey) (float32, error) {
+	if v, err := c.l1.GetAggregate(key); err == nil {
+		c.stats.hit(true)
+		return v, nil
+	}
+	v, err := c.l2.GetAggregate(key)
+	c.stats.hit(err == nil)
+	if err != nil {
+		return v, err
+	}
+	c.fill(c.l1.SetAggregate(key, v))
+	return v, nil
+}
+
+func (c *Tiered) SetAggregate(key AggregateKey, value float32) error {
+	if err := c.l2.SetAggregate(key, value); err != nil {
+		return err
+	}
+	return c.l1.SetAggregate(key, value)
+}
+
+// GetMany читает из L1 все даты, затем одним обращением дочитывает промахи из L2.
+func (c *Tiered) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	values, found, err := c.l1.GetMany(key, dates)
+	if err != nil {
+		values, found = make([]float32, len(dates)), make([]bool, len(dates))
+	}
+	var idx []int
+	var rest []time.Time
+	for i, ok := range found {
+		if !ok {
+			idx = append(idx, i)
+			rest = append(rest, dates[i])
+		} else {
+			c.stats.hit(true)
+		}
+	}
+	if len(rest) == 0 {
+		return values, found, nil
+	}
+	v2, f2, err := c.l2.GetMany(key, rest)
+	if err != nil {
+		return values, found, err
+	}
+	var fill []Point
+	for j, i := range idx {
+		c.stats.hit(f2[j])
+		if f2[j] {
+			values[i], found[i] = v2[j], true
+			fill = append(fill, Point{Date: dates[i], Value: v2[j]})
+		}
+	}
+	if len(fill) > 0 {
+		c.fill(c.l1.SetMany(key, fill))
+	}
+	return values, found, nil
+}
+
+func (c *Tiered) SetMany(key Key, points []Point) error {
+	if err := c.l2.SetMany(key, points); err != nil {
+		return err
+	}
+	return c.l1.SetMany(key, points)
+}
+
+// GetAggregates читает из L1 все ключи, затем одним обращением дочитывает промахи из L2.
+func (c *Tiered) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
+	values, found, err := c.l1.GetAggregates(keys)
+	if err != nil {
+		values, found = make([]float32, len(keys)), make([]bool, len(keys))
+	}
+	var idx []int
+	var rest []AggregateKey
+	for i, ok := range found {
+		if !ok {
+			idx = append(idx, i)
+			rest = append(rest, keys[i])
+		} else {
+			c.stats.hit(true)
+		}
+	}
+	if len(rest) == 0 {
+		return values, found, nil
+	}
+	v2, f2, err := c.l2.GetAggregates(rest)
+	if err != nil {
+		return values, found, err
+	}
+	var fillKeys []AggregateKey
+	var fillValues []float32
+	for j, i := range idx {
+		c.stats.hit(f2[j])
+		if f2[j] {
+			values[i], found[i] = v2[j], true
+			fillKeys = append(fillKeys, keys[i])
+			fillValues = append(fillValues, v2[j])
+		}
+	}
+	if len(fillKeys) > 0 {
+		c.fill(c.l1.SetAggregates(fillKeys, fillValues))
+	}
+	return values, found, nil
+}
+
+func (c *Tiered) SetAggregates(keys []AggregateKey, values []float32) error {
+	if err := c.l2.SetAggregates(keys, values); err != nil {
+		return err
+	}
+	return c.l1.SetAggregates(keys, values)
+}
+
+// GetSeries возвращает серию из L1, если он покрывает весь интервал; иначе
+// читает серию из L2 и переносит в L1 интервалы, которые покрывает L2.
+func (c *Tiered) GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error) {
+	points, missing, err := c.l1.GetSeries(key, from, to)
+	if err == nil && len(missing) == 0 {
+		c.stats.hit(true)
+		return points, nil, nil
+	}
+	points, missing, err = c.l2.GetSeries(key, from, to)
+	c.stats.hit(err == nil && len(missing) == 0)
+	if err != nil {
+		return points, missing, err
+	}
+	// покрытые L2 части интервала - дополнение к missing
+	for _, iv := range Missing(missing, Interval{From: from, To: to}) {
+		part := make([]Point, 0)
+		for _, p := range points {
+			if !p.Date.Before(iv.From) && p.Date.Before(iv.To) {
+				part = append(part, p)
+			}
+		}
+		c.fill(c.l1.SetSeries(key, iv.From, iv.To, part))
+	}
+	return points, missing, nil
+}
+
+func (c *Tiered) SetSeries(key Key, from, to time.Time, points []Point) error {
+	if err := c.l2.SetSeries(key, from, to, points); err != nil {
+		return err
+	}
+	return c.l1.SetSeries(key, from, to, points)
+}
+
+// Stats возвращает попадания составного кэша и статистику каждого уровня.
+func (c *Tiered) Stats() Stats {
+	l1, l2 := c.l1.Stats(), c.l2.Stats()
+	return Stats{
+		Name:    c.config.CurrCacheName,
+		Type:    "tiered",
+		Hits:    c.stats.hits.Load(),
+		Misses:  c.stats.misses.Load(),
+		Entries: l1.Entries + l2.Entries,
+		Bytes:   l1.Bytes + l2.Bytes,
+		Levels:  []Stats{l1, l2},
+	}
+}
+
+// Invalidate удаляет значения из обоих уровней; возвращает сумму удалённых.
+func (c *Tiered) Invalidate(mask string, from, to time.Time) (int, error) {
+	n2, err := c.l2.Invalidate(mask, from, to)
+	if err != nil {
+		return n2, err
+	}
+	n1, err := c.l1.Invalidate(mask, from, to)
+	return n1 + n2, err
+}
+
+func (c *Tiered) Flush() error {
+	if err := c.l2.Flush(); err != nil {
+		return err
+	}
+	return c.l1.Flush()
+}
+
+// Stale читает устаревшие значения обоих уровней; найденные в L2 значения
+// не копируются в L1, чтобы не выдать их там за актуальные.
+func (c *Tiered) Stale() Cache {
+	return &Tiered{l1: readOnly{c.l1.Stale()}, l2: c.l2.Stale(), config: c.config, stats: c.stats}
+}
+
+// readOnly - кэш, запись в который игнорируется.
+type readOnly struct {
+	Cache
+}
+
+func (readOnly) Set(Key, time.Time, float32) error                  { return nil }
+func (readOnly) SetAggregate(AggregateKey, float32) error           { return nil }
+func (readOnly) SetMany(Key, []Point) error                         { return nil }
+func (readOnly) SetAggregates([]AggregateKey, []float32) error      { return nil }
+func (readOnly) SetSeries(Key, time.Time, time.Time, []Point) error { return nil }
+
+// fill логирует ошибку заполнения L1: она не влияет на результат чтения.
+func (c *Tiered) fill(err error) {
+	if err != nil {
+		logger.Error(err.Error())
+	}
+}
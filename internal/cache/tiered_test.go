@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+)
+
+func TestTiered(t *testing.T) {
+	cfg, err := config.Config{Cache: []config.CacheConfig{
+		{Name: "tiered", Type: "tiered", L1: "l1", L2: "l2", L1TTL: 60},
+		{Name: "l1", Type: "memory", Shards: 1},
+		{Name: "l2", Type: "memoryByte"},
+	}}.WithCache("tiered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatalf("NewTiered: %v", err)
+	}
+	defer c.Disconnect()
+	tc := c.(*Tiered)
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_ = tc.l2.Set(testPoint("a"), date, 7)
+	if v, err := tc.Get(testPoint("a"), date); err != nil || v != 7 {
+		t.Fatalf("read-through from L2: expected 7, got %v (%v)", v, err)
+	}
+	if v, err := tc.l1.Get(testPoint("a"), date); err != nil || v != 7 {
+		t.Fatalf("L2 hit must fill L1, got %v (%v)", v, err)
+	}
+
+	_ = tc.l2.SetSeries(testSeries("a"), date, date.Add(2*time.Hour), []Point{{date, 1}, {date.Add(time.Hour), 2}})
+	points, missing, err := tc.GetSeries(testSeries("a"), date, date.Add(3*time.Hour))
+	if err != nil || len(points) != 2 || len(missing) != 1 {
+		t.Fatalf("expected 2 points and 1 missing interval, got %v %v (%v)", points, missing, err)
+	}
+	if _, m, _ := tc.l1.GetSeries(testSeries("a"), date, date.Add(2*time.Hour)); len(m) != 0 {
+		t.Fatalf("covered part of the series must be copied to L1, missing %v", m)
+	}
+
+	bad := cfg
+	bad.CurrCache = &config.CacheConfig{Name: "bad", Type: "tiered", L1: "tiered", L2: "l2"}
+	if _, err := NewTiered(bad); err == nil {
+		t.Fatalf("nested tiered cache must be rejected")
+	}
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"robin2/internal/trace"
+)
+
+// traced записывает обращения к кэшу в трассировку запроса.
+type traced struct {
+	Cache
+	t    *trace.Trace
+	name string
+}
+
+// WithTrace возвращает кэш c, обращения к которому записываются в t.
+// Если t или c равны nil, возвращается c.
+func WithTrace(c Cache, t *trace.Trace, name string) Cache {
+	if c == nil || t == nil {
+		return c
+	}
+	return &traced{Cache: c, t: t, name: name}
+}
+
+func (c *traced) Get(key Key, date time.Time) (float32, error) {
+	sp := c.t.Start(trace.LayerCache, "get", c.name).Key(key.String() + "|" + date.Format("2006-01-02 15:04:05"))
+	v, err := c.Cache.Get(key, date)
+	sp.Hit(err == nil).End(nil)
+	return v, err
+}
+
+func (c *traced) Set(key Key, date time.Time, value float32) error {
+	sp := c.t.Start(trace.LayerCache, "set", c.name).Key(key.String() + "|" + date.Format("2006-01-02 15:04:05"))
+	err := c.Cache.Set(key, date, value)
+	sp.End(err)
+	return err
+}
+
+func (c *traced) GetAggregate(key AggregateKey) (float32, error) {
+	sp := c.t.Start(trace.LayerCache, "get", c.name).Key(key.String())
+	v, err := c.Cache.GetAggregate(key)
+	sp.Hit(err == nil).End(nil)
+	return v, err
+}
+
+func (c *traced) SetAggregate(key AggregateKey, value float32) error {
+	sp := c.t.Start(trace.LayerCache, "set", c.name).Key(key.String())
+	err := c.Cache.SetAggregate(key, value)
+	sp.End(err)
+	return err
+}
+
+func (c *traced) GetMany(key Key, dates []time.Time) ([]float32, []bool, error) {
+	sp := c.t.Start(trace.LayerCache, "get_many", c.name).Key(key.String())
+	values, found, err := c.Cache.GetMany(key, dates)
+	sp.Hit(err == nil && allFound(found)).Rows(len(dates)).End(err)
+	return values, found, err
+}
+
+func (c *traced) SetMany(key Key, points []Point) error {
+	sp := c.t.Start(trace.LayerCache, "set_many", c.name).Key(key.String())
+	err := c.Cache.SetMany(key, points)
+	sp.Rows(len(points)).End(err)
+	return err
+}
+
+func (c *traced) GetAggregates(keys []AggregateKey) ([]float32, []bool, error) {
+	sp := c.t.Start(trace.LayerCache, "get_many", c.name).Key(aggregateTags(keys))
+	values, found, err := c.Cache.GetAggregates(keys)
+	sp.Hit(err == nil && allFound(found)).Rows(len(keys)).End(err)
+	return values, found, err
+}
+
+func (c *traced) SetAggregates(keys []AggregateKey, values []float32) error {
+	sp := c.t.Start(trace.LayerCache, "set_many", c.name).Key(aggregateTags(keys))
+	err := c.Cache.SetAggregates(keys, values)
+	sp.Rows(len(keys)).End(err)
+	return err
+}
+
+func allFound(found []bool) bool {
+	for _, ok := range found {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// aggregateTags возвращает теги пакета агрегатов через запятую без повторов.
+func aggregateTags(keys []AggregateKey) string {
+	seen := make(map[string]bool, len(keys))
+	var tags []string
+	for _, k := range keys {
+		if !seen[k.Tag] {
+			seen[k.Tag] = true
+			tags = append(tags, k.Tag)
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+func (c *traced) GetSeries(key Key, from, to time.Time) ([]Point, []Interval, error) {
+	sp := c.t.Start(trace.LayerCache, "get_series", c.name).Key(key.String() + "|" + from.Format("2006-01-02 15:04:05") + "|" + to.Format("2006-01-02 15:04:05"))
+	points, missing, err := c.Cache.GetSeries(key, from, to)
+	sp.Hit(err == nil && len(missing) == 0).Rows(len(points)).End(err)
+	return points, missing, err
+}
+
+func (c *traced) SetSeries(key Key, from, to time.Time, points []Point) error {
+	sp := c.t.Start(trace.LayerCache, "set_series", c.name).Key(key.String() + "|" + from.Format("2006-01-02 15:04:05") + "|" + to.Format("2006-01-02 15:04:05"))
+	err := c.Cache.SetSeries(key, from, to, points)
+	sp.Rows(len(points)).End(err)
+	return err
+}
+
+// Stale записывает в ту же трассировку чтение устаревших значений.
+func (c *traced) Stale() Cache {
+	return WithTrace(c.Cache.Stale(), c.t, c.name+".stale")
+}
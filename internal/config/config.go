@@ -6,6 +6,8 @@ import (
 	"os"
 	"robin2/internal/errors"
 	"robin2/internal/logger"
+	"sort"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -21,6 +23,57 @@ type Config struct {
 	CurrCacheName string        `json:"curr_cache"`
 	Cache         []CacheConfig `json:"cache"`
 	DateFormats   []string      `json:"date_formats"`
+	Prefetch      Prefetch      `json:"prefetch,omitempty"`
+	Templates     Templates     `json:"templates,omitempty"`
+	Scheduler     Scheduler     `json:"scheduler,omitempty"`
+}
+
+// Scheduler - выполнение шаблонов по расписаниям, заданным в самих шаблонах.
+// Результаты сохраняются файлами в каталоге dir (по умолчанию "archive") в
+// формате format (по умолчанию json), если расписание не задаёт свой, и
+// удаляются через keep_days суток (0 - хранятся всегда). Concurrency -
+// сколько шаблонов выполняется одновременно.
+type Scheduler struct {
+	Dir         string `json:"dir,omitempty"`
+	Format      string `json:"format,omitempty"`
+	KeepDays    int    `json:"keep_days,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+}
+
+// Templates - хранилище шаблонов запросов: каталог dir (type "files", по
+// умолчанию) или таблица table базы данных db (type "db", по умолчанию -
+// curr_db). Шаблоны не зависят от того, какая база выбрана текущей.
+// Privileged - имена шаблонов, которым разрешено быть привилегированными:
+// привилегия задаётся только конфигурацией сервера.
+type Templates struct {
+	Type       string   `json:"type,omitempty"`
+	Dir        string   `json:"dir,omitempty"`
+	DB         string   `json:"db,omitempty"`
+	Table      string   `json:"table,omitempty"`
+	Privileged []string `json:"privileged,omitempty"`
+}
+
+// Prefetch - предварительная загрузка значений в кэш при запуске и по
+// расписанию: каждые interval секунд и/или ежедневно в моменты at (ЧЧ:ММ).
+// HotTags самых запрашиваемых тегов дополнительно загружаются за hot_range.
+type Prefetch struct {
+	Interval    int            `json:"interval,omitempty"`
+	At          []string       `json:"at,omitempty"`
+	Concurrency int            `json:"concurrency,omitempty"`
+	HotTags     int            `json:"hot_tags,omitempty"`
+	HotRange    string         `json:"hot_range,omitempty"`
+	Items       []PrefetchItem `json:"items,omitempty"`
+}
+
+// PrefetchItem - что загружать: теги или маски тегов (* и ?) за период range
+// ("today", "yesterday", "last 24h", "last 7d"). Без group загружаются сырые
+// значения, с group - агрегат за период или, если задан count, по count
+// интервалам.
+type PrefetchItem struct {
+	Tags  []string `json:"tags"`
+	Range string   `json:"range"`
+	Group string   `json:"group,omitempty"`
+	Count int      `json:"count,omitempty"`
 }
 
 type Database struct {
@@ -28,6 +81,7 @@ type Database struct {
 	Type             string            `json:"type"`
 	Host             string            `json:"host"`
 	Port             string            `json:"port"`
+	Hosts            []Host            `json:"hosts,omitempty"`
 	User             string            `json:"user"`
 	Password         string            `json:"password"`
 	Database         string            `json:"database"`
@@ -38,12 +92,95 @@ type Database struct {
 	MaxOpenConns     int               `json:"max_open_conns,omitempty"`
 	ConnMaxIdleTime  int               `json:"conn_max_idle_time,omitempty"`
 	ConnMaxLifetime  int               `json:"conn_max_lifetime,omitempty"`
+	HealthInterval   int               `json:"health_interval,omitempty"`
+	BreakerThreshold int               `json:"breaker_threshold,omitempty"`
+	MaxBackoff       int               `json:"max_backoff,omitempty"`
+	ReplicaReadRange int               `json:"replica_read_range,omitempty"`
+	SettleWindow     int               `json:"settle_window,omitempty"`
+	SettleTTL        int               `json:"settle_ttl,omitempty"`
+	StaleIfError     bool              `json:"stale_if_error,omitempty"`
+	Limits           Limits            `json:"limits,omitempty"`
+}
+
+// Limits - ограничения одного запроса к базе данных. Нулевое значение -
+// без ограничения. Endpoints перекрывают общие лимиты для отдельных видов
+// запросов (tag_date, tag_from_to, tag_from_to_group, tag_list и т.д.);
+// отрицательное значение снимает общий лимит для вида запроса.
+type Limits struct {
+	MaxTags   int               `json:"max_tags,omitempty"`
+	MaxCount  int               `json:"max_count,omitempty"`
+	MaxRange  int               `json:"max_range,omitempty"`
+	MaxRows   int               `json:"max_rows,omitempty"`
+	Endpoints map[string]Limits `json:"endpoints,omitempty"`
+}
+
+// For возвращает лимиты для вида запроса kind.
+func (l Limits) For(kind string) Limits {
+	res := Limits{MaxTags: l.MaxTags, MaxCount: l.MaxCount, MaxRange: l.MaxRange, MaxRows: l.MaxRows}
+	e, ok := l.Endpoints[kind]
+	if !ok {
+		return res
+	}
+	if e.MaxTags != 0 {
+		res.MaxTags = e.MaxTags
+	}
+	if e.MaxCount != 0 {
+		res.MaxCount = e.MaxCount
+	}
+	if e.MaxRange != 0 {
+		res.MaxRange = e.MaxRange
+	}
+	if e.MaxRows != 0 {
+		res.MaxRows = e.MaxRows
+	}
+	return res
+}
+
+const (
+	HostPrimary = "primary"
+	HostReplica = "replica"
+)
+
+// Host - один из серверов базы данных: основной или реплика только для чтения.
+type Host struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Role     string `json:"role"`
+	Priority int    `json:"priority"`
+}
+
+// Endpoints возвращает серверы базы в порядке подключения: сначала основные,
+// затем реплики, внутри роли - по возрастанию priority. Если список hosts
+// не задан, возвращается единственный основной сервер из host и port.
+func (d *Database) Endpoints() []Host {
+	if len(d.Hosts) == 0 {
+		return []Host{{Host: d.Host, Port: d.Port, Role: HostPrimary}}
+	}
+	hosts := make([]Host, len(d.Hosts))
+	copy(hosts, d.Hosts)
+	for i := range hosts {
+		if hosts[i].Port == "" {
+			hosts[i].Port = d.Port
+		}
+		if hosts[i].Role == "" {
+			hosts[i].Role = HostPrimary
+		}
+	}
+	sort.SliceStable(hosts, func(i, j int) bool {
+		ri, rj := hosts[i].Role == HostReplica, hosts[j].Role == HostReplica
+		if ri != rj {
+			return rj
+		}
+		return hosts[i].Priority < hosts[j].Priority
+	})
+	return hosts
 }
 
 type CacheConfig struct {
 	Name             string `json:"name"`
 	Type             string `json:"type"`
 	TTL              int    `json:"ttl"`
+	TTLSeconds       int    `json:"ttl_seconds,omitempty"`
 	Active           string `json:"active"`
 	Host             string `json:"host"`
 	Port             string `json:"port"`
@@ -53,6 +190,57 @@ type CacheConfig struct {
 	MaxIdleConns     int    `json:"max_idle_conns"`
 	ConnMaxLifetime  int    `json:"conn_max_lifetime"`
 	ConnectionString string `json:"connection_string"`
+	MaxEntries       int    `json:"max_entries,omitempty"`
+	MaxBytes         int64  `json:"max_bytes,omitempty"`
+	Shards           int    `json:"shards,omitempty"`
+	CleanupInterval  int    `json:"cleanup_interval,omitempty"`
+	L1               string `json:"l1,omitempty"`
+	L2               string `json:"l2,omitempty"`
+	L1TTL            int    `json:"l1_ttl,omitempty"`
+	Dir              string `json:"dir,omitempty"`
+	Prefix           string `json:"prefix,omitempty"`
+	StaleGrace       int    `json:"stale_grace,omitempty"`
+}
+
+// Grace возвращает, сколько просроченные значения ещё хранятся для ответа
+// при недоступной базе данных (stale_if_error).
+func (c *CacheConfig) Grace() time.Duration {
+	return time.Duration(c.StaleGrace) * time.Second
+}
+
+// Expiration возвращает время жизни значений кэша: ttl_seconds в секундах,
+// если задан, иначе ttl в часах. Нулевое значение - без истечения.
+func (c *CacheConfig) Expiration() time.Duration {
+	if c.TTLSeconds > 0 {
+		return time.Duration(c.TTLSeconds) * time.Second
+	}
+	return time.Duration(c.TTL) * time.Hour
+}
+
+// WithDB возвращает копию конфигурации, в которой текущей выбрана база данных name.
+func (c Config) WithDB(name string) (Config, error) {
+	for i := range c.DB {
+		if c.DB[i].Name == name {
+			db := c.DB[i]
+			c.CurrDB = &db
+			c.CurrDBName = name
+			return c, nil
+		}
+	}
+	return c, errors.ErrCurrDBNotFound
+}
+
+// WithCache возвращает копию конфигурации, в которой текущим выбран кэш name.
+func (c Config) WithCache(name string) (Config, error) {
+	for i := range c.Cache {
+		if c.Cache[i].Name == name {
+			cc := c.Cache[i]
+			c.CurrCache = &cc
+			c.CurrCacheName = name
+			return c, nil
+		}
+	}
+	return c, errors.ErrCurrCacheNotFound
 }
 
 func New() *Config {
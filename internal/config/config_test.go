@@ -0,0 +1,32 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEndpoints(t *testing.T) {
+	d := Database{Host: "db", Port: "9000"}
+	if hosts := d.Endpoints(); len(hosts) != 1 || hosts[0] != (Host{Host: "db", Port: "9000", Role: HostPrimary}) {
+		t.Fatalf("host and port must be the only primary, got %+v", hosts)
+	}
+
+	d.Hosts = []Host{
+		{Host: "r2", Role: HostReplica, Priority: 2},
+		{Host: "p2", Priority: 2},
+		{Host: "r1", Role: HostReplica, Priority: 1, Port: "9001"},
+		{Host: "p1", Role: HostPrimary, Priority: 1},
+		{Host: "p3", Priority: 2},
+	}
+	var order []string
+	for _, h := range d.Endpoints() {
+		order = append(order, h.Host+":"+h.Port+":"+h.Role)
+	}
+	want := []string{"p1:9000:primary", "p2:9000:primary", "p3:9000:primary", "r1:9001:replica", "r2:9000:replica"}
+	if !slices.Equal(order, want) {
+		t.Fatalf("expected %v, got %v", want, order)
+	}
+	if d.Hosts[0].Port != "" {
+		t.Fatal("Endpoints must not change the configuration")
+	}
+}
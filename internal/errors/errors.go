@@ -17,4 +17,23 @@ var (
 	ErrCurrDBNotFound         = errors.New("curr database name not found")
 	ErrCurrCacheNotFound      = errors.New("curr cache name not found")
 	ErrCurrCacheNotAvailaible = errors.New("cache is not available")
+	ErrDbUnavailable          = errors.New("database is unavailable")
+	ErrLimitExceeded          = errors.New("query limit exceeded")
+	ErrQueryAborted           = errors.New("shared query aborted")
+	ErrInvalidRange           = errors.New("invalid range")
+	ErrInvalidBlock           = errors.New("invalid series block")
+	ErrTemplateNotFound       = errors.New("template not found")
+	ErrTemplateExists         = errors.New("template already exists")
+	ErrInvalidTemplateName    = errors.New("invalid template name")
+	ErrTemplatesUnavailable   = errors.New("template repository is not available")
+	ErrTemplateParam          = errors.New("invalid template parameter")
+	ErrInvalidSchedule        = errors.New("invalid schedule")
+	ErrScheduleNotFound       = errors.New("schedule not found")
+	ErrJobRunning             = errors.New("job is already running")
+	ErrSchedulerStopped       = errors.New("scheduler is stopped")
+	ErrResultNotFound         = errors.New("result not found")
+	ErrWriteQuery             = errors.New("query modifies data")
+	ErrMultiStatement         = errors.New("multiple statements are not allowed")
+	ErrPrivilegeDenied        = errors.New("template is not allowed to be privileged")
+	ErrInvalidBundle          = errors.New("invalid template bundle")
 )
@@ -0,0 +1,82 @@
+package prefetch
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxTracked ограничивает число отслеживаемых тегов: новые теги сверх него
+// не учитываются до очередного затухания счётчиков.
+const maxTracked = 10000
+
+// HotTag - тег и его затухающий счётчик запросов.
+type HotTag struct {
+	Tag   string  `json:"tag"`
+	Score float64 `json:"score"`
+}
+
+// Tracker считает запросы тегов. Счётчики уменьшаются вдвое после каждой
+// загрузки (Decay), поэтому горячими остаются теги, запрашиваемые недавно.
+type Tracker struct {
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{counts: make(map[string]float64)}
+}
+
+// Record учитывает запрос тегов; элементы tags могут быть списками через запятую.
+func (t *Tracker) Record(tags ...string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, list := range tags {
+		for _, tag := range strings.Split(list, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			if _, ok := t.counts[tag]; !ok && len(t.counts) >= maxTracked {
+				continue
+			}
+			t.counts[tag]++
+		}
+	}
+}
+
+// Top возвращает n самых запрашиваемых тегов по убыванию счётчика.
+func (t *Tracker) Top(n int) []HotTag {
+	t.mu.Lock()
+	res := make([]HotTag, 0, len(t.counts))
+	for tag, score := range t.counts {
+		res = append(res, HotTag{Tag: tag, Score: score})
+	}
+	t.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Tag < res[j].Tag
+	})
+	if n >= 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// Decay уменьшает счётчики вдвое и забывает теги, которые давно не запрашивались.
+func (t *Tracker) Decay() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tag, score := range t.counts {
+		if score /= 2; score < 0.5 {
+			delete(t.counts, tag)
+		} else {
+			t.counts[tag] = score
+		}
+	}
+}
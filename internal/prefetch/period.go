@@ -0,0 +1,47 @@
+package prefetch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"robin2/internal/errors"
+)
+
+// Period возвращает интервал [from, to) для описания периода s относительно now:
+//   - today - с начала суток до текущей минуты;
+//   - yesterday - предыдущие сутки;
+//   - last N<m|h|d> - N минут, часов или суток до начала текущего часа
+//     (для минут - до текущей минуты), чтобы периоды соседних запусков
+//     совпадали с периодами отчётов.
+func Period(s string, now time.Time) (time.Time, time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "today":
+		return midnight, now.Truncate(time.Minute), nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), midnight, nil
+	}
+
+	v, ok := strings.CutPrefix(s, "last ")
+	if !ok || len(v) < 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %q", errors.ErrInvalidRange, s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v[:len(v)-1]))
+	if err != nil || n <= 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %q", errors.ErrInvalidRange, s)
+	}
+	to := now.Truncate(time.Hour)
+	switch v[len(v)-1] {
+	case 'm':
+		to = now.Truncate(time.Minute)
+		return to.Add(-time.Duration(n) * time.Minute), to, nil
+	case 'h':
+		return to.Add(-time.Duration(n) * time.Hour), to, nil
+	case 'd':
+		return to.AddDate(0, 0, -n), to, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("%w: %q", errors.ErrInvalidRange, s)
+}
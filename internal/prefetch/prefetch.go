@@ -0,0 +1,307 @@
+// Package prefetch загружает значения тегов в кэш заранее: при запуске и по
+// расписанию - список из конфигурации и самые запрашиваемые теги.
+package prefetch
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"robin2/internal/config"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+	"robin2/internal/store"
+)
+
+const (
+	defaultConcurrency = 4
+	defaultHotRange    = "last 24h"
+)
+
+// Status - результат последней загрузки для /api/cache/prefetch/.
+type Status struct {
+	Running   bool      `json:"running"`
+	LastRun   time.Time `json:"last_run"`
+	Duration  string    `json:"duration"`
+	Requests  int64     `json:"requests"`
+	Errors    int64     `json:"errors"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRun   time.Time `json:"next_run"`
+	Hot       []HotTag  `json:"hot"`
+}
+
+// Prefetcher выполняет загрузку на активном хранилище, которое возвращает store:
+// хранилище может смениться между запусками.
+type Prefetcher struct {
+	cfg     config.Prefetch
+	store   func() store.Store
+	hot     *Tracker
+	at      []time.Duration
+	running atomic.Bool
+	stop    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+	// startMu упорядочивает запуск загрузки в фоне (см. Trigger) и Stop
+	startMu sync.Mutex
+	mu      sync.Mutex
+	status  Status
+}
+
+func New(cfg config.Prefetch, st func() store.Store, hot *Tracker) *Prefetcher {
+	p := &Prefetcher{cfg: cfg, store: st, hot: hot, stop: make(chan struct{})}
+	for _, s := range cfg.At {
+		t, err := time.Parse("15:04", strings.TrimSpace(s))
+		if err != nil {
+			logger.Error(fmt.Sprintf("prefetch: invalid time %q: %v", s, err))
+			continue
+		}
+		p.at = append(p.at, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	return p
+}
+
+// enabled - есть что загружать.
+func (p *Prefetcher) enabled() bool {
+	return len(p.cfg.Items) > 0 || p.cfg.HotTags > 0
+}
+
+// Start запускает загрузку сразу и затем по расписанию.
+func (p *Prefetcher) Start() {
+	if !p.enabled() {
+		return
+	}
+	p.wg.Add(1)
+	go p.loop()
+}
+
+// Stop останавливает расписание и дожидается завершения текущей загрузки.
+// Повторный вызов только дожидается завершения.
+func (p *Prefetcher) Stop() {
+	p.startMu.Lock()
+	p.once.Do(func() { close(p.stop) })
+	p.startMu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Prefetcher) loop() {
+	defer p.wg.Done()
+	for {
+		p.Run()
+		next := p.next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		p.mu.Lock()
+		p.status.NextRun = next
+		p.mu.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-p.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// next возвращает время следующего запуска: ближайшее из now+interval и
+// моментов at. Нулевое время - расписание не задано.
+func (p *Prefetcher) next(now time.Time) time.Time {
+	var next time.Time
+	if p.cfg.Interval > 0 {
+		next = now.Add(time.Duration(p.cfg.Interval) * time.Second)
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, at := range p.at {
+		t := midnight.Add(at)
+		if !t.After(now) {
+			t = midnight.AddDate(0, 0, 1).Add(at)
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// Run выполняет загрузку. Возвращает false, если загрузка уже выполняется.
+func (p *Prefetcher) Run() bool {
+	if !p.running.CompareAndSwap(false, true) {
+		return false
+	}
+	defer p.running.Store(false)
+	p.run()
+	return true
+}
+
+// Trigger запускает загрузку в фоне; Stop дожидается её завершения.
+// Возвращает false, если загрузка уже выполняется или загрузчик остановлен.
+func (p *Prefetcher) Trigger() bool {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+	select {
+	case <-p.stop:
+		return false
+	default:
+	}
+	if !p.running.CompareAndSwap(false, true) {
+		return false
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.running.Store(false)
+		p.run()
+	}()
+	return true
+}
+
+func (p *Prefetcher) run() {
+	start := time.Now()
+	jobs := p.jobs(start)
+	var requests, failed atomic.Int64
+	var lastErr atomic.Value
+	var unavailable atomic.Bool
+
+	n := p.cfg.Concurrency
+	if n <= 0 {
+		n = defaultConcurrency
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+loop:
+	for _, job := range jobs {
+		// база недоступна - остальные запросы тоже завершатся ошибкой
+		if unavailable.Load() {
+			break
+		}
+		select {
+		case <-p.stop:
+			break loop
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(job func() error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			requests.Add(1)
+			if err := job(); err != nil {
+				failed.Add(1)
+				lastErr.Store(err.Error())
+				if stderrors.Is(err, errors.ErrDbUnavailable) {
+					unavailable.Store(true)
+				}
+			}
+		}(job)
+	}
+	wg.Wait()
+	p.hot.Decay()
+
+	d := time.Since(start).Round(time.Millisecond)
+	p.mu.Lock()
+	p.status.LastRun = start
+	p.status.Duration = d.String()
+	p.status.Requests = requests.Load()
+	p.status.Errors = failed.Load()
+	p.status.LastError, _ = lastErr.Load().(string)
+	p.mu.Unlock()
+	logger.Info(fmt.Sprintf("prefetch: %d requests, %d errors in %s", requests.Load(), failed.Load(), d))
+}
+
+// jobs составляет запросы загрузки на момент now: элементы конфигурации,
+// затем горячие теги, ещё не загруженные как сырые значения за тот же период.
+func (p *Prefetcher) jobs(now time.Time) []func() error {
+	st := p.store()
+	if st == nil {
+		return nil
+	}
+	var jobs []func() error
+	series := make(map[string]bool)
+	for _, item := range p.cfg.Items {
+		from, to, err := Period(item.Range, now)
+		if err != nil {
+			logger.Error("prefetch: " + err.Error())
+			continue
+		}
+		for _, tag := range p.resolve(st, item.Tags) {
+			if item.Group == "" && item.Count == 0 {
+				series[tag+"|"+from.String()+"|"+to.String()] = true
+			}
+			jobs = append(jobs, request(st, tag, from, to, item.Group, item.Count))
+		}
+	}
+
+	if p.cfg.HotTags > 0 {
+		hotRange := p.cfg.HotRange
+		if hotRange == "" {
+			hotRange = defaultHotRange
+		}
+		from, to, err := Period(hotRange, now)
+		if err != nil {
+			logger.Error("prefetch: " + err.Error())
+			return jobs
+		}
+		for _, h := range p.hot.Top(p.cfg.HotTags) {
+			if !series[h.Tag+"|"+from.String()+"|"+to.String()] {
+				jobs = append(jobs, request(st, h.Tag, from, to, "", 0))
+			}
+		}
+	}
+	return jobs
+}
+
+// resolve раскрывает маски тегов (* и ?) по списку тегов базы.
+func (p *Prefetcher) resolve(st store.Store, masks []string) []string {
+	var tags []string
+	for _, m := range masks {
+		if !strings.ContainsAny(m, "*?") {
+			tags = append(tags, m)
+			continue
+		}
+		out, err := st.GetTagList(m)
+		if err != nil {
+			logger.Error(fmt.Sprintf("prefetch: tag list %q: %v", m, err))
+			continue
+		}
+		for _, row := range out.Rows {
+			if len(row) > 0 && row[0] != "" {
+				tags = append(tags, row[0])
+			}
+		}
+	}
+	return tags
+}
+
+// request возвращает запрос, которым значения попадают в кэш: тот же, что
+// выполняет /get/tag/ с такими параметрами.
+func request(st store.Store, tag string, from, to time.Time, group string, count int) func() error {
+	return func() error {
+		var err error
+		switch {
+		case group == "" && count == 0:
+			_, err = st.GetTagFromTo(tag, from, to)
+		case group == "":
+			_, err = st.GetTagCount(tag, from, to, count)
+		case count == 0:
+			_, err = st.GetTagFromToGroup(tag, from, to, group)
+		default:
+			_, err = st.GetTagCountGroup(tag, from, to, count, group)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", tag, err)
+		}
+		return nil
+	}
+}
+
+func (p *Prefetcher) Status() Status {
+	p.mu.Lock()
+	st := p.status
+	p.mu.Unlock()
+	st.Running = p.running.Load()
+	st.Hot = p.hot.Top(p.cfg.HotTags)
+	return st
+}
@@ -0,0 +1,89 @@
+package prefetch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+)
+
+func TestPeriod(t *testing.T) {
+	now := time.Date(2024, 3, 10, 8, 42, 15, 0, time.Local)
+	day := func(d, h int) time.Time { return time.Date(2024, 3, d, h, 0, 0, 0, time.Local) }
+	cases := []struct {
+		s        string
+		from, to time.Time
+	}{
+		{"yesterday", day(9, 0), day(10, 0)},
+		{"today", day(10, 0), time.Date(2024, 3, 10, 8, 42, 0, 0, time.Local)},
+		{"last 24h", day(9, 8), day(10, 8)},
+		{"Last 2d", day(8, 8), day(10, 8)},
+	}
+	for _, c := range cases {
+		from, to, err := Period(c.s, now)
+		if err != nil || !from.Equal(c.from) || !to.Equal(c.to) {
+			t.Errorf("%q: got %v - %v (%v), want %v - %v", c.s, from, to, err, c.from, c.to)
+		}
+	}
+	for _, s := range []string{"", "last", "last 0h", "last 5w", "week"} {
+		if _, _, err := Period(s, now); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	p := New(config.Prefetch{Interval: 3600, At: []string{"07:55", "19:55"}}, nil, NewTracker())
+	now := time.Date(2024, 3, 10, 7, 30, 0, 0, time.Local)
+	if next := p.next(now); !next.Equal(time.Date(2024, 3, 10, 7, 55, 0, 0, time.Local)) {
+		t.Fatalf("expected 07:55, got %v", next)
+	}
+	now = time.Date(2024, 3, 10, 21, 0, 0, 0, time.Local)
+	if next := p.next(now); !next.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected interval, got %v", next)
+	}
+	p = New(config.Prefetch{At: []string{"07:55"}}, nil, NewTracker())
+	if next := p.next(now); !next.Equal(time.Date(2024, 3, 11, 7, 55, 0, 0, time.Local)) {
+		t.Fatalf("expected next day, got %v", next)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	tr.Record("a, b", "a")
+	tr.Record("c")
+	tr.Record("a")
+	top := tr.Top(2)
+	if len(top) != 2 || top[0].Tag != "a" || top[0].Score != 3 || top[1].Tag != "b" {
+		t.Fatalf("unexpected top %v", top)
+	}
+	tr.Decay()
+	if top := tr.Top(-1); len(top) != 3 || top[0].Score != 1.5 {
+		t.Fatalf("unexpected top after decay %v", top)
+	}
+	tr.Decay()
+	if top := tr.Top(-1); len(top) != 1 || top[0].Tag != "a" {
+		t.Fatalf("rarely requested tags must be forgotten, got %v", top)
+	}
+}
+
+func TestStopTrigger(t *testing.T) {
+	p := New(config.Prefetch{}, nil, NewTracker())
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p.Trigger()
+		}()
+		go func() {
+			defer wg.Done()
+			p.Stop()
+		}()
+	}
+	wg.Wait()
+	if p.Trigger() {
+		t.Fatal("stopped prefetcher must not start loading")
+	}
+}
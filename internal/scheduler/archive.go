@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"robin2/internal/data"
+	"robin2/internal/errors"
+	"robin2/internal/format"
+	"robin2/internal/logger"
+)
+
+// fileTime - начало имени файла результата: момент запуска.
+const fileTime = "20060102T150405"
+
+// Result - сохранённый результат выполнения шаблона.
+type Result struct {
+	Template string    `json:"template"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	Time     time.Time `json:"time"`
+}
+
+var extensions = map[string]string{
+	"json":    ".json",
+	"grafana": ".json",
+	"xml":     ".xml",
+	"html":    ".html",
+}
+
+var contentTypes = map[string]string{
+	".json": "application/json",
+	".xml":  "application/xml",
+	".html": "text/html; charset=utf-8",
+}
+
+// ContentType возвращает тип содержимого файла результата.
+func ContentType(file string) string {
+	if ct, ok := contentTypes[filepath.Ext(file)]; ok {
+		return ct
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// save сохраняет результат out расписания index шаблона name, запущенного в
+// момент at, в формате f (пустой - формат из конфигурации) файлом
+// {dir}/{name}/{at}_{index}.{ext} и удаляет устаревшие результаты шаблона.
+// Номер расписания в имени не даёт расписаниям, запущенным в одну секунду,
+// перезаписать результаты друг друга.
+func (s *Scheduler) save(name string, index int, f string, at time.Time, out *data.Output) (string, error) {
+	if f == "" {
+		f = s.cfg.Format
+	}
+	fmtr, err := format.New(f)
+	if err != nil {
+		return "", err
+	}
+	ext, ok := extensions[f]
+	if !ok {
+		ext = ".txt"
+	}
+	dir := filepath.Join(s.cfg.Dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	file := fmt.Sprintf("%s_%d%s", at.Format(fileTime), index, ext)
+	tmp, err := os.CreateTemp(dir, ".result-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(fmtr.Process(out)); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, file)); err != nil {
+		return "", err
+	}
+	s.clean(name, at)
+	return file, nil
+}
+
+// clean удаляет результаты шаблона name старше keep_days суток до now.
+func (s *Scheduler) clean(name string, now time.Time) {
+	if s.cfg.KeepDays <= 0 {
+		return
+	}
+	results, err := s.Results(name)
+	if err != nil {
+		logger.Error("scheduler: " + err.Error())
+		return
+	}
+	border := now.AddDate(0, 0, -s.cfg.KeepDays)
+	for _, r := range results {
+		if r.Time.Before(border) {
+			if err := os.Remove(filepath.Join(s.cfg.Dir, name, r.File)); err != nil {
+				logger.Error("scheduler: " + err.Error())
+			}
+		}
+	}
+}
+
+// Results возвращает сохранённые результаты шаблона name, пустое имя - всех
+// шаблонов, от новых к старым.
+func (s *Scheduler) Results(name string) ([]Result, error) {
+	names := []string{name}
+	if name == "" {
+		entries, err := os.ReadDir(s.cfg.Dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		names = names[:0]
+		for _, e := range entries {
+			if e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+	} else if !validPath(name) {
+		return nil, fmt.Errorf("%w: %s", errors.ErrResultNotFound, name)
+	}
+
+	var res []Result
+	for _, n := range names {
+		entries, err := os.ReadDir(filepath.Join(s.cfg.Dir, n))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			// {at}_{index}.{ext}, у ранних результатов - {at}.{ext}
+			base := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+			base, _, _ = strings.Cut(base, "_")
+			t, err := time.ParseInLocation(fileTime, base, time.Local)
+			if e.IsDir() || err != nil {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			res = append(res, Result{Template: n, File: e.Name(), Size: info.Size(), Time: t})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].Time.Equal(res[j].Time) {
+			return res[i].Time.After(res[j].Time)
+		}
+		return res[i].Template < res[j].Template
+	})
+	return res, nil
+}
+
+// Result возвращает путь к файлу file результатов шаблона name.
+func (s *Scheduler) Result(name, file string) (string, error) {
+	path := filepath.Join(s.cfg.Dir, name, file)
+	if !validPath(name) || !validPath(file) {
+		return "", fmt.Errorf("%w: %s/%s", errors.ErrResultNotFound, name, file)
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("%w: %s/%s", errors.ErrResultNotFound, name, file)
+	}
+	return path, nil
+}
+
+// validPath - s - имя файла или каталога архива, а не путь.
+func validPath(s string) bool {
+	return s != "" && !strings.HasPrefix(s, ".") && filepath.Base(s) == s && !strings.ContainsAny(s, `/\`)
+}
@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"robin2/internal/errors"
+)
+
+// Cron - разобранное расписание cron: множества допустимых минут, часов,
+// дней месяца, месяцев и дней недели (0 - воскресенье) битами.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// если ограничены и день месяца, и день недели, подходит любой из них
+	domAny, dowAny bool
+}
+
+var aliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// ParseCron разбирает расписание из пяти полей "минута час день месяц
+// день_недели". Поле - *, число, диапазон a-b, шаг */n или a-b/n, или список
+// через запятую. Допустимы @hourly, @daily, @weekly, @monthly, @yearly.
+func ParseCron(s string) (Cron, error) {
+	s = strings.TrimSpace(s)
+	if a, ok := aliases[strings.ToLower(s)]; ok {
+		s = a
+	}
+	f := strings.Fields(s)
+	if len(f) != 5 {
+		return Cron{}, fmt.Errorf("%w: %q: expected 5 fields", errors.ErrInvalidSchedule, s)
+	}
+	var c Cron
+	var err error
+	if c.minute, err = field(f[0], 0, 59); err != nil {
+		return Cron{}, fmt.Errorf("%w: %q: minute: %v", errors.ErrInvalidSchedule, s, err)
+	}
+	if c.hour, err = field(f[1], 0, 23); err != nil {
+		return Cron{}, fmt.Errorf("%w: %q: hour: %v", errors.ErrInvalidSchedule, s, err)
+	}
+	if c.dom, err = field(f[2], 1, 31); err != nil {
+		return Cron{}, fmt.Errorf("%w: %q: day of month: %v", errors.ErrInvalidSchedule, s, err)
+	}
+	if c.month, err = field(f[3], 1, 12); err != nil {
+		return Cron{}, fmt.Errorf("%w: %q: month: %v", errors.ErrInvalidSchedule, s, err)
+	}
+	if c.dow, err = field(f[4], 0, 7); err != nil {
+		return Cron{}, fmt.Errorf("%w: %q: day of week: %v", errors.ErrInvalidSchedule, s, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = f[2] == "*", f[4] == "*"
+	return c, nil
+}
+
+// field разбирает поле расписания со значениями от lo до hi.
+func field(s string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if r, st, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(st)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", st)
+			}
+			rng, step = r, n
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if step > 1 {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", rng, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Match сообщает, подходит ли минута t расписанию.
+func (c Cron) Match(t time.Time) bool {
+	return c.minute&(1<<t.Minute()) != 0 &&
+		c.hour&(1<<t.Hour()) != 0 &&
+		c.month&(1<<t.Month()) != 0 &&
+		c.day(t)
+}
+
+func (c Cron) day(t time.Time) bool {
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<t.Weekday()) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next возвращает ближайшую после t минуту расписания; нулевое время, если
+// её нет в ближайшие пять лет (например, 31 февраля).
+func (c Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case c.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.day(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
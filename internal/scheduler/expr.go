@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exprRe - выражение даты: опорный момент и сдвиги, например today-1d+8h.
+var exprRe = regexp.MustCompile(`^(now|hour|today|yesterday|tomorrow)((?:[+-]\d+[mhdw])*)$`)
+
+var shiftRe = regexp.MustCompile(`([+-]\d+)([mhdw])`)
+
+// Expand вычисляет выражение даты s относительно момента запуска now:
+//   - now - момент запуска с точностью до минуты, hour - начало текущего часа;
+//   - today, yesterday, tomorrow - начало суток;
+//   - сдвиги +N или -N минут (m), часов (h), суток (d) или недель (w).
+//
+// Например, отчёт за прошлую смену с 20:00 до 08:00 - from=today-1d+20h,
+// to=today+8h. Если s не выражение, ok = false.
+func Expand(s string, now time.Time) (t time.Time, ok bool) {
+	m := exprRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return time.Time{}, false
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch m[1] {
+	case "now":
+		t = now.Truncate(time.Minute)
+	case "hour":
+		t = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+	case "today":
+		t = midnight
+	case "yesterday":
+		t = midnight.AddDate(0, 0, -1)
+	case "tomorrow":
+		t = midnight.AddDate(0, 0, 1)
+	}
+	for _, sh := range shiftRe.FindAllStringSubmatch(m[2], -1) {
+		n, _ := strconv.Atoi(sh[1])
+		switch sh[2] {
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		}
+	}
+	return t, true
+}
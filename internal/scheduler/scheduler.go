@@ -0,0 +1,290 @@
+// Package scheduler выполняет шаблоны запросов по расписаниям, заданным в
+// самих шаблонах (templrepo.Schedule), и сохраняет результаты файлами архива.
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"robin2/internal/config"
+	"robin2/internal/data"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+	"robin2/internal/templrepo"
+)
+
+const (
+	defaultDir         = "archive"
+	defaultFormat      = "json"
+	defaultConcurrency = 2
+	// dateArg - формат даты из выражения для аргумента, не объявленного датой
+	dateArg = "2006-01-02 15:04:05"
+)
+
+// Exec выполняет шаблон name с аргументами args, как /templ/exec/.
+type Exec func(name string, args map[string]string) (*data.Output, error)
+
+// Job - состояние расписания index шаблона для /templ/schedule/.
+type Job struct {
+	Template  string    `json:"template"`
+	Index     int       `json:"index"`
+	Cron      string    `json:"cron"`
+	Running   bool      `json:"running"`
+	LastRun   time.Time `json:"last_run"`
+	Duration  string    `json:"duration,omitempty"`
+	Rows      int       `json:"rows"`
+	File      string    `json:"file,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRun   time.Time `json:"next_run"`
+}
+
+// Scheduler раз в минуту просматривает шаблоны хранилища, которое возвращает
+// repo, и запускает те, чьё расписание подходит к минуте: хранилище и
+// расписания могут меняться между запусками.
+type Scheduler struct {
+	cfg  config.Scheduler
+	repo func() (templrepo.Repo, error)
+	exec Exec
+	sem  chan struct{}
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func New(cfg config.Scheduler, repo func() (templrepo.Repo, error), exec Exec) *Scheduler {
+	if cfg.Dir == "" {
+		cfg.Dir = defaultDir
+	}
+	if cfg.Format == "" {
+		cfg.Format = defaultFormat
+	}
+	n := cfg.Concurrency
+	if n <= 0 {
+		n = defaultConcurrency
+	}
+	return &Scheduler{
+		cfg:  cfg,
+		repo: repo,
+		exec: exec,
+		sem:  make(chan struct{}, n),
+		stop: make(chan struct{}),
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Start запускает просмотр расписаний в начале каждой минуты.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop останавливает расписания и дожидается завершения выполняемых шаблонов.
+// Повторный вызов только дожидается завершения.
+func (s *Scheduler) Stop() {
+	// под mu: start не добавит задание в wg после начала ожидания
+	s.mu.Lock()
+	s.once.Do(func() { close(s.stop) })
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	s.tick(time.Now(), false)
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.tick(next, true)
+	}
+}
+
+// tick обновляет время следующих запусков расписаний и, если run, запускает
+// шаблоны, расписания которых подходят к минуте at.
+func (s *Scheduler) tick(at time.Time, run bool) {
+	repo, err := s.repo()
+	if err != nil {
+		return
+	}
+	list, err := repo.List("")
+	if err != nil {
+		logger.Error("scheduler: " + err.Error())
+		return
+	}
+	seen := make(map[string]bool)
+	for _, t := range list {
+		for i, sch := range t.Schedules {
+			job := s.job(t.Name, i, sch.Cron)
+			seen[key(t.Name, i)] = true
+			c, err := ParseCron(sch.Cron)
+			s.mu.Lock()
+			if err != nil {
+				job.LastError, job.NextRun = err.Error(), time.Time{}
+				s.mu.Unlock()
+				continue
+			}
+			job.NextRun = c.Next(at)
+			s.mu.Unlock()
+			if run && c.Match(at) {
+				if err := s.start(job, t, i, at); err != nil {
+					logger.Error(fmt.Sprintf("scheduler: %s: %v", t.Name, err))
+				}
+			}
+		}
+	}
+	// расписания удалённых шаблонов
+	s.mu.Lock()
+	for k, job := range s.jobs {
+		if !seen[k] && !job.Running {
+			delete(s.jobs, k)
+		}
+	}
+	s.mu.Unlock()
+}
+
+func key(name string, index int) string {
+	return name + "#" + strconv.Itoa(index)
+}
+
+// job возвращает состояние расписания index шаблона name, создавая его.
+func (s *Scheduler) job(name string, index int, cron string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[key(name, index)]
+	if !ok {
+		job = &Job{Template: name, Index: index}
+		s.jobs[key(name, index)] = job
+	}
+	job.Cron = cron
+	return job
+}
+
+// Run выполняет расписание index шаблона name сейчас, не дожидаясь его
+// времени.
+func (s *Scheduler) Run(name string, index int) error {
+	repo, err := s.repo()
+	if err != nil {
+		return err
+	}
+	t, err := repo.Get(name)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(t.Schedules) {
+		return fmt.Errorf("%w: %s #%d", errors.ErrScheduleNotFound, name, index)
+	}
+	job := s.job(name, index, t.Schedules[index].Cron)
+	return s.start(job, t, index, time.Now())
+}
+
+// start выполняет расписание index шаблона t в фоне с моментом запуска at.
+func (s *Scheduler) start(job *Job, t templrepo.Template, index int, at time.Time) error {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+		s.mu.Unlock()
+		return errors.ErrSchedulerStopped
+	default:
+	}
+	if job.Running {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s #%d", errors.ErrJobRunning, t.Name, index)
+	}
+	job.Running = true
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-s.stop:
+			s.mu.Lock()
+			job.Running = false
+			s.mu.Unlock()
+			return
+		case s.sem <- struct{}{}:
+		}
+		defer func() { <-s.sem }()
+		s.run(job, t, t.Schedules[index], at)
+	}()
+	return nil
+}
+
+func (s *Scheduler) run(job *Job, t templrepo.Template, sch templrepo.Schedule, at time.Time) {
+	start := time.Now()
+	out, err := s.exec(t.Name, Args(t, sch, at))
+	var file string
+	if err == nil {
+		file, err = s.save(t.Name, job.Index, sch.Format, at, out)
+	}
+	d := time.Since(start).Round(time.Millisecond)
+
+	s.mu.Lock()
+	job.Running = false
+	job.LastRun, job.Duration, job.Rows, job.LastError = start, d.String(), 0, ""
+	if err != nil {
+		job.LastError = err.Error()
+	} else {
+		job.Rows, job.File = len(out.Rows), file
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		logger.Error(fmt.Sprintf("scheduler: %s: %v", t.Name, err))
+		return
+	}
+	logger.Info(fmt.Sprintf("scheduler: %s: %d rows in %s saved to %s", t.Name, len(out.Rows), d, file))
+}
+
+// Args возвращает аргументы выполнения расписания sch шаблона t в момент at:
+// выражения дат (см. Expand) заменяются датами - для параметров, объявленных
+// датой, меткой времени в миллисекундах, для остальных - текстом
+// "2006-01-02 15:04:05".
+func Args(t templrepo.Template, sch templrepo.Schedule, at time.Time) map[string]string {
+	dates := make(map[string]bool)
+	for _, p := range t.Params {
+		dates[p.Name] = p.Type == templrepo.ParamDate
+	}
+	args := make(map[string]string, len(sch.Args)+1)
+	for k, v := range sch.Args {
+		if tm, ok := Expand(v, at); ok {
+			if dates[k] {
+				v = strconv.FormatInt(tm.UnixMilli(), 10)
+			} else {
+				v = tm.Format(dateArg)
+			}
+		}
+		args[k] = v
+	}
+	if sch.DB != "" {
+		args[templrepo.ReservedParam] = sch.DB
+	}
+	return args
+}
+
+// Jobs возвращает состояние расписаний по имени шаблона и номеру расписания.
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	res := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		res = append(res, *job)
+	}
+	s.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Template != res[j].Template {
+			return res[i].Template < res[j].Template
+		}
+		return res[i].Index < res[j].Index
+	})
+	return res
+}
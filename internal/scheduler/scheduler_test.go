@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+	"robin2/internal/data"
+	"robin2/internal/errors"
+	"robin2/internal/templrepo"
+)
+
+func TestCron(t *testing.T) {
+	at := func(d, h, m int) time.Time { return time.Date(2024, 3, d, h, m, 0, 0, time.Local) }
+	cases := []struct {
+		cron string
+		now  time.Time
+		next time.Time
+	}{
+		{"5 8,20 * * *", at(10, 7, 30), at(10, 8, 5)},
+		{"5 8,20 * * *", at(10, 8, 5), at(10, 20, 5)},
+		{"*/15 * * * *", at(10, 7, 31), at(10, 7, 45)},
+		{"0 9 * * 1-5", at(9, 10, 0), at(11, 9, 0)}, // суббота - следующий понедельник
+		{"0 0 1 * *", at(10, 0, 0), time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local)},
+		{"@daily", at(10, 23, 59), at(11, 0, 0)},
+		{"0 0 31 2 *", at(10, 0, 0), time.Time{}},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.cron)
+		if err != nil {
+			t.Fatalf("%q: %v", c.cron, err)
+		}
+		if next := cron.Next(c.now); !next.Equal(c.next) {
+			t.Errorf("%q after %v: got %v, want %v", c.cron, c.now, next, c.next)
+		}
+	}
+	for _, s := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	now := time.Date(2024, 3, 10, 8, 5, 30, 0, time.Local)
+	tm := templrepo.Template{Meta: templrepo.Meta{Params: []templrepo.Param{{Name: "from", Type: templrepo.ParamDate}}}}
+	sch := templrepo.Schedule{DB: "plant", Args: map[string]string{"from": "today-1d+20h", "to": "Today+8h", "area": "today's"}}
+
+	args := Args(tm, sch, now)
+	from := time.Date(2024, 3, 9, 20, 0, 0, 0, time.Local).UnixMilli()
+	if args["from"] != strconv.FormatInt(from, 10) {
+		t.Fatalf("unexpected from %q", args["from"])
+	}
+	if args["to"] != "2024-03-10 08:00:00" || args["area"] != "today's" || args["db"] != "plant" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if tm, ok := Expand("now-1w", now); !ok || !tm.Equal(time.Date(2024, 3, 3, 8, 5, 0, 0, time.Local)) {
+		t.Fatalf("unexpected now-1w %v", tm)
+	}
+}
+
+func TestSaveSameSecond(t *testing.T) {
+	s := New(config.Scheduler{Dir: t.TempDir(), Format: "json"}, nil, nil)
+	at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+	out := &data.Output{Headers: []string{"v"}, Rows: [][]string{{"1"}}}
+	for i := range 2 {
+		if _, err := s.save("report", i, "", at, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := s.Results("report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || !res[0].Time.Equal(at) || res[0].File == res[1].File {
+		t.Fatalf("unexpected results %+v", res)
+	}
+	if _, err := s.Result("report", "20240301T100000_1.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	s := New(config.Scheduler{Dir: t.TempDir()}, func() (templrepo.Repo, error) { return nil, errors.ErrTemplatesUnavailable }, nil)
+	s.Start()
+	done := make(chan struct{})
+	for range 2 {
+		go func() {
+			s.Stop()
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+	tm := templrepo.Template{Name: "report", Meta: templrepo.Meta{Schedules: []templrepo.Schedule{{Cron: "* * * * *"}}}}
+	if err := s.start(s.job(tm.Name, 0, "* * * * *"), tm, 0, time.Now()); err != errors.ErrSchedulerStopped {
+		t.Fatalf("stopped scheduler must not start jobs, got %v", err)
+	}
+}
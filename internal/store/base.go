@@ -2,13 +2,16 @@ package store
 
 // todo: ? rebuild all funcs to return map[string]map[time.Time]float32
 // fix: rebuild all funcs to return []map[string]float32
-// bug: templates works on clickhouse only! rewrite logic and config for templates
 
 import (
+	"context"
 	"database/sql"
+	stderrors "errors"
 	"fmt"
+	"math"
 	"net"
 	"robin2/internal/errors"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,32 +20,297 @@ import (
 	"robin2/internal/config"
 	"robin2/internal/data"
 	"robin2/internal/logger"
+	"robin2/internal/trace"
 	"robin2/internal/utils"
-
-	"github.com/google/uuid"
 )
 
+// defaultFetchConcurrency - наибольшее число одновременно загружаемых
+// недостающих интервалов серий одного запроса.
+const defaultFetchConcurrency = 8
+
 type Base struct {
 	// Store
-	db            *sql.DB
+	p             *pools
+	mu            *sync.RWMutex
 	config        config.Config
 	cache         cache.Cache
+	fresh         cache.Cache
+	flight        *flight
+	refresh       *refresher
+	staleness     *Staleness
+	trace         *trace.Trace
+	readOnly      readOnlySession
+	name          string
+	setup         func(*sql.DB)
 	roundConstant float64
 	round         int
 }
 
-// GenerateConnectionString генерирует строку подключения на основе настроек конфигурации.
-//
-// Он извлекает строку подключения из конфигурации, используя имя базы данных, и заменяет все
-// заполнители в строке подключения соответствующими значениями из конфигурации.
-// Функция также устанавливает свойство 'round' экземпляра BaseStoreImpl на значение, указанное в
-// конфигурации.
-//
-// Возвращает сгенерированную строку подключения.
-func (s *Base) GenerateConnectionString(name string) string {
+// pools - пулы соединений хранилища и автомат отключения. Хранятся по
+// указателю под mu, чтобы копии хранилища (см. withTrace) видели
+// переподключения оригинала, а автомат можно было подключить к хранилищу,
+// уже обслуживающему запросы.
+type pools struct {
+	db      *sql.DB
+	replica *sql.DB
+	host    config.Host
+	breaker *Breaker
+}
+
+func newBase(cfg config.Config) Base {
+	return Base{
+		p:             &pools{},
+		mu:            &sync.RWMutex{},
+		flight:        newFlight(),
+		refresh:       newRefresher(),
+		config:        cfg,
+		roundConstant: math.Pow(10, float64(cfg.Round)),
+	}
+}
+
+// open запоминает параметры подключения и подключается к базе данных.
+// setup вызывается для каждого нового пула соединений (настройки лимитов и т.п.).
+func (s *Base) open(name string, cache cache.Cache, setup func(*sql.DB)) error {
+	s.mu.Lock()
+	s.name, s.cache, s.setup = name, cache, setup
+	s.mu.Unlock()
+	s.openFresh()
+	return s.Reconnect()
+}
+
+// Reconnect подключается к первому доступному серверу базы (основные, затем
+// реплики) и заменяет новым пулом текущий. Запросы, начатые на старом пуле,
+// завершаются на нём.
+func (s *Base) Reconnect() error {
+	var err error
+	for _, h := range s.config.CurrDB.Endpoints() {
+		var db *sql.DB
+		if db, err = s.dial(h); err != nil {
+			logger.Error(fmt.Sprintf("%s: host %s:%s is unavailable: %v", s.config.CurrDB.Name, h.Host, h.Port, err))
+			continue
+		}
+		s.swap(db, h)
+		s.connectReplica()
+		return nil
+	}
+	return err
+}
+
+// Failback возвращает подключение на более приоритетный сервер, если после
+// переключения он снова доступен, и переподключает отказавшую реплику.
+func (s *Base) Failback() error {
+	s.mu.RLock()
+	curr, replica := s.p.host, s.p.replica
+	s.mu.RUnlock()
+
+	for _, h := range s.config.CurrDB.Endpoints() {
+		if h == curr {
+			break
+		}
+		db, err := s.dial(h)
+		if err != nil {
+			continue
+		}
+		logger.Info(fmt.Sprintf("%s: failing back to %s:%s", s.config.CurrDB.Name, h.Host, h.Port))
+		s.swap(db, h)
+		s.connectReplica()
+		return nil
+	}
+
+	if s.config.CurrDB.ReplicaReadRange > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout())
+		defer cancel()
+		if replica == nil || replica.PingContext(ctx) != nil {
+			s.connectReplica()
+		}
+	}
+	return nil
+}
+
+// dial открывает пул соединений к серверу h и проверяет его ping.
+func (s *Base) dial(h config.Host) (*sql.DB, error) {
+	db, err := sql.Open(s.config.CurrDB.Type, s.connectionString(h))
+	if err != nil {
+		return nil, err
+	}
+	if s.setup != nil {
+		s.setup(db)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout())
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (s *Base) swap(db *sql.DB, h config.Host) {
+	s.mu.Lock()
+	old := s.p.db
+	s.p.db, s.p.host = db, h
+	s.mu.Unlock()
+	closeLater(old)
+	s.logConnection(h)
+}
+
+// connectReplica подключает пул для чтения длинных диапазонов к первой
+// доступной реплике, отличной от текущего сервера.
+func (s *Base) connectReplica() {
+	if s.config.CurrDB.ReplicaReadRange <= 0 {
+		return
+	}
+	s.mu.RLock()
+	curr := s.p.host
+	s.mu.RUnlock()
+
+	var replica *sql.DB
+	for _, h := range s.config.CurrDB.Endpoints() {
+		if h.Role != config.HostReplica || h == curr {
+			continue
+		}
+		db, err := s.dial(h)
+		if err != nil {
+			logger.Error(fmt.Sprintf("%s: replica %s:%s is unavailable: %v", s.config.CurrDB.Name, h.Host, h.Port, err))
+			continue
+		}
+		logger.Info(fmt.Sprintf("%s: long range reads go to replica %s:%s", s.config.CurrDB.Name, h.Host, h.Port))
+		replica = db
+		break
+	}
+
+	s.mu.Lock()
+	old := s.p.replica
+	s.p.replica = replica
+	s.mu.Unlock()
+	closeLater(old)
+}
+
+// closeLater закрывает пул в фоне: Close дожидается завершения начатых на нём запросов.
+func closeLater(db *sql.DB) {
+	if db == nil {
+		return
+	}
+	go func() {
+		if err := db.Close(); err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+}
+
+// Host возвращает адрес сервера, к которому подключено хранилище.
+func (s *Base) Host() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.p.host.Host == "" {
+		return ""
+	}
+	return s.p.host.Host + ":" + s.p.host.Port
+}
+
+// Close закрывает пулы соединений хранилища.
+func (s *Base) Close() error {
+	s.mu.Lock()
+	db, replica := s.p.db, s.p.replica
+	s.p.db, s.p.replica = nil, nil
+	s.mu.Unlock()
+	if s.fresh != nil {
+		if err := s.fresh.Disconnect(); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+	if replica != nil {
+		if err := replica.Close(); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+// withTrace возвращает копию хранилища, которая записывает SQL-запросы
+// и обращения к кэшу в трассировку t. Пулы соединений у копии общие с оригиналом.
+func (s *Base) withTrace(t *trace.Trace) Base {
+	c := *s
+	c.trace = t
+	c.cache = cache.WithTrace(s.cache, t, s.config.CurrCacheName)
+	c.fresh = cache.WithTrace(s.fresh, t, s.name+".fresh")
+	return c
+}
+
+// SetBreaker подключает автомат отключения, который проверяется перед каждым запросом к базе.
+func (s *Base) SetBreaker(b *Breaker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.p.breaker = b
+}
+
+func (s *Base) conn() *sql.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.p.db
+}
+
+// acquire возвращает пул соединений для запроса или ошибку, если база
+// недоступна: не подключена или разомкнут автомат отключения.
+func (s *Base) acquire() (*sql.DB, error) {
+	s.mu.RLock()
+	db, b := s.p.db, s.p.breaker
+	s.mu.RUnlock()
+	if err := b.Allow(); err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return nil, errors.ErrDbConnectionFailed
+	}
+	return db, nil
+}
+
+// reader возвращает пул для чтения диапазона from-to: диапазоны длиннее
+// replica_read_range направляются на реплику, если она подключена.
+func (s *Base) reader(from, to time.Time) (*sql.DB, error) {
+	db, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	if r := s.config.CurrDB.ReplicaReadRange; r > 0 && to.Sub(from) >= time.Duration(r)*time.Second {
+		s.mu.RLock()
+		replica := s.p.replica
+		s.mu.RUnlock()
+		if replica != nil {
+			return replica, nil
+		}
+	}
+	return db, nil
+}
+
+func (s *Base) timeout() time.Duration {
+	if s.config.CurrDB.Timeout > 0 {
+		return time.Duration(s.config.CurrDB.Timeout) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// GenerateConnectionString возвращает строку подключения к серверу host
+// текущей базы данных (см. config.Database.Endpoints). Если host пуст или не
+// входит в список серверов базы, строка строится для первого из них.
+func (s *Base) GenerateConnectionString(host string) string {
+	hosts := s.config.CurrDB.Endpoints()
+	for _, h := range hosts {
+		if h.Host == host {
+			return s.connectionString(h)
+		}
+	}
+	return s.connectionString(hosts[0])
+}
+
+// connectionString генерирует строку подключения к серверу h базы данных из конфигурации.
+func (s *Base) connectionString(h config.Host) string {
 	connStr := s.config.CurrDB.ConnectionString
-	connStr = strings.ReplaceAll(connStr, "{host}", s.config.CurrDB.Host)
-	connStr = strings.ReplaceAll(connStr, "{port}", s.config.CurrDB.Port)
+	connStr = strings.ReplaceAll(connStr, "{host}", h.Host)
+	connStr = strings.ReplaceAll(connStr, "{port}", h.Port)
 	connStr = strings.ReplaceAll(connStr, "{user}", s.config.CurrDB.User)
 	connStr = strings.ReplaceAll(connStr, "{password}", s.config.CurrDB.Password)
 	connStr = strings.ReplaceAll(connStr, "{database}", s.config.CurrDB.Database)
@@ -52,17 +320,14 @@ func (s *Base) GenerateConnectionString(name string) string {
 
 // logConnection записывает соединение с базой данных.
 //
-// Эта функция извлекает необходимую информацию из конфигурационного файла,
-// чтобы записать детали соединения. Она использует имя базы данных, чтобы получить
-// хост и порт из конфигурации. Затем она находит IP-адрес хоста и записывает
-// детали соединения вместе с полученными IP-адресами.
-func (s *Base) logConnection(_ string) {
-	dbConfig := s.config.CurrDB
-	ips := resolveIPs(dbConfig.Host)
+// Эта функция записывает тип базы данных, хост и порт сервера h,
+// к которому выполнено подключение, вместе с IP-адресами хоста.
+func (s *Base) logConnection(h config.Host) {
+	ips := resolveIPs(h.Host)
 
 	logger.Info(fmt.Sprintf(
-		"connecting to %s database on %s:%s (%s)",
-		dbConfig.Type, dbConfig.Host, dbConfig.Port, strings.Join(ips, ", "),
+		"connecting to %s database on %s:%s %s (%s)",
+		s.config.CurrDB.Type, h.Host, h.Port, h.Role, strings.Join(ips, ", "),
 	))
 }
 
@@ -95,9 +360,16 @@ func (s *Base) replaceTemplate(repMap map[string]string, query string) string {
 // Он возвращает две строки, представляющие версию и время работы,
 // а также ошибку, если возникла проблема при получении статуса.
 func (s *Base) GetStatus() (string, time.Duration, error) {
+	db := s.conn()
+	if db == nil {
+		return "", 0, errors.ErrDbConnectionFailed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout())
+	defer cancel()
+
 	var version string
 	var uptime time.Duration
-	err := s.db.QueryRow(s.config.CurrDB.Query["status"]).Scan(&version, &uptime)
+	err := db.QueryRowContext(ctx, s.config.CurrDB.Query["status"]).Scan(&version, &uptime)
 	if err != nil {
 		return "", 0, err
 	}
@@ -126,7 +398,15 @@ func (s *Base) GetTagDate(tag string, date time.Time) (*data.Tag, error) {
 	}
 
 	if err := s.fetchFromDatabase(tag, date, &currTag); err != nil {
-		return nil, err
+		if !s.staleAllowed(err) {
+			return nil, err
+		}
+		val, ok := s.staleDate(tag, date, err)
+		if !ok {
+			return nil, err
+		}
+		currTag.Value = val
+		return &currTag, nil
 	}
 
 	s.updateCache(currTag, date)
@@ -138,9 +418,6 @@ func (s *Base) validateInput(date time.Time) error {
 	if date.IsZero() {
 		return errors.ErrInvalidDate
 	}
-	if s.db == nil {
-		return errors.ErrDbConnectionFailed
-	}
 	return nil
 }
 
@@ -153,16 +430,31 @@ func (s *Base) initializeTag(tag string, date time.Time) data.Tag {
 }
 
 func (s *Base) getFromCache(tag string, date time.Time) (float32, error) {
-	if s.cache == nil {
+	c := s.cacheAt(date)
+	if c == nil {
 		return -1, errors.ErrCurrCacheNotAvailaible
 
 	}
-	return s.cache.Get(tag, date)
+	return c.Get(s.pointKey(tag), date)
 }
 
 func (s *Base) fetchFromDatabase(tag string, date time.Time, currTag *data.Tag) error {
+	db, err := s.acquire()
+	if err != nil {
+		return err
+	}
 	query := s.buildQuery(tag, date)
-	return s.db.QueryRow(query).Scan(&currTag.Name, &currTag.Date, &currTag.Value)
+	row, _, err := share(s, "row|"+query, query, func() (data.Tag, error) {
+		var t data.Tag
+		sp := s.trace.Start(trace.LayerDB, "query", s.name).Query(query)
+		err := db.QueryRow(query).Scan(&t.Name, &t.Date, &t.Value)
+		sp.Rows(utils.ThenIf(err == nil, 1, 0)).End(err)
+		return t, err
+	})
+	if err == nil {
+		*currTag = row
+	}
+	return err
 }
 
 func (s *Base) buildQuery(tag string, date time.Time) string {
@@ -174,14 +466,123 @@ func (s *Base) buildQuery(tag string, date time.Time) string {
 }
 
 func (s *Base) updateCache(tag data.Tag, date time.Time) {
-	if s.cache == nil || tag.Value == -1 {
+	c := s.cacheAt(date)
+	if c == nil || tag.Value == -1 {
+		return
+	}
+	if err := c.Set(s.pointKey(tag.Name), date, float32(tag.Value)); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// pointKey возвращает ключ кэша значений тега на дату. Вид значения
+// определяется запросом get_tag_date: он может интерполировать.
+func (s *Base) pointKey(tag string) cache.Key {
+	return cache.Key{Database: s.name, Kind: cache.PointKind(s.config.CurrDB.Query["get_tag_date"]), Tag: tag}
+}
+
+func (s *Base) seriesKey(tag string) cache.Key {
+	return cache.Key{Database: s.name, Kind: cache.KindSeries, Tag: tag}
+}
+
+func (s *Base) aggregateKey(tag string, from, to time.Time, group string) cache.AggregateKey {
+	return cache.AggregateKey{Database: s.name, Tag: tag, From: from, To: to, Group: group}
+}
+
+func (s *Base) getAggregate(key cache.AggregateKey) (float32, error) {
+	c := s.cacheAt(key.To)
+	if c == nil {
+		return -1, errors.ErrCurrCacheNotAvailaible
+	}
+	return c.GetAggregate(key)
+}
+
+func (s *Base) setAggregate(key cache.AggregateKey, val float32) {
+	c := s.cacheAt(key.To)
+	if c == nil {
 		return
 	}
-	if err := s.cache.Set(tag.Name, date, float32(tag.Value)); err != nil {
+	if err := c.SetAggregate(key, val); err != nil {
 		logger.Error(err.Error())
 	}
 }
 
+// cacheGroups группирует индексы значений по кэшу, в котором они хранятся
+// (основной или окна досылки, см. cacheAt). Значения без кэша пропускаются.
+func (s *Base) cacheGroups(n int, at func(i int) time.Time) map[cache.Cache][]int {
+	res := make(map[cache.Cache][]int)
+	for i := 0; i < n; i++ {
+		if c := s.cacheAt(at(i)); c != nil {
+			res[c] = append(res[c], i)
+		}
+	}
+	return res
+}
+
+// getMany читает значения тега на даты dates - по одному обращению к каждому кэшу.
+func (s *Base) getMany(tag string, dates []time.Time) ([]float32, []bool) {
+	values, found := make([]float32, len(dates)), make([]bool, len(dates))
+	for c, idx := range s.cacheGroups(len(dates), func(i int) time.Time { return dates[i] }) {
+		part := make([]time.Time, len(idx))
+		for j, i := range idx {
+			part[j] = dates[i]
+		}
+		v, f, err := c.GetMany(s.pointKey(tag), part)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		for j, i := range idx {
+			values[i], found[i] = v[j], f[j]
+		}
+	}
+	return values, found
+}
+
+func (s *Base) setMany(tag string, points []cache.Point) {
+	for c, idx := range s.cacheGroups(len(points), func(i int) time.Time { return points[i].Date }) {
+		part := make([]cache.Point, len(idx))
+		for j, i := range idx {
+			part[j] = points[i]
+		}
+		if err := c.SetMany(s.pointKey(tag), part); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
+// getAggregates читает агрегаты keys - по одному обращению к каждому кэшу.
+func (s *Base) getAggregates(keys []cache.AggregateKey) ([]float32, []bool) {
+	values, found := make([]float32, len(keys)), make([]bool, len(keys))
+	for c, idx := range s.cacheGroups(len(keys), func(i int) time.Time { return keys[i].To }) {
+		part := make([]cache.AggregateKey, len(idx))
+		for j, i := range idx {
+			part[j] = keys[i]
+		}
+		v, f, err := c.GetAggregates(part)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		for j, i := range idx {
+			values[i], found[i] = v[j], f[j]
+		}
+	}
+	return values, found
+}
+
+func (s *Base) setAggregates(keys []cache.AggregateKey, values []float32) {
+	for c, idx := range s.cacheGroups(len(keys), func(i int) time.Time { return keys[i].To }) {
+		partKeys, partValues := make([]cache.AggregateKey, len(idx)), make([]float32, len(idx))
+		for j, i := range idx {
+			partKeys[j], partValues[j] = keys[i], values[i]
+		}
+		if err := c.SetAggregates(partKeys, partValues); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
 // func (s *Base) cacheDay(tag string, day time.Time) {
 // 	to := day.AddDate(0, 0, 1)
 // 	s.GetTagFromToUncached(tag, day, to)
@@ -213,18 +614,44 @@ func (s *Base) GetTagCount(tag string, from time.Time, to time.Time, count int)
 	for i, t := range tags {
 		tags[i] = strings.TrimSpace(t)
 	}
+	if err := s.checkRequest(KindTagCount, len(tags), count, from, to); err != nil {
+		return nil, err
+	}
+	if err := s.validateInput(from); err != nil {
+		return nil, err
+	}
+	dates := make([]time.Time, count)
+	for i := range dates {
+		dates[i] = from.Add(time.Duration(tmDiff*float64(i)) * time.Second)
+	}
 	res := make(map[string]map[time.Time]float32, len(tags))
 	for _, t := range tags {
+		values, found := s.getMany(t, dates)
+		var fetched []cache.Point
 		resDt := make(map[time.Time]float32, count)
-		for i := 0; i < count; i++ {
-			dateFrom := from.Add(time.Duration(tmDiff*float64(i)) * time.Second)
-			valOut, err := s.GetTagDate(t, dateFrom)
-			if err != nil {
-				return nil, err
+		for i, date := range dates {
+			if !found[i] {
+				currTag := s.initializeTag(t, date)
+				if err := s.fetchFromDatabase(t, date, &currTag); err != nil {
+					val, ok := -float32(1), false
+					if s.staleAllowed(err) {
+						val, ok = s.staleDate(t, date, err)
+					}
+					if !ok {
+						return nil, err
+					}
+					values[i] = val
+					resDt[date] = val
+					continue
+				}
+				values[i] = currTag.Value
+				if currTag.Value != -1 {
+					fetched = append(fetched, cache.Point{Date: date, Value: currTag.Value})
+				}
 			}
-			val := valOut.Value
-			resDt[dateFrom] = float32(val)
+			resDt[date] = values[i]
 		}
+		s.setMany(t, fetched)
 		res[t] = resDt
 	}
 	return res, nil
@@ -256,59 +683,59 @@ func (s *Base) GetTagCountGroup(tag string, from time.Time, to time.Time, count
 	for i, t := range tags {
 		tags[i] = strings.TrimSpace(t)
 	}
+	if err := s.checkRequest(KindTagCountGroup, len(tags), count, from, to); err != nil {
+		return nil, err
+	}
+	group = strings.ToLower(group)
 	res := data.Tags{}
-
-	if group == "avgm" {
-		for _, t := range tags {
-			allPeriod := data.Tags{}
-			for i := 1; i <= count; i++ {
-
-				dateFrom := from.Add(time.Duration(tmDiff*float64(i-1)) * time.Second)
-				dateTo := from.Add(time.Duration(tmDiff*float64(i)) * time.Second)
-				fromStr := dateFrom.Format("2006-01-02 15:04:05")
-				toStr := dateTo.Format("2006-01-02 15:04:05")
-
-				val, err := s.cache.GetStr(t, fromStr+"|"+toStr+"|"+group)
-				if err != nil {
-					if len(allPeriod) == 0 {
-						allPeriod, err = s.GetTagFromTo(t, from, to)
-						if err != nil {
+	for _, t := range tags {
+		keys := make([]cache.AggregateKey, count)
+		for i := range keys {
+			dateFrom := from.Add(time.Duration(tmDiff*float64(i)) * time.Second)
+			dateTo := from.Add(time.Duration(tmDiff*float64(i+1)) * time.Second)
+			keys[i] = s.aggregateKey(t, dateFrom, dateTo, group)
+		}
+		values, found := s.getAggregates(keys)
+
+		var allPeriod data.Tags
+		loaded := false
+		var setKeys []cache.AggregateKey
+		var setValues []float32
+		for i, key := range keys {
+			if !found[i] {
+				var val float32
+				var err error
+				if group == "avgm" {
+					// avgm считается по сырым значениям за весь период, загруженным один раз
+					if !loaded {
+						if allPeriod, err = s.tagFromTo(KindTagCountGroup, []string{t}, from, to); err != nil {
 							return nil, err
 						}
+						loaded = true
 					}
-					val = allPeriod.GetFromTo(dateFrom, dateTo).Average(t)
-					if val != -1 {
-						err := s.cache.SetStr(t, fromStr+"|"+toStr+"|"+group, val)
-						if err != nil {
-							logger.Error(err.Error())
-						}
+					val = allPeriod.GetFromTo(key.From, key.To).Average(t)
+				} else if val, err = s.queryAggregate(KindTagCountGroup, key); err != nil {
+					if stderrors.Is(err, errors.ErrLimitExceeded) {
+						return nil, err
 					}
-				}
-				resDt := data.Tag{
-					Name:  t,
-					Date:  dateTo,
-					Value: val,
-				}
-				res = append(res, &resDt)
-			}
-		}
-	} else {
-		for _, t := range tags {
-			for i := 1; i <= count; i++ {
-				dateFrom := from.Add(time.Duration(tmDiff*float64(i-1)) * time.Second)
-				dateTo := from.Add(time.Duration(tmDiff*float64(i)) * time.Second)
-				val, err := s.GetTagFromToGroup(t, dateFrom, dateTo, group)
-				if err != nil {
 					val = -1
+					if s.staleAllowed(err) {
+						val, _ = s.staleAggregate(KindTagCountGroup, key, err)
+					}
 				}
-				resDt := data.Tag{
-					Name:  t,
-					Date:  dateTo,
-					Value: val,
+				if err == nil && val != -1 {
+					setKeys = append(setKeys, key)
+					setValues = append(setValues, val)
 				}
-				res = append(res, &resDt)
+				values[i] = val
 			}
+			res = append(res, &data.Tag{
+				Name:  t,
+				Date:  key.To,
+				Value: values[i],
+			})
 		}
+		s.setAggregates(setKeys, setValues)
 	}
 	return res, nil
 }
@@ -382,11 +809,189 @@ func (s *Base) GetTagFromTo(tag string, from time.Time, to time.Time) (data.Tags
 	for i, t := range tags {
 		tags[i] = strings.TrimSpace(t)
 	}
+	if err := s.checkRequest(KindTagFromTo, len(tags), 0, from, to); err != nil {
+		return nil, err
+	}
+	return s.tagFromTo(KindTagFromTo, tags, from, to)
+}
+
+// tagFromTo возвращает сырые значения тегов за диапазон from-to. Если подключён
+// кэш, из базы запрашиваются только интервалы, которых нет в кэше серий,
+// и результат объединяется с закэшированными значениями.
+func (s *Base) tagFromTo(kind string, tags []string, from time.Time, to time.Time) (data.Tags, error) {
+	limit := s.rowLimit(kind)
+	if s.cache == nil {
+		return s.fetchFromTo(limit, tags, from, to)
+	}
+
+	type part struct {
+		tag string
+		iv  cache.Interval
+	}
+	res := data.Tags{}
+	var parts []part
+	for _, t := range tags {
+		points, missing := s.getSeries(t, from, to)
+		for _, p := range points {
+			res = append(res, &data.Tag{Name: t, Date: p.Date, Value: p.Value})
+		}
+		for _, iv := range missing {
+			parts = append(parts, part{tag: t, iv: iv})
+		}
+	}
+
+	// данные после начала загрузки ещё могут поступить - их интервал не кэшируется
+	now := time.Now()
+	fetched := make([]data.Tags, len(parts))
+	errs := make([]error, len(parts))
+	sem := make(chan struct{}, s.fetchConcurrency())
+	var wg sync.WaitGroup
+	for i, p := range parts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, p part) {
+			defer func() { <-sem; wg.Done() }()
+			fetched[i], errs[i] = s.fetchFromTo(limit, []string{p.tag}, p.iv.From, p.iv.To)
+			// запрос может вернуть точки на границах интервала (BETWEEN,
+			// граничные точки ClickHouse): без обрезки точка на стыке
+			// интервалов повторяется, а ответ зависит от состояния кэша
+			fetched[i] = clipTags(fetched[i], p.iv)
+		}(i, p)
+	}
+	wg.Wait()
+
+	for i, p := range parts {
+		if errs[i] != nil {
+			if !s.staleAllowed(errs[i]) {
+				return nil, errs[i]
+			}
+			points, ok := s.staleSeries(kind, p.tag, p.iv, errs[i])
+			if !ok {
+				return nil, errs[i]
+			}
+			for _, pt := range points {
+				res = append(res, &data.Tag{Name: p.tag, Date: pt.Date, Value: pt.Value})
+			}
+			continue
+		}
+		res = append(res, fetched[i]...)
+		s.cacheSeries(p.tag, p.iv, fetched[i], now)
+	}
+
+	order := make(map[string]int, len(tags))
+	for i, t := range tags {
+		order[t] = i
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return order[res[i].Name] < order[res[j].Name]
+		}
+		return res[i].Date.Before(res[j].Date)
+	})
+	return res, nil
+}
+
+// fetchConcurrency - сколько недостающих интервалов загружается одновременно:
+// не больше пула соединений базы.
+func (s *Base) fetchConcurrency() int {
+	if n := s.config.CurrDB.MaxOpenConns; n > 0 && n < defaultFetchConcurrency {
+		return n
+	}
+	return defaultFetchConcurrency
+}
+
+// clipTags оставляет значения с датами из интервала [iv.From, iv.To).
+func clipTags(tags data.Tags, iv cache.Interval) data.Tags {
+	res := tags[:0]
+	for _, t := range tags {
+		if !t.Date.Before(iv.From) && t.Date.Before(iv.To) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// getSeries читает серию тега из основного кэша; интервалы, которых в нём
+// нет, дочитываются из кэша окна досылки.
+func (s *Base) getSeries(tag string, from, to time.Time) ([]cache.Point, []cache.Interval) {
+	key := s.seriesKey(tag)
+	points, missing, err := s.cache.GetSeries(key, from, to)
+	if err != nil {
+		logger.Error(err.Error())
+		points, missing = nil, []cache.Interval{{From: from, To: to}}
+	}
+	if s.fresh == nil || len(missing) == 0 {
+		return points, missing
+	}
+	var rest []cache.Interval
+	for _, iv := range missing {
+		fp, fm, err := s.fresh.GetSeries(key, iv.From, iv.To)
+		if err != nil {
+			logger.Error(err.Error())
+			rest = append(rest, iv)
+			continue
+		}
+		points = append(points, fp...)
+		rest = append(rest, fm...)
+	}
+	return points, rest
+}
+
+// cacheSeries сохраняет загруженные за интервал iv значения тега в кэш серий:
+// часть до начала окна досылки - в основной кэш, остальное - в кэш окна
+// досылки. Часть интервала после now не отмечается загруженной.
+func (s *Base) cacheSeries(tag string, iv cache.Interval, tags data.Tags, now time.Time) {
+	settle := s.settleFrom(now)
+	if iv.To.After(now) {
+		iv.To = now
+	}
+	settled, recent := iv, iv
+	if settled.To.After(settle) {
+		settled.To = settle
+	}
+	if recent.From.Before(settle) {
+		recent.From = settle
+	}
+	setSeries(s.cache, s.seriesKey(tag), settled, tags)
+	if s.fresh != nil {
+		setSeries(s.fresh, s.seriesKey(tag), recent, tags)
+	}
+}
+
+func setSeries(c cache.Cache, key cache.Key, iv cache.Interval, tags data.Tags) {
+	if !iv.From.Before(iv.To) {
+		return
+	}
+	points := make([]cache.Point, 0, len(tags))
+	for _, t := range tags {
+		if !t.Date.Before(iv.From) && t.Date.Before(iv.To) {
+			points = append(points, cache.Point{Date: t.Date, Value: t.Value})
+		}
+	}
+	if err := c.SetSeries(key, iv.From, iv.To, points); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// fetchFromTo читает из базы сырые значения тегов за диапазон from-to,
+// учитывая лимит строк limit.
+func (s *Base) fetchFromTo(limit *rowLimiter, tags []string, from time.Time, to time.Time) (data.Tags, error) {
+	db, err := s.reader(from, to)
+	if err != nil {
+		return nil, err
+	}
 
 	var wg sync.WaitGroup
 	res := data.Tags{}
 	resCh := make(chan *data.Tag, len(tags))
 	errCh := make(chan error, 1)
+	// sendErr сохраняет первую ошибку и не блокирует остальные горутины
+	sendErr := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
 
 	for _, t := range tags {
 		wg.Add(1)
@@ -399,47 +1004,27 @@ func (s *Base) GetTagFromTo(tag string, from time.Time, to time.Time) (data.Tags
 				"{to}":   to.Format("2006-01-02 15:04:05"),
 			}, s.config.CurrDB.Query["get_tag_from_to"])
 
-			rows, err := s.db.Query(query)
-			if err != nil {
-				errCh <- err
-				return
-			}
-			defer rows.Close()
-
-			// Получаем информацию о колонках для определения их количества
-			columns, err := rows.Columns()
+			// одинаковый запрос выполняется один раз с отдельным лимитом,
+			// строки затем учитываются в лимите каждого вызова
+			key := fmt.Sprintf("range|%s|%d|%s", limit.kind, limit.max, query)
+			rows, shared, err := share(s, key, query, func() (data.Tags, error) {
+				return s.queryRange(db, &rowLimiter{kind: limit.kind, max: limit.max}, t, query)
+			})
 			if err != nil {
-				errCh <- err
+				sendErr(err)
 				return
 			}
-
-			for rows.Next() {
-				currTag := &data.Tag{}
-
-				// Определяем количество колонок и сканируем соответственно
-				if len(columns) == 3 {
-					// ClickHouse: TagName, DateTime, Value
-					if err := rows.Scan(&currTag.Name, &currTag.Date, &currTag.Value); err != nil {
-						logger.Error(fmt.Sprintf("Error scanning 3 columns for tag %s: %v", t, err))
-						errCh <- err
-						return
-					}
-				} else if len(columns) == 2 {
-					// Другие БД: DateTime, Value (TagName берем из параметра)
-					if err := rows.Scan(&currTag.Date, &currTag.Value); err != nil {
-						logger.Error(fmt.Sprintf("Error scanning 2 columns for tag %s: %v", t, err))
-						errCh <- err
-						return
-					}
-					currTag.Name = t
-				} else {
-					err := fmt.Errorf("unexpected number of columns: %d, expected 2 or 3", len(columns))
-					logger.Error(err.Error())
-					errCh <- err
+			for _, r := range rows {
+				if err := limit.next(); err != nil {
+					sendErr(err)
 					return
 				}
-
-				resCh <- currTag
+				if shared {
+					// результат общий: вызовы не должны изменять теги друг друга
+					c := *r
+					r = &c
+				}
+				resCh <- r
 			}
 		}(t)
 	}
@@ -461,9 +1046,63 @@ func (s *Base) GetTagFromTo(tag string, from time.Time, to time.Time) (data.Tags
 	return res, nil
 }
 
+// queryRange выполняет запрос get_tag_from_to для тега t и возвращает его строки.
+func (s *Base) queryRange(db *sql.DB, limit *rowLimiter, t, query string) (data.Tags, error) {
+	sp := s.trace.Start(trace.LayerDB, "query", s.name).Query(query)
+	rows, err := db.Query(query)
+	if err != nil {
+		sp.End(err)
+		return nil, err
+	}
+	defer rows.Close()
+	res := data.Tags{}
+	defer func() { sp.Rows(len(res)).End(rows.Err()) }()
+
+	// Получаем информацию о колонках для определения их количества
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		if err := limit.next(); err != nil {
+			return nil, err
+		}
+		currTag := &data.Tag{}
+
+		// Определяем количество колонок и сканируем соответственно
+		if len(columns) == 3 {
+			// ClickHouse: TagName, DateTime, Value
+			if err := rows.Scan(&currTag.Name, &currTag.Date, &currTag.Value); err != nil {
+				logger.Error(fmt.Sprintf("Error scanning 3 columns for tag %s: %v", t, err))
+				return nil, err
+			}
+		} else if len(columns) == 2 {
+			// Другие БД: DateTime, Value (TagName берем из параметра)
+			if err := rows.Scan(&currTag.Date, &currTag.Value); err != nil {
+				logger.Error(fmt.Sprintf("Error scanning 2 columns for tag %s: %v", t, err))
+				return nil, err
+			}
+			currTag.Name = t
+		} else {
+			err := fmt.Errorf("unexpected number of columns: %d, expected 2 or 3", len(columns))
+			logger.Error(err.Error())
+			return nil, err
+		}
+
+		res = append(res, currTag)
+	}
+	return res, rows.Err()
+}
+
 func (s *Base) GetTagFromToUncached(tag string, from time.Time, to time.Time) (data.Tags, error) {
 	//	logger.Debug(fmt.Sprintf("GetTagFromToUncached %s : %s - %s", tag, from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")))
 
+	db, err := s.reader(from, to)
+	if err != nil {
+		return nil, err
+	}
+
 	tags := strings.Split(tag, ",")
 	for i, t := range tags {
 		tags[i] = strings.TrimSpace(t)
@@ -479,7 +1118,7 @@ func (s *Base) GetTagFromToUncached(tag string, from time.Time, to time.Time) (d
 			"{to}":   to.Format("2006-01-02 15:04:05"),
 		}, s.config.CurrDB.Query["get_tag_from_to"])
 
-		rows, err := s.db.Query(query)
+		rows, err := db.Query(query)
 		if err != nil {
 			return nil, err
 		}
@@ -517,10 +1156,7 @@ func (s *Base) GetTagFromToUncached(tag string, from time.Time, to time.Time) (d
 			// 	Date:  date,
 			// 	Value: val,
 			// }
-			err = s.cache.Set(tagName, date, val)
-			if err != nil {
-				logger.Error(err.Error())
-			}
+			s.updateCache(data.Tag{Name: tagName, Date: date, Value: val}, date)
 			// res = append(res, &currTag)
 		}
 
@@ -544,13 +1180,36 @@ func (s *Base) GetTagFromToUncached(tag string, from time.Time, to time.Time) (d
 func (s *Base) GetTagFromToGroup(tag string, from time.Time, to time.Time, group string) (float32, error) {
 	// logger.Debug(fmt.Sprintf("GetTagFromTo %s: %s - %s (%s)", tag, from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05"), group))
 
-	group = strings.ToLower(group)
-	var query string
+	if err := s.checkRange(KindTagFromToGroup, from, to); err != nil {
+		return -1, err
+	}
+	return s.getTagFromToGroup(KindTagFromToGroup, tag, from, to, group)
+}
 
-	fromStr, toStr := from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")
-	if val, err := s.cache.GetStr(tag, fromStr+"|"+toStr+"|"+group); err == nil {
+func (s *Base) getTagFromToGroup(kind string, tag string, from time.Time, to time.Time, group string) (float32, error) {
+	key := s.aggregateKey(tag, from, to, strings.ToLower(group))
+	if val, err := s.getAggregate(key); err == nil {
 		return val, nil
 	}
+	val, err := s.queryAggregate(kind, key)
+	if err == nil && val != -1 {
+		s.setAggregate(key, val)
+	}
+	if s.staleAllowed(err) {
+		if v, ok := s.staleAggregate(kind, key, err); ok {
+			return v, nil
+		}
+	}
+	return val, err
+}
+
+// queryAggregate вычисляет агрегат key по базе данных, не обращаясь к кэшу агрегатов.
+// Если в периоде нет значений, возвращает -1.
+func (s *Base) queryAggregate(kind string, key cache.AggregateKey) (float32, error) {
+	tag, from, to, group := key.Tag, key.From, key.To, key.Group
+	var query string
+
+	fromStr, toStr := from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")
 
 	switch group {
 	case "avg", "sum", "min", "max":
@@ -563,16 +1222,11 @@ func (s *Base) GetTagFromToGroup(tag string, from time.Time, to time.Time, group
 		query = s.config.CurrDB.Query["get_tag_from_to_group_count"]
 
 	case "avgm":
-		t, err := s.GetTagFromTo(tag, from, to)
+		t, err := s.tagFromTo(kind, []string{tag}, from, to)
 		if err != nil {
 			return -1, err
 		}
-		val := t.Average(tag)
-		err = s.cache.SetStr(tag, fromStr+"|"+toStr+"|"+group, val)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		return val, nil
+		return t.Average(tag), nil
 
 	default:
 		return -1, errors.ErrGroupError
@@ -584,9 +1238,18 @@ func (s *Base) GetTagFromToGroup(tag string, from time.Time, to time.Time, group
 		return -1, errors.ErrGroupError
 	}
 
-	var value sql.NullFloat64
-	row := s.db.QueryRow(query)
-	err := row.Scan(&value)
+	db, err := s.reader(from, to)
+	if err != nil {
+		return -1, err
+	}
+
+	value, _, err := share(s, "agg|"+query, query, func() (sql.NullFloat64, error) {
+		var value sql.NullFloat64
+		sp := s.trace.Start(trace.LayerDB, "query", s.name).Query(query)
+		err := db.QueryRow(query).Scan(&value)
+		sp.Rows(utils.ThenIf(err == nil, 1, 0)).End(err)
+		return value, err
+	})
 
 	if err != nil {
 		return -1, err
@@ -596,11 +1259,6 @@ func (s *Base) GetTagFromToGroup(tag string, from time.Time, to time.Time, group
 		return -1, nil
 	}
 
-	err = s.cache.SetStr(tag, fromStr+"|"+toStr+"|"+group, float32(value.Float64))
-	if err != nil {
-		logger.Error(err.Error())
-	}
-
 	return float32(value.Float64), nil
 }
 
@@ -613,6 +1271,7 @@ func (s *Base) GetTagFromToGroup(tag string, from time.Time, to time.Time, group
 // - *data.Output: Список тегов.
 // - error: Ошибка, если запрос к базе данных не выполнен.
 func (s *Base) GetTagList(like string) (*data.Output, error) {
+	out := &data.Output{}
 	if like == "" {
 		like = "%"
 	}
@@ -620,20 +1279,26 @@ func (s *Base) GetTagList(like string) (*data.Output, error) {
 	query := s.config.CurrDB.Query["get_tag_list"]
 	// replace {tag} with like
 	query = strings.Replace(query, "{tag}", like, -1)
+	db, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
 	// tags := make([]string, 0, 15000)
-	rows, err := s.db.Query(query)
+	sp := s.trace.Start(trace.LayerDB, "query", s.name).Query(query)
+	rows, err := db.Query(query)
+	if err != nil {
+		sp.End(err)
+		logger.Debug(err.Error())
+		return nil, err
+	}
+	defer func() { sp.Rows(len(out.Rows)).End(rows.Err()) }()
 	defer func() {
 		err := rows.Close()
 		if err != nil {
 			logger.Debug(err.Error())
 		}
 	}()
-	if err != nil {
-		logger.Debug(err.Error())
-		return nil, err
-	}
 
-	out := &data.Output{}
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, err
@@ -646,7 +1311,11 @@ func (s *Base) GetTagList(like string) (*data.Output, error) {
 		row[i] = new(sql.RawBytes)
 	}
 
+	limit := s.rowLimit(KindTagList)
 	for rows.Next() {
+		if err := limit.next(); err != nil {
+			return nil, err
+		}
 		err = rows.Scan(row...)
 		if err != nil {
 			return nil, err
@@ -676,6 +1345,9 @@ func (s *Base) GetTagList(like string) (*data.Output, error) {
 func (s *Base) GetDownDates(tag string, from time.Time, to time.Time) ([]time.Time, error) {
 	logger.Debug("GetDownDate " + tag + " : " + from.Format("2006-01-02 15:04:05") + " - " + to.Format("2006-01-02 15:04:05"))
 	var query string
+	if err := s.checkRange(KindDownDates, from, to); err != nil {
+		return nil, err
+	}
 	query = s.config.CurrDB.Query["get_down_dates"]
 	fromStr := from.Format("2006-01-02 15:04:05")
 	toStr := to.Format("2006-01-02 15:04:05")
@@ -683,13 +1355,25 @@ func (s *Base) GetDownDates(tag string, from time.Time, to time.Time) ([]time.Ti
 	if query == "" {
 		return nil, errors.ErrQueryError
 	}
+	db, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
 	var dates []time.Time
-	cur, err := s.db.Query(query)
+	limit := s.rowLimit(KindDownDates)
+	sp := s.trace.Start(trace.LayerDB, "query", s.name).Query(query)
+	cur, err := db.Query(query)
 	if err != nil {
+		sp.End(err)
 		logger.Debug(err.Error())
 		return nil, err
 	} else {
+		defer func() { sp.Rows(len(dates)).End(cur.Err()) }()
 		for cur.Next() {
+			if err := limit.next(); err != nil {
+				_ = cur.Close()
+				return nil, err
+			}
 			var date time.Time
 			err := cur.Scan(&date)
 			if err != nil {
@@ -722,6 +1406,9 @@ func (s *Base) GetDownDates(tag string, from time.Time, to time.Time) ([]time.Ti
 func (s *Base) GetUpDates(tag string, from time.Time, to time.Time) ([]time.Time, error) {
 	logger.Debug("GetUpDate " + tag + " : " + from.Format("2006-01-02 15:04:05") + " - " + to.Format("2006-01-02 15:04:05"))
 	var query string
+	if err := s.checkRange(KindUpDates, from, to); err != nil {
+		return nil, err
+	}
 	query = s.config.CurrDB.Query["get_up_dates"]
 	fromStr := from.Format("2006-01-02 15:04:05")
 	toStr := to.Format("2006-01-02 15:04:05")
@@ -729,13 +1416,25 @@ func (s *Base) GetUpDates(tag string, from time.Time, to time.Time) ([]time.Time
 	if query == "" {
 		return nil, errors.ErrQueryError
 	}
+	db, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
 	var dates []time.Time
-	cur, err := s.db.Query(query)
+	limit := s.rowLimit(KindUpDates)
+	sp := s.trace.Start(trace.LayerDB, "query", s.name).Query(query)
+	cur, err := db.Query(query)
 	if err != nil {
+		sp.End(err)
 		logger.Debug(err.Error())
 		return nil, err
 	} else {
+		defer func() { sp.Rows(len(dates)).End(cur.Err()) }()
 		for cur.Next() {
+			if err := limit.next(); err != nil {
+				_ = cur.Close()
+				return nil, err
+			}
 			var date time.Time
 			err := cur.Scan(&date)
 			if err != nil {
@@ -755,150 +1454,55 @@ func (s *Base) GetUpDates(tag string, from time.Time, to time.Time) ([]time.Time
 	return dates, nil
 }
 
-// TemplateGet получает тело шаблона по его имени.
-//
-// Параметры:
-// - name: имя шаблона.
-//
-// Возвращает:
-// - string: тело шаблона.
-// - error: ошибка, если шаблон не может быть найден или происходит ошибка при получении.
-func (s *Base) TemplateGet(name string) (string, error) {
-	var body string
-	err := s.db.QueryRow("SELECT t.Body from runtime.templates t where t.Name = '" + name + "'").Scan(&body)
-	if err != nil {
-		return "", err
-	}
-	return body, nil
+// Pool возвращает пул соединений с базой для служебных запросов, например
+// к таблице шаблонов (см. templrepo).
+func (s *Base) Pool() (*sql.DB, error) {
+	return s.acquire()
 }
 
-// TemplateExec выполняет шаблон с заданным именем и параметрами.
-//
-// Он заменяет заполнители в теле шаблона значениями из карты params и затем
-// выполняет полученный SQL-запрос с помощью базового подключения к базе данных.
-// Возвращает результат в виде строки.
-//
-// Параметры:
-//   - name: имя шаблона для выполнения.
-//   - params: карта пар ключ-значение, представляющая параметры для замены
-//     в теле шаблона.
-//
-// Возвращает:
-// - string: результат выполнения шаблона.
-// - error: ошибка, если произошла во время выполнения.
-func (s *Base) TemplateExec(name string, params map[string]string) (*data.Output, error) {
-	body, err := s.TemplateGet(name)
-	if err != nil {
-		return nil, err
-	}
-
-	for k, v := range params {
-		body = strings.Replace(body, "{"+k+"}", v, -1)
-	}
-
-	// todo: add cache
-
-	dbName := utils.ThenIf(params["db"] != "", params["db"], s.config.CurrDB.Name)
-	// var storedb *Store
-	// if dbName != s.config.CurrDB.Name {
-	storedb, err := New(s.config)
-	if err != nil {
-		return nil, err
-	}
-	err = storedb.Connect(dbName, nil)
-	if err != nil {
-		return nil, err
-	}
-	// } else {
-	// 	storedb = &s
-	// }
-
-	rows, err := storedb.ExecQuery(body)
-	if err != nil {
+// ExecQuery выполняет запрос только на чтение: одну инструкцию, прошедшую
+// CheckReadOnly, в сессии только для чтения диалекта (см. readOnly).
+func (s *Base) ExecQuery(query string, args ...any) (*data.Output, error) {
+	if err := CheckReadOnly(query); err != nil {
 		return nil, err
 	}
+	return s.execQuery(query, args, true)
+}
 
-	return rows, nil
+// ExecPrivileged выполняет запрос без проверок и вне сессии только для
+// чтения. Только для шаблонов, явно отмеченных привилегированными.
+func (s *Base) ExecPrivileged(query string, args ...any) (*data.Output, error) {
+	return s.execQuery(query, args, false)
 }
 
-// TemplateList получает карту имен и тел шаблонов на основе заданного шаблона.
-//
-// Параметр `like` используется для указания шаблона для сопоставления имен шаблонов. Если `like` является пустой строкой, шаблон по умолчанию устанавливается на "%".
-// Функция возвращает карту map[string]string, содержащую имена шаблонов в качестве ключей и их тела в качестве значений.
-// Если произошла ошибка при выполнении запроса к базе данных, функция возвращает nil и ошибку.
-func (s *Base) TemplateList(like string) (map[string]string, error) {
-	tmpl := map[string]string{}
-	if like == "" {
-		like = "%"
-	}
-	q := fmt.Sprintf("SELECT Name, Body FROM runtime.templates WHERE Name LIKE '%s%%'", like)
-	rows, err := s.db.Query(q)
+func (s *Base) execQuery(query string, args []any, readOnly bool) (*data.Output, error) {
+	db, err := s.acquire()
 	if err != nil {
 		return nil, err
 	}
-	for rows.Next() {
-		var name, body string
-		err := rows.Scan(&name, &body)
-		if err != nil {
+	sp := s.trace.Start(trace.LayerDB, "query", s.name).Query(query)
+	var q querier = db
+	if readOnly {
+		session := s.readOnly
+		if session == nil {
+			session = rollbackSession
+		}
+		var done func()
+		if q, done, err = session(context.Background(), db); err != nil {
+			sp.End(err)
 			return nil, err
 		}
-		tmpl[name] = body
+		defer done()
 	}
-	return tmpl, nil
-}
-
-// TemplateSet обновляет тело шаблона с указанным именем в таблице runtime.templates.
-//
-// Параметры:
-// - name: имя шаблона.
-// - body: новое тело шаблона.
-// Возвращает:
-// - error: если произошла ошибка при обновлении шаблона.
-func (s *Base) TemplateSet(name string, body string) error {
-	_, err := s.db.Exec("UPDATE runtime.templates SET Body = ? WHERE Name = ?", body, name)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// TemplateDel удаляет шаблон по имени из BaseStoreImpl.
-//
-// name - Имя шаблона, который должен быть удален.
-// error - Возвращает ошибку, если удаление не удалось.
-func (s *Base) TemplateDel(name string) error {
-	// _, err := s.db.Exec("DELETE FROM runtime.templates WHERE Name = ?", name)
-	_, err := s.db.Exec("ALTER TABLE runtime.templates DELETE WHERE Name = ?", name)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// TemplateAdd добавляет новый шаблон в BaseStoreImpl.
-//
-// Принимает два параметра:
-// - name: строка, представляющая имя шаблона.
-// - body: строка, представляющая тело шаблона.
-//
-// Возвращает ошибку, указывающую на любые проблемы, возникшие во время операции.
-func (s *Base) TemplateAdd(name string, body string) error {
-	id := uuid.New().String()
-	_, err := s.db.Exec("INSERT INTO runtime.templates (ID, Name, Body) VALUES (?, ?, ?)", id, name, body)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *Base) ExecQuery(query string) (*data.Output, error) {
-	rows, err := s.db.Query(query)
+	rows, err := q.QueryContext(context.Background(), query, args...)
 	if err != nil {
+		sp.End(err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	out := &data.Output{}
+	defer func() { sp.Rows(len(out.Rows)).End(rows.Err()) }()
 
 	cols, err := rows.Columns()
 	if err != nil {
@@ -912,7 +1516,11 @@ func (s *Base) ExecQuery(query string) (*data.Output, error) {
 		row[i] = new(sql.RawBytes)
 	}
 
+	limit := s.rowLimit(KindQuery)
 	for rows.Next() {
+		if err := limit.next(); err != nil {
+			return nil, err
+		}
 		err = rows.Scan(row...)
 		if err != nil {
 			return nil, err
@@ -1,11 +1,10 @@
 package store
 
 import (
-	"database/sql"
-	"math"
 	"robin2/internal/cache"
 	"robin2/internal/config"
 	"robin2/internal/logger"
+	"robin2/internal/trace"
 
 	_ "github.com/ClickHouse/clickhouse-go/v2"
 )
@@ -20,42 +19,26 @@ type Clickhouse struct {
 
 func NewClickhouse(cfg config.Config) (Store, error) {
 	logger.Debug("NewClickHouseStore")
-	round := cfg.Round
-	p := math.Pow(10, float64(round))
 	t := Clickhouse{
-		Base: Base{
-			roundConstant: p,
-			config:        cfg,
-		},
+		Base: newBase(cfg),
 	}
+	t.readOnly = clickhouseReadOnly
 	return &t, nil
 }
 
 func (s *Clickhouse) Connect(name string, cache cache.Cache) error {
 	logger.Debug("ClickHouseStoreImpl.Connect")
+	return s.open(name, cache, nil)
+}
 
-	if s.db != nil {
-		if err := s.db.Close(); err != nil {
-			logger.Error(err.Error())
-			return err
-		}
-	}
-
-	s.cache = cache
-
-	var err error
-	s.db, err = sql.Open(s.config.CurrDB.Type, s.GenerateConnectionString(name))
-	if err != nil {
-		logger.Error(err.Error())
-		return err
-	}
-
-	if err = s.db.Ping(); err != nil {
-		logger.Error(err.Error())
-		return err
-	}
-
-	s.logConnection(name)
+func (s *Clickhouse) WithTrace(t *trace.Trace) Store {
+	c := *s
+	c.Base = s.withTrace(t)
+	return &c
+}
 
-	return nil
+func (s *Clickhouse) WithStale(st *Staleness) Store {
+	c := *s
+	c.Base = s.withStale(st)
+	return &c
 }
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+)
+
+// hostsDriver - драйвер, строка подключения которого - адрес сервера:
+// подключение к серверу из down завершается ошибкой. Хранилище открывает
+// пулы по имени драйвера (см. Base.dial), поэтому драйвер регистрируется.
+type hostsDriver struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+var testHosts = &hostsDriver{down: make(map[string]bool)}
+
+func init() {
+	sql.Register("hoststest", testHosts)
+}
+
+func (d *hostsDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down[dsn] {
+		return nil, errors.New("connection refused")
+	}
+	return hostConn{}, nil
+}
+
+// setDown делает недоступными серверы hosts, остальные - доступными.
+func (d *hostsDriver) setDown(hosts ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.down)
+	for _, h := range hosts {
+		d.down[h] = true
+	}
+}
+
+type hostConn struct{}
+
+func (hostConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (hostConn) Close() error                        { return nil }
+func (hostConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestFailover(t *testing.T) {
+	s := newBase(config.Config{CurrDB: &config.Database{
+		Name: "db", Type: "hoststest", ConnectionString: "{host}", Port: "1", Timeout: 1, ReplicaReadRange: 3600,
+		Hosts: []config.Host{
+			{Host: "127.0.0.3", Role: config.HostReplica},
+			{Host: "127.0.0.2", Priority: 2},
+			{Host: "127.0.0.1", Priority: 1},
+		},
+	}})
+	s.name = "db"
+	t.Cleanup(func() {
+		testHosts.setDown()
+		_ = s.Close()
+	})
+
+	// основной сервер недоступен - подключение к следующему
+	testHosts.setDown("127.0.0.1")
+	if err := s.Reconnect(); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+	if h := s.Host(); h != "127.0.0.2:1" {
+		t.Fatalf("expected failover to 127.0.0.2:1, got %s", h)
+	}
+
+	// длинные диапазоны читаются с реплики
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	long, err := s.reader(from, from.Add(2*time.Hour))
+	if err != nil || long == nil || long != s.p.replica {
+		t.Fatalf("long range must be read from the replica (%v)", err)
+	}
+	if short, err := s.reader(from, from.Add(time.Minute)); err != nil || short != s.p.db {
+		t.Fatalf("short range must be read from the primary (%v)", err)
+	}
+
+	// возврат на более приоритетный сервер
+	testHosts.setDown("127.0.0.2")
+	if err := s.Failback(); err != nil {
+		t.Fatalf("Failback: %v", err)
+	}
+	if h := s.Host(); h != "127.0.0.1:1" {
+		t.Fatalf("expected failback to 127.0.0.1:1, got %s", h)
+	}
+	if err := s.Failback(); err != nil || s.Host() != "127.0.0.1:1" {
+		t.Fatalf("failback must keep the most preferred host, got %s (%v)", s.Host(), err)
+	}
+
+	// все серверы недоступны
+	testHosts.setDown("127.0.0.1", "127.0.0.2", "127.0.0.3")
+	if err := s.Reconnect(); err == nil {
+		t.Fatal("Reconnect must fail when every host is down")
+	}
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"sync"
+
+	"robin2/internal/errors"
+	"robin2/internal/trace"
+)
+
+// flight объединяет одновременные одинаковые запросы к базе данных: пока
+// запрос с ключом key выполняется, остальные вызовы с тем же ключом не идут
+// в базу, а ждут и получают его результат. Результат не запоминается -
+// следующий вызов после завершения снова выполняет запрос.
+type flight struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+	// wait, если задана, вызывается перед ожиданием чужого вызова (для тестов)
+	wait func(key string)
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+func newFlight() *flight {
+	return &flight{calls: make(map[string]*flightCall)}
+}
+
+// do выполняет fn или ждёт уже выполняемый вызов с тем же ключом.
+// shared - результат получен от другого вызова.
+func (g *flight) do(key string, fn func() (interface{}, error)) (val interface{}, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		if g.wait != nil {
+			g.wait(key)
+		}
+		<-c.done
+		return c.val, true, c.err
+	}
+	// если fn завершится паникой, ожидающие получат эту ошибку
+	c := &flightCall{done: make(chan struct{}), err: errors.ErrQueryAborted}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+	return c.val, false, c.err
+}
+
+// share выполняет запрос fn с ключом key (вид и текст запроса) один раз для
+// всех одновременных вызовов в той же базе данных. Ожидание чужого запроса
+// записывается в трассировку как шаг shared.
+func share[T any](s *Base, key, query string, fn func() (T, error)) (T, bool, error) {
+	v, shared, err := s.flight.do(s.name+"|"+key, func() (interface{}, error) {
+		return fn()
+	})
+	if shared {
+		s.trace.Start(trace.LayerDB, "shared", s.name).Query(query).End(err)
+	}
+	res, _ := v.(T)
+	return res, shared, err
+}
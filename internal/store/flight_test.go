@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+)
+
+// runShared запускает n одновременных вызовов call и отпускает первый, только
+// когда остальные n-1 ждут его результата: started сообщает о начале первого
+// вызова, ожидание остальных - перехват flight.wait.
+func runShared(t *testing.T, g *flight, n int, started <-chan struct{}, release chan<- struct{}, call func() error) {
+	t.Helper()
+	waiting := make(chan struct{}, n)
+	g.wait = func(string) { waiting <- struct{}{} }
+	t.Cleanup(func() { g.wait = nil })
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := call(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	timeout := time.After(5 * time.Second)
+	select {
+	case <-started:
+	case <-timeout:
+		t.Fatal("query is not started")
+	}
+	for i := 1; i < n; i++ {
+		select {
+		case <-waiting:
+		case <-timeout:
+			t.Fatalf("%d of %d calls are waiting", i-1, n-1)
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestFlightShared(t *testing.T) {
+	g := newFlight()
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	var calls, shared atomic.Int32
+	fn := func() (interface{}, error) {
+		calls.Add(1)
+		started <- struct{}{}
+		<-release
+		return 42, nil
+	}
+
+	const n = 20
+	runShared(t, g, n, started, release, func() error {
+		v, sh, err := g.do("q", fn)
+		if err != nil || v != 42 {
+			return errors.New("expected 42")
+		}
+		if sh {
+			shared.Add(1)
+		}
+		return nil
+	})
+
+	if calls.Load() != 1 {
+		t.Fatalf("query must run once, ran %d times", calls.Load())
+	}
+	if shared.Load() != n-1 {
+		t.Fatalf("expected %d shared results, got %d", n-1, shared.Load())
+	}
+	if _, sh, _ := g.do("q", func() (interface{}, error) { return 1, nil }); sh {
+		t.Fatal("finished query must not be shared")
+	}
+}
+
+func TestStoreSharedQueries(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	cases := []struct {
+		name string
+		call func(s *Base) error
+	}{
+		{"GetTagFromToGroup", func(s *Base) error {
+			v, err := s.GetTagFromToGroup("a", from, to, "avg")
+			if err == nil && v != 1.5 {
+				err = errors.New("unexpected aggregate")
+			}
+			return err
+		}},
+		{"GetTagFromTo", func(s *Base) error {
+			rows, err := s.GetTagFromTo("a", from, to)
+			if err == nil && len(rows) != 1 {
+				err = errors.New("unexpected rows")
+			}
+			return err
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := &countingDB{started: make(chan struct{}, 1), release: make(chan struct{})}
+			s := newBase(config.Config{CurrDB: &config.Database{Name: "db", Query: map[string]string{
+				"get_tag_from_to_group": "select {group}(v) from h where t = '{tag}' and d >= '{from}' and d < '{to}'",
+				"get_tag_from_to":       "select d, v from h where t = '{tag}' and d >= '{from}' and d < '{to}'",
+			}}})
+			s.name, s.p.db = "db", sql.OpenDB(db)
+			t.Cleanup(func() { _ = s.p.db.Close() })
+
+			runShared(t, s.flight, 10, db.started, db.release, func() error { return c.call(&s) })
+			if n := db.queries.Load(); n != 1 {
+				t.Fatalf("expected a single database query, got %d", n)
+			}
+		})
+	}
+}
+
+// countingDB - база данных, которая считает запросы и отвечает на каждый
+// после release: агрегатом 1.5 или одной строкой значения.
+type countingDB struct {
+	queries atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *countingDB) Connect(context.Context) (driver.Conn, error) { return countingConn{d}, nil }
+func (d *countingDB) Driver() driver.Driver                        { return nil }
+
+type countingConn struct{ d *countingDB }
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	return countingStmt{d: c.d, query: query}, nil
+}
+func (countingConn) Close() error              { return nil }
+func (countingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type countingStmt struct {
+	d     *countingDB
+	query string
+}
+
+func (countingStmt) Close() error  { return nil }
+func (countingStmt) NumInput() int { return -1 }
+func (countingStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s countingStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.d.queries.Add(1)
+	select {
+	case s.d.started <- struct{}{}:
+	default:
+	}
+	<-s.d.release
+	if strings.Contains(s.query, "avg(") {
+		return &countingRows{columns: []string{"v"}, row: []driver.Value{1.5}}, nil
+	}
+	return &countingRows{columns: []string{"d", "v"}, row: []driver.Value{time.Now(), 2.0}}, nil
+}
+
+type countingRows struct {
+	columns []string
+	row     []driver.Value
+	done    bool
+}
+
+func (r *countingRows) Columns() []string { return r.columns }
+func (r *countingRows) Close() error      { return nil }
+
+func (r *countingRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
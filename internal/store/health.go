@@ -0,0 +1,350 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"robin2/internal/cache"
+	"robin2/internal/config"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+)
+
+const (
+	defaultHealthInterval   = 10 * time.Second
+	defaultMaxBackoff       = 5 * time.Minute
+	defaultBreakerThreshold = 2
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed BreakerState = "closed"
+	BreakerOpen   BreakerState = "open"
+)
+
+// Breaker - автомат отключения запросов к базе данных.
+//
+// Пока автомат разомкнут, хранилище сразу возвращает ErrDbUnavailable,
+// не дожидаясь таймаутов драйвера. Состоянием управляет HealthMonitor
+// по результатам проверок запросом status.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	state     BreakerState
+	lastErr   error
+}
+
+func NewBreaker(threshold int) *Breaker {
+	if threshold < 1 {
+		threshold = defaultBreakerThreshold
+	}
+	return &Breaker{threshold: threshold, state: BreakerClosed}
+}
+
+// Allow возвращает ошибку, если автомат разомкнут. Nil-автомат пропускает всё.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		return fmt.Errorf("%w: %v", errors.ErrDbUnavailable, b.lastErr)
+	}
+	return nil
+}
+
+// Success замыкает автомат. Возвращает true, если до этого он был разомкнут.
+func (b *Breaker) Success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recovered := b.state == BreakerOpen
+	b.failures = 0
+	b.lastErr = nil
+	b.state = BreakerClosed
+	return recovered
+}
+
+// Failure учитывает неудачную проверку и размыкает автомат после threshold
+// неудач подряд. Возвращает true в момент размыкания.
+func (b *Breaker) Failure(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr = err
+	if b.state == BreakerClosed && b.failures >= b.threshold {
+		b.state = BreakerOpen
+		return true
+	}
+	return false
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
+
+// DBHealth - снимок состояния базы данных для /api/status/.
+type DBHealth struct {
+	Name      string       `json:"name"`
+	Type      string       `json:"type"`
+	Host      string       `json:"host"`
+	Active    bool         `json:"active"`
+	Status    string       `json:"status"`
+	Breaker   BreakerState `json:"breaker"`
+	Version   string       `json:"version"`
+	Uptime    string       `json:"uptime"`
+	Latency   string       `json:"latency"`
+	Failures  int          `json:"failures"`
+	LastCheck time.Time    `json:"last_check"`
+	NextCheck time.Time    `json:"next_check"`
+	LastError string       `json:"last_error,omitempty"`
+}
+
+type healthTarget struct {
+	mu         sync.Mutex
+	name       string
+	dbType     string
+	store      Store
+	cache      cache.Cache
+	owned      bool
+	breaker    *Breaker
+	interval   time.Duration
+	maxBackoff time.Duration
+	health     DBHealth
+}
+
+// HealthMonitor в фоне проверяет каждую базу данных из конфигурации,
+// переподключается к недоступным с экспоненциальной задержкой и управляет
+// их автоматами отключения.
+type HealthMonitor struct {
+	cfg     config.Config
+	targets map[string]*healthTarget
+	order   []string
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewHealthMonitor(cfg config.Config) *HealthMonitor {
+	m := &HealthMonitor{
+		cfg:     cfg,
+		targets: make(map[string]*healthTarget, len(cfg.DB)),
+		stop:    make(chan struct{}),
+	}
+	for _, db := range cfg.DB {
+		if db.HealthInterval < 0 {
+			continue
+		}
+		t := &healthTarget{
+			name:       db.Name,
+			dbType:     db.Type,
+			owned:      true,
+			breaker:    NewBreaker(db.BreakerThreshold),
+			interval:   defaultHealthInterval,
+			maxBackoff: defaultMaxBackoff,
+		}
+		if db.HealthInterval > 0 {
+			t.interval = time.Duration(db.HealthInterval) * time.Second
+		}
+		if db.MaxBackoff > 0 {
+			t.maxBackoff = time.Duration(db.MaxBackoff) * time.Second
+		}
+		t.health = DBHealth{Name: db.Name, Type: db.Type, Status: "unknown", Breaker: BreakerClosed}
+		m.targets[db.Name] = t
+		m.order = append(m.order, db.Name)
+	}
+	return m
+}
+
+// Watch передаёт монитору рабочее хранилище базы name: проверки и
+// переподключения будут выполняться на нём, а его запросы - учитывать автомат.
+func (m *HealthMonitor) Watch(name string, st Store, c cache.Cache) {
+	t, ok := m.targets[name]
+	if !ok {
+		return
+	}
+	st.SetBreaker(t.breaker)
+	t.mu.Lock()
+	old, owned := t.store, t.owned
+	t.store, t.cache, t.owned = st, c, false
+	t.mu.Unlock()
+	if owned && old != nil {
+		if err := old.Close(); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
+// Release возвращает базу name под собственное подключение монитора,
+// когда рабочее хранилище перестаёт быть активным.
+func (m *HealthMonitor) Release(name string) {
+	t, ok := m.targets[name]
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.owned {
+		t.store, t.cache, t.owned = nil, nil, true
+	}
+}
+
+func (m *HealthMonitor) Start() {
+	for _, name := range m.order {
+		m.wg.Add(1)
+		go m.run(m.targets[name])
+	}
+}
+
+// Stop останавливает проверки и закрывает собственные подключения монитора.
+func (m *HealthMonitor) Stop() {
+	close(m.stop)
+	m.wg.Wait()
+	for _, t := range m.targets {
+		t.mu.Lock()
+		if t.owned && t.store != nil {
+			if err := t.store.Close(); err != nil {
+				logger.Error(err.Error())
+			}
+			t.store = nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Status возвращает состояние всех наблюдаемых баз в порядке конфигурации.
+func (m *HealthMonitor) Status() []DBHealth {
+	res := make([]DBHealth, 0, len(m.order))
+	for _, name := range m.order {
+		h, _ := m.Get(name)
+		res = append(res, h)
+	}
+	return res
+}
+
+func (m *HealthMonitor) Get(name string) (DBHealth, bool) {
+	t, ok := m.targets[name]
+	if !ok {
+		return DBHealth{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.health
+	h.Active = !t.owned
+	h.Breaker = t.breaker.State()
+	h.Failures = t.breaker.Failures()
+	return h, true
+}
+
+func (m *HealthMonitor) run(t *healthTarget) {
+	defer m.wg.Done()
+	var delay time.Duration
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(delay):
+		}
+		delay = m.check(t)
+	}
+}
+
+// check выполняет одну проверку и возвращает задержку до следующей.
+func (m *HealthMonitor) check(t *healthTarget) time.Duration {
+	st, err := m.targetStore(t)
+
+	start := time.Now()
+	var version string
+	var uptime time.Duration
+	if err == nil {
+		version, uptime, err = st.GetStatus()
+		if err != nil {
+			// пул соединений мог остаться в неработоспособном состоянии - пробуем переподключиться
+			if rerr := st.Reconnect(); rerr == nil {
+				version, uptime, err = st.GetStatus()
+			}
+		}
+	}
+	latency := time.Since(start)
+
+	delay := t.interval
+	if err != nil {
+		if t.breaker.Failure(err) {
+			logger.Warn(fmt.Sprintf("database %s is unavailable, circuit opened: %v", t.name, err))
+		}
+		delay = backoff(t.interval, t.maxBackoff, t.breaker.Failures())
+	} else {
+		if t.breaker.Success() {
+			logger.Info(fmt.Sprintf("database %s is available again, circuit closed", t.name))
+		}
+		if ferr := st.Failback(); ferr != nil {
+			logger.Error(ferr.Error())
+		}
+		st.RefreshStale()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.health.LastCheck = start
+	t.health.NextCheck = start.Add(delay)
+	t.health.Latency = latency.Round(time.Millisecond).String()
+	if st != nil {
+		t.health.Host = st.Host()
+	}
+	switch {
+	case err == nil:
+		t.health.Status = "green"
+		t.health.Version = version
+		t.health.Uptime = uptime.String()
+		t.health.LastError = ""
+	case t.breaker.State() == BreakerOpen:
+		t.health.Status = "red"
+		t.health.LastError = err.Error()
+	default:
+		t.health.Status = "yellow"
+		t.health.LastError = err.Error()
+	}
+	return delay
+}
+
+// targetStore возвращает хранилище для проверки, при необходимости создавая
+// собственное подключение монитора.
+func (m *HealthMonitor) targetStore(t *healthTarget) (Store, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.store != nil {
+		return t.store, nil
+	}
+	cfg, err := m.cfg.WithDB(t.name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	st.SetBreaker(t.breaker)
+	t.store, t.owned = st, true
+	return st, st.Connect(t.name, nil)
+}
+
+func backoff(base, max time.Duration, failures int) time.Duration {
+	d := base
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
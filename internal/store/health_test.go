@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	rerrors "robin2/internal/errors"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(2)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("new breaker must be closed, got %v", err)
+	}
+
+	if b.Failure(errors.New("timeout")) {
+		t.Fatalf("breaker opened before threshold")
+	}
+	if !b.Failure(errors.New("timeout")) {
+		t.Fatalf("breaker must open on threshold")
+	}
+	if err := b.Allow(); !errors.Is(err, rerrors.ErrDbUnavailable) {
+		t.Fatalf("expected ErrDbUnavailable, got %v", err)
+	}
+
+	if !b.Success() {
+		t.Fatalf("success after open must report recovery")
+	}
+	if b.State() != BreakerClosed || b.Failures() != 0 {
+		t.Fatalf("breaker must be closed and reset, got %s/%d", b.State(), b.Failures())
+	}
+
+	var nilBreaker *Breaker
+	if err := nilBreaker.Allow(); err != nil {
+		t.Fatalf("nil breaker must allow, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 10*time.Second, time.Minute
+	test_cases := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{10, time.Minute},
+	}
+	for _, test := range test_cases {
+		if d := backoff(base, max, test.failures); d != test.expected {
+			t.Errorf("backoff(%d): expected %v, got %v", test.failures, test.expected, d)
+		}
+	}
+}
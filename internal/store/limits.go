@@ -0,0 +1,103 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"robin2/internal/config"
+	"robin2/internal/errors"
+)
+
+// Виды запросов, для которых в конфигурации можно задать отдельные лимиты.
+const (
+	KindTagDate        = "tag_date"
+	KindTagFromTo      = "tag_from_to"
+	KindTagFromToGroup = "tag_from_to_group"
+	KindTagCount       = "tag_from_to_count"
+	KindTagCountGroup  = "tag_from_to_count_group"
+	KindTagList        = "tag_list"
+	KindDownDates      = "down_dates"
+	KindUpDates        = "up_dates"
+	KindQuery          = "query"
+)
+
+// LimitError - превышение лимита запроса. errors.Is(err, ErrLimitExceeded) == true.
+type LimitError struct {
+	Kind  string
+	Limit string
+	Value string
+	Max   string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s is %s, allowed %s (%s)", errors.ErrLimitExceeded, e.Limit, e.Value, e.Max, e.Kind)
+}
+
+func (e *LimitError) Unwrap() error {
+	return errors.ErrLimitExceeded
+}
+
+func (s *Base) limits(kind string) config.Limits {
+	return s.config.CurrDB.Limits.For(kind)
+}
+
+// CheckTags проверяет количество тегов n в одном запросе вида kind.
+func (s *Base) CheckTags(kind string, n int) error {
+	if max := s.limits(kind).MaxTags; max > 0 && n > max {
+		return &LimitError{Kind: kind, Limit: "max_tags", Value: strconv.Itoa(n), Max: strconv.Itoa(max)}
+	}
+	return nil
+}
+
+func (s *Base) checkCount(kind string, count int) error {
+	if max := s.limits(kind).MaxCount; max > 0 && count > max {
+		return &LimitError{Kind: kind, Limit: "max_count", Value: strconv.Itoa(count), Max: strconv.Itoa(max)}
+	}
+	return nil
+}
+
+// checkRange проверяет длительность диапазона from-to (max_range задаётся в секундах).
+func (s *Base) checkRange(kind string, from, to time.Time) error {
+	max := time.Duration(s.limits(kind).MaxRange) * time.Second
+	if d := to.Sub(from); max > 0 && d > max {
+		return &LimitError{Kind: kind, Limit: "max_range", Value: d.String(), Max: max.String()}
+	}
+	return nil
+}
+
+// checkRequest проверяет количество тегов, значений count (0 - не проверяется)
+// и длительность диапазона запроса вида kind.
+func (s *Base) checkRequest(kind string, tags, count int, from, to time.Time) error {
+	if err := s.CheckTags(kind, tags); err != nil {
+		return err
+	}
+	if err := s.checkCount(kind, count); err != nil {
+		return err
+	}
+	return s.checkRange(kind, from, to)
+}
+
+// rowLimiter считает строки, прочитанные из базы за один запрос,
+// в том числе из нескольких горутин.
+type rowLimiter struct {
+	kind string
+	max  int64
+	n    atomic.Int64
+}
+
+func (s *Base) rowLimit(kind string) *rowLimiter {
+	return &rowLimiter{kind: kind, max: int64(s.limits(kind).MaxRows)}
+}
+
+// next учитывает очередную строку и возвращает ошибку при превышении max_rows.
+func (l *rowLimiter) next() error {
+	if l.max <= 0 {
+		return nil
+	}
+	if n := l.n.Add(1); n > l.max {
+		return &LimitError{Kind: l.kind, Limit: "max_rows", Value: ">" + strconv.FormatInt(l.max, 10), Max: strconv.FormatInt(l.max, 10)}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"robin2/internal/config"
+	rerrors "robin2/internal/errors"
+)
+
+func TestLimits(t *testing.T) {
+	cfg := config.Config{CurrDB: &config.Database{Limits: config.Limits{
+		MaxTags:  2,
+		MaxRange: 3600,
+		MaxRows:  2,
+		Endpoints: map[string]config.Limits{
+			KindTagFromToGroup: {MaxRange: -1, MaxTags: 10},
+		},
+	}}}
+	s := newBase(cfg)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := s.checkRequest(KindTagFromTo, 2, 0, from, from.Add(time.Hour)); err != nil {
+		t.Fatalf("request within limits failed: %v", err)
+	}
+	err := s.checkRequest(KindTagFromTo, 3, 0, from, from.Add(time.Hour))
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != "max_tags" || !errors.Is(err, rerrors.ErrLimitExceeded) {
+		t.Fatalf("expected max_tags limit error, got %v", err)
+	}
+	if err := s.checkRange(KindTagFromTo, from, from.Add(2*time.Hour)); !errors.As(err, &le) || le.Limit != "max_range" {
+		t.Fatalf("expected max_range limit error, got %v", err)
+	}
+	if err := s.checkRequest(KindTagFromToGroup, 5, 0, from, from.Add(24*time.Hour)); err != nil {
+		t.Fatalf("endpoint override must relax limits, got %v", err)
+	}
+
+	rl := s.rowLimit(KindTagFromTo)
+	for i := 0; i < 2; i++ {
+		if err := rl.next(); err != nil {
+			t.Fatalf("row %d within limit failed: %v", i, err)
+		}
+	}
+	if err := rl.next(); !errors.As(err, &le) || le.Limit != "max_rows" {
+		t.Fatalf("expected max_rows limit error, got %v", err)
+	}
+}
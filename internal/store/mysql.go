@@ -2,10 +2,10 @@ package store
 
 import (
 	"database/sql"
-	"math"
 	"robin2/internal/cache"
 	"robin2/internal/config"
 	"robin2/internal/logger"
+	"robin2/internal/trace"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -21,36 +21,15 @@ type MySql struct {
 
 func NewMySql(cfg config.Config) (Store, error) {
 	logger.Debug("NewMySqlStore")
-	round := cfg.Round
-	p := math.Pow(10, float64(round))
 	t := MySql{
-		Base: Base{
-			roundConstant: p,
-			config:        cfg,
-		},
+		Base: newBase(cfg),
 	}
+	t.readOnly = readOnlyTx
 	return &t, nil
 }
 
 func (s *MySql) Connect(name string, cache cache.Cache) error {
 	logger.Debug("MySqlStoreImpl.Connect")
-	var err error
-	if s.Base.db != nil {
-		err = s.Base.db.Close()
-		if err != nil {
-			logger.Error(err.Error())
-		}
-	}
-	s.cache = cache
-	s.Base.db, err = sql.Open(s.Base.config.CurrDB.Type, s.Base.GenerateConnectionString(name))
-	if err != nil {
-		logger.Error(err.Error())
-		return err
-	}
-	s.Base.db.SetMaxIdleConns(s.Base.config.CurrDB.MaxIdleConns)
-	s.Base.db.SetMaxOpenConns(s.Base.config.CurrDB.MaxOpenConns)
-	s.Base.db.SetConnMaxIdleTime(time.Duration(s.Base.config.CurrDB.ConnMaxIdleTime) * time.Second)
-	s.Base.db.SetConnMaxLifetime(time.Duration(s.Base.config.CurrDB.ConnMaxLifetime) * time.Second)
 	// todo: CHECK! setup strings
 	// for _, v := range base.config.CurrDB.SetUpStrings {
 	// 	_, err = base.db.Exec(v)
@@ -59,12 +38,24 @@ func (s *MySql) Connect(name string, cache cache.Cache) error {
 	// 		return err
 	// 	}
 	// }
-	// defer base.db.Close()
-	err = s.Base.db.Ping()
-	if err != nil {
-		logger.Error(err.Error())
-		return err
-	}
-	s.Base.logConnection(name)
-	return nil
+	return s.open(name, cache, s.setupPool)
+}
+
+func (s *MySql) setupPool(db *sql.DB) {
+	db.SetMaxIdleConns(s.config.CurrDB.MaxIdleConns)
+	db.SetMaxOpenConns(s.config.CurrDB.MaxOpenConns)
+	db.SetConnMaxIdleTime(time.Duration(s.config.CurrDB.ConnMaxIdleTime) * time.Second)
+	db.SetConnMaxLifetime(time.Duration(s.config.CurrDB.ConnMaxLifetime) * time.Second)
+}
+
+func (s *MySql) WithTrace(t *trace.Trace) Store {
+	c := *s
+	c.Base = s.withTrace(t)
+	return &c
+}
+
+func (s *MySql) WithStale(st *Staleness) Store {
+	c := *s
+	c.Base = s.withStale(st)
+	return &c
 }
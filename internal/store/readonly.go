@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// querier выполняет запросы: пул соединений или транзакция.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// readOnlySession открывает на пуле db сессию, в которой запросы не могут
+// изменить данные; done завершает её после чтения результата. Диалект
+// задаёт способ, который поддерживает его драйвер.
+type readOnlySession func(ctx context.Context, db *sql.DB) (q querier, done func(), err error)
+
+// readOnlyTx - транзакция READ ONLY средствами database/sql (MySQL).
+func readOnlyTx(ctx context.Context, db *sql.DB) (querier, func(), error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() { _ = tx.Rollback() }, nil
+}
+
+// rollbackSession - транзакция, которая всегда откатывается: для драйверов
+// без транзакций только для чтения (MSSQL). Изменения данных не сохраняются.
+func rollbackSession(ctx context.Context, db *sql.DB) (querier, func(), error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() { _ = tx.Rollback() }, nil
+}
+
+// setTransactionReadOnly - транзакция, объявленная только для чтения
+// инструкцией SET TRANSACTION READ ONLY (Oracle).
+func setTransactionReadOnly(ctx context.Context, db *sql.DB) (querier, func(), error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "SET TRANSACTION READ ONLY"); err != nil {
+		_ = tx.Rollback()
+		return nil, nil, fmt.Errorf("read-only transaction: %w", err)
+	}
+	return tx, func() { _ = tx.Rollback() }, nil
+}
+
+// clickhouseReadOnly - настройка readonly=2 для каждого запроса: в ClickHouse
+// нет транзакций. Значение 2 запрещает запись, но разрешает драйверу
+// передавать остальные настройки.
+func clickhouseReadOnly(_ context.Context, db *sql.DB) (querier, func(), error) {
+	return readOnlySettings{db}, func() {}, nil
+}
+
+type readOnlySettings struct {
+	db *sql.DB
+}
+
+func (q readOnlySettings) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{"readonly": 2}))
+	return q.db.QueryContext(ctx, query, args...)
+}
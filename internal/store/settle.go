@@ -0,0 +1,55 @@
+package store
+
+import (
+	"time"
+
+	"robin2/internal/cache"
+	"robin2/internal/config"
+	"robin2/internal/logger"
+)
+
+// openFresh создаёт кэш для значений внутри окна досылки settle_window:
+// историк ещё может дописать или исправить их, поэтому в основной кэш они
+// не попадают, а хранятся в памяти процесса settle_ttl секунд. Без settle_ttl
+// такие значения не кэшируются вовсе.
+func (s *Base) openFresh() {
+	if s.fresh != nil {
+		if err := s.fresh.Disconnect(); err != nil {
+			logger.Error(err.Error())
+		}
+		s.fresh = nil
+	}
+	db := s.config.CurrDB
+	if s.cache == nil || db.SettleWindow <= 0 || db.SettleTTL <= 0 {
+		return
+	}
+	cfg := s.config
+	cfg.CurrCache = &config.CacheConfig{Name: db.Name + ".fresh", Type: "memory", TTLSeconds: db.SettleTTL}
+	if s.config.CurrCache != nil {
+		// просроченные значения окна досылки тоже нужны для ответа при недоступной базе
+		cfg.CurrCache.StaleGrace = s.config.CurrCache.StaleGrace
+	}
+	cfg.CurrCacheName = cfg.CurrCache.Name
+	fresh, err := cache.New(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	s.fresh = fresh
+}
+
+// settleFrom возвращает начало окна досылки: значения до этого момента
+// считаются окончательными.
+func (s *Base) settleFrom(now time.Time) time.Time {
+	return now.Add(-time.Duration(s.config.CurrDB.SettleWindow) * time.Second)
+}
+
+// cacheAt возвращает кэш для значений, действительных до момента t: основной
+// кэш, если t не позже начала окна досылки, иначе кэш с коротким TTL (nil,
+// если он не настроен).
+func (s *Base) cacheAt(t time.Time) cache.Cache {
+	if s.config.CurrDB.SettleWindow <= 0 || !t.After(s.settleFrom(time.Now())) {
+		return s.cache
+	}
+	return s.fresh
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"robin2/internal/cache"
+	"robin2/internal/config"
+	"robin2/internal/data"
+)
+
+func TestSettleWindow(t *testing.T) {
+	cfg := config.Config{
+		CurrDB:    &config.Database{Name: "db", SettleWindow: 900, SettleTTL: 60},
+		CurrCache: &config.CacheConfig{Name: "memory", Type: "memory"},
+	}
+	main, err := cache.New(cfg)
+	if err != nil {
+		t.Fatalf("cache: %v", err)
+	}
+	s := newBase(cfg)
+	s.cache = main
+	s.openFresh()
+	t.Cleanup(func() { _ = s.Close() })
+
+	now := time.Now()
+	old, recent := now.Add(-time.Hour), now.Add(-time.Minute)
+	s.updateCache(data.Tag{Name: "a", Value: 1}, old)
+	s.updateCache(data.Tag{Name: "a", Value: 2}, recent)
+
+	if _, err := main.Get(s.pointKey("a"), old); err != nil {
+		t.Fatalf("settled value must be in main cache, got %v", err)
+	}
+	if _, err := main.Get(s.pointKey("a"), recent); err == nil {
+		t.Fatal("value inside settle window must not be in main cache")
+	}
+	if v, err := s.getFromCache("a", recent); err != nil || v != 2 {
+		t.Fatalf("value inside settle window must be read from fresh cache, got %v (%v)", v, err)
+	}
+
+	from := now.Add(-time.Hour)
+	s.cacheSeries("b", cache.Interval{From: from, To: now.Add(time.Hour)}, data.Tags{
+		{Name: "b", Date: from, Value: 1},
+		{Name: "b", Date: recent, Value: 2},
+	}, now)
+	if _, missing, _ := main.GetSeries(s.seriesKey("b"), from, now); len(missing) != 1 || missing[0].From.After(s.settleFrom(now)) {
+		t.Fatalf("main cache must not cover the settle window, missing %v", missing)
+	}
+	if points, missing := s.getSeries("b", from, now); len(points) != 2 || len(missing) != 0 {
+		t.Fatalf("expected 2 points without gaps, got %v, missing %v", points, missing)
+	}
+}
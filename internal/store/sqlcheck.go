@@ -0,0 +1,164 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"robin2/internal/errors"
+)
+
+// readStatements - первые ключевые слова инструкций, которые только читают.
+var readStatements = map[string]bool{
+	"SELECT":   true,
+	"WITH":     true,
+	"SHOW":     true,
+	"DESCRIBE": true,
+	"DESC":     true,
+	"EXPLAIN":  true,
+	"VALUES":   true,
+}
+
+// writeKeywords - ключевые слова, изменяющие данные или схему в любом месте
+// инструкции: DML в CTE, SELECT ... INTO, вызовы процедур.
+var writeKeywords = map[string]bool{
+	"INSERT":   true,
+	"UPDATE":   true,
+	"DELETE":   true,
+	"MERGE":    true,
+	"UPSERT":   true,
+	"DROP":     true,
+	"CREATE":   true,
+	"ALTER":    true,
+	"TRUNCATE": true,
+	"GRANT":    true,
+	"REVOKE":   true,
+	"EXEC":     true,
+	"EXECUTE":  true,
+	"CALL":     true,
+	"INTO":     true,
+}
+
+// CheckReadOnly проверяет, что query - одна инструкция, которая только читает
+// данные: начинается с SELECT, WITH, SHOW, DESCRIBE, EXPLAIN или VALUES и не
+// содержит ключевых слов изменения данных и схемы. Строки, идентификаторы в
+// кавычках и комментарии не проверяются; INSERT перед "(" - строковая
+// функция MySQL. Комментарии /*! ... */ и /*+ ... */ MySQL и подсказки
+// оптимизатора проверяются как код. Запрос не привязан к диалекту, поэтому
+// он разбирается дважды: со стандартными строками, где кавычка удваивается,
+// и со строками MySQL и ClickHouse, где её экранирует \, - и должен пройти
+// проверку в обоих случаях. Проверка лексическая и дополняет выполнение в
+// сессии только для чтения, а не заменяет его.
+func CheckReadOnly(query string) error {
+	for _, backslash := range []bool{false, true} {
+		if err := checkReadOnly(query, backslash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkReadOnly(query string, backslash bool) error {
+	words, statements := sqlWords(query, backslash)
+	if statements > 1 {
+		return errors.ErrMultiStatement
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	if !readStatements[words[0].text] {
+		return fmt.Errorf("%w: %s", errors.ErrWriteQuery, words[0].text)
+	}
+	for _, w := range words[1:] {
+		if writeKeywords[w.text] && !(w.call && w.text == "INSERT") {
+			return fmt.Errorf("%w: %s", errors.ErrWriteQuery, w.text)
+		}
+	}
+	return nil
+}
+
+// sqlWord - слово запроса в верхнем регистре; call - за ним следует "(".
+type sqlWord struct {
+	text string
+	call bool
+}
+
+// sqlWords возвращает слова запроса вне строк, идентификаторов в кавычках и
+// комментариев, кроме частей составных имён (t.update), и число непустых
+// инструкций, разделённых ";". Если backslash, \ в строке экранирует
+// следующий символ.
+func sqlWords(query string, backslash bool) ([]sqlWord, int) {
+	var words []sqlWord
+	statements, empty := 0, true
+	r := []rune(query)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+2 < len(r) && r[i+1] == '*' && (r[i+2] == '!' || r[i+2] == '+'):
+			// /*!50100 ... */ выполняется MySQL, /*+ ... */ - подсказка:
+			// содержимое - код, закрывающее */ пропускается как знаки
+			for i += 3; i < len(r) && unicode.IsDigit(r[i]); i++ {
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			i += 2
+			for i < len(r) && !(r[i] == '*' && i+1 < len(r) && r[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			// кавычка удваивается внутри строки: 'it''s'
+			for i++; i < len(r); i++ {
+				if backslash && r[i] == '\\' && (c == '\'' || c == '"') {
+					i++
+					continue
+				}
+				if r[i] == end {
+					if i+1 < len(r) && r[i+1] == end && end != ']' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+			if empty {
+				statements, empty = statements+1, false
+			}
+		case c == ';':
+			empty = true
+			i++
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(r) && (unicode.IsLetter(r[i]) || unicode.IsDigit(r[i]) || strings.ContainsRune("_$#", r[i])) {
+				i++
+			}
+			qualified := start > 0 && r[start-1] == '.'
+			j := i
+			for j < len(r) && unicode.IsSpace(r[j]) {
+				j++
+			}
+			if !qualified {
+				words = append(words, sqlWord{text: strings.ToUpper(string(r[start:i])), call: j < len(r) && r[j] == '('})
+			}
+			if empty {
+				statements, empty = statements+1, false
+			}
+		default:
+			i++
+			if empty {
+				statements, empty = statements+1, false
+			}
+		}
+	}
+	return words, statements
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	rerrors "robin2/internal/errors"
+)
+
+func TestCheckReadOnly(t *testing.T) {
+	cases := []struct {
+		query string
+		err   error
+	}{
+		{"select * from t where d >= {from}", nil},
+		{"  -- отчёт\n SELECT a, replace(b, 'x', 'y'), insert(c, 1, 2, 'z') FROM t;  ", nil},
+		{"with x as (select 1) select * from x", nil},
+		{"(select 1) union (select 2)", nil},
+		{"select 'drop table t; delete' as s, \"update\", [insert], `into` from t /* ; drop */", nil},
+		{"select 'it''s; ok' from t", nil},
+		{"select t.update, t.delete from t", nil},
+		{"show tables", nil},
+		{"", nil},
+		{"DROP TABLE t", rerrors.ErrWriteQuery},
+		{"delete from t", rerrors.ErrWriteQuery},
+		{"/* select */ insert into t values (1)", rerrors.ErrWriteQuery},
+		{"with x as (delete from t returning *) select * from x", rerrors.ErrWriteQuery},
+		{"select * into t2 from t", rerrors.ErrWriteQuery},
+		{"select 1; exec('drop table t')", rerrors.ErrMultiStatement},
+		{"select 1; drop table t", rerrors.ErrMultiStatement},
+		{"select 'a\\'; drop table t; --'", rerrors.ErrMultiStatement},
+		{"select exec('x')", rerrors.ErrWriteQuery},
+		{"SELECT * FROM t /*! INTO OUTFILE '/tmp/x' */", rerrors.ErrWriteQuery},
+		{"select /*+ index(t i) */ * from t /*!50100 into outfile '/tmp/x' */", rerrors.ErrWriteQuery},
+		{"SELECT 'a\\''; DROP TABLE x; -- '", rerrors.ErrMultiStatement},
+		{"select 'a\\'' from t", nil},
+	}
+	for _, c := range cases {
+		err := CheckReadOnly(c.query)
+		if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("%q: got %v, want %v", c.query, err, c.err)
+		}
+	}
+}
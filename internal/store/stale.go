@@ -0,0 +1,208 @@
+package store
+
+import (
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"robin2/internal/cache"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+)
+
+// Staleness отмечает, что ответ на запрос собран из просроченного кэша:
+// база данных была недоступна, а для неё включён stale_if_error (см. WithStale).
+type Staleness struct {
+	stale  atomic.Bool
+	mu     sync.Mutex
+	reason string
+}
+
+func (st *Staleness) mark(err error) {
+	st.mu.Lock()
+	if st.reason == "" {
+		st.reason = err.Error()
+	}
+	st.mu.Unlock()
+	st.stale.Store(true)
+}
+
+// Stale сообщает, что хотя бы часть ответа взята из просроченного кэша.
+func (st *Staleness) Stale() bool {
+	return st != nil && st.stale.Load()
+}
+
+// Reason возвращает ошибку базы данных, из-за которой ответ собран из кэша.
+func (st *Staleness) Reason() string {
+	if st == nil {
+		return ""
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.reason
+}
+
+// maxRefresh - сколько запросов обновления просроченных значений хранится
+// до восстановления базы. Остальные будут загружены при следующем обращении.
+const maxRefresh = 10000
+
+// refresher - очередь запросов, ответы на которые были собраны из
+// просроченного кэша. После восстановления базы они выполняются повторно,
+// чтобы обновить кэш. Очередь общая у хранилища и его копий.
+type refresher struct {
+	mu      sync.Mutex
+	queue   map[string]func(*Base) error
+	running atomic.Bool
+}
+
+func newRefresher() *refresher {
+	return &refresher{queue: make(map[string]func(*Base) error)}
+}
+
+func (r *refresher) add(key string, fn func(*Base) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) < maxRefresh {
+		r.queue[key] = fn
+	}
+}
+
+func (r *refresher) take() map[string]func(*Base) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q := r.queue
+	r.queue = make(map[string]func(*Base) error)
+	return q
+}
+
+// withStale возвращает копию хранилища, которая при ошибке базы данных
+// отвечает из просроченного кэша и отмечает это в st.
+func (s *Base) withStale(st *Staleness) Base {
+	c := *s
+	c.staleness = st
+	return c
+}
+
+// RefreshStale в фоне повторяет запросы, ответы на которые были собраны из
+// просроченного кэша. Вызывается монитором после успешной проверки базы.
+func (s *Base) RefreshStale() {
+	if s.refresh == nil || !s.refresh.running.CompareAndSwap(false, true) {
+		return
+	}
+	q := s.refresh.take()
+	if len(q) == 0 {
+		s.refresh.running.Store(false)
+		return
+	}
+	go func() {
+		defer s.refresh.running.Store(false)
+		start := time.Now()
+		failed := 0
+		for key, fn := range q {
+			if err := fn(s); err != nil {
+				failed++
+				logger.Debug(fmt.Sprintf("refresh %s: %v", key, err))
+			}
+		}
+		logger.Info(fmt.Sprintf("%s: refreshed %d stale requests, %d errors in %s", s.name, len(q), failed, time.Since(start).Round(time.Millisecond)))
+	}()
+}
+
+// dbFailed сообщает, что err - ошибка обращения к базе данных, а не
+// результат запроса или ошибка его параметров.
+func dbFailed(err error) bool {
+	return err != nil &&
+		!stderrors.Is(err, sql.ErrNoRows) &&
+		!stderrors.Is(err, errors.ErrLimitExceeded) &&
+		!stderrors.Is(err, errors.ErrGroupError)
+}
+
+// staleAllowed сообщает, что вместо ошибки err можно ответить из просроченного кэша.
+func (s *Base) staleAllowed(err error) bool {
+	return s.staleness != nil && s.cache != nil && s.config.CurrDB.StaleIfError && dbFailed(err)
+}
+
+// servedStale отмечает ответ из просроченного кэша и ставит запрос key в
+// очередь обновления после восстановления базы.
+func (s *Base) servedStale(err error, key string, refresh func(*Base) error) {
+	s.staleness.mark(err)
+	s.refresh.add(key, refresh)
+}
+
+// staleAt возвращает кэш для значений до момента t, читающий и просроченные значения.
+func (s *Base) staleAt(t time.Time) cache.Cache {
+	c := s.cacheAt(t)
+	if c == nil {
+		return nil
+	}
+	return c.Stale()
+}
+
+// staleDate возвращает значение тега на дату из просроченного кэша.
+func (s *Base) staleDate(tag string, date time.Time, err error) (float32, bool) {
+	c := s.staleAt(date)
+	if c == nil {
+		return -1, false
+	}
+	val, cerr := c.Get(s.pointKey(tag), date)
+	if cerr != nil {
+		return -1, false
+	}
+	s.servedStale(err, fmt.Sprintf("date|%s|%s", tag, date.Format(time.RFC3339Nano)), func(b *Base) error {
+		_, err := b.GetTagDate(tag, date)
+		return err
+	})
+	return val, true
+}
+
+// staleAggregate возвращает агрегат key из просроченного кэша.
+func (s *Base) staleAggregate(kind string, key cache.AggregateKey, err error) (float32, bool) {
+	c := s.staleAt(key.To)
+	if c == nil {
+		return -1, false
+	}
+	val, cerr := c.GetAggregate(key)
+	if cerr != nil {
+		return -1, false
+	}
+	s.servedStale(err, "agg|"+key.Field()+"|"+key.Tag, func(b *Base) error {
+		_, err := b.getTagFromToGroup(kind, key.Tag, key.From, key.To, key.Group)
+		return err
+	})
+	return val, true
+}
+
+// staleSeries возвращает значения тега за интервал iv, которые есть в
+// просроченном кэше серий. Если в кэше нет ни одного загруженного участка
+// интервала, ok = false.
+func (s *Base) staleSeries(kind, tag string, iv cache.Interval, err error) (points []cache.Point, ok bool) {
+	key := s.seriesKey(tag)
+	missing := []cache.Interval{iv}
+	for _, c := range []cache.Cache{s.cache, s.fresh} {
+		if c == nil {
+			continue
+		}
+		var rest []cache.Interval
+		for _, m := range missing {
+			p, r, cerr := c.Stale().GetSeries(key, m.From, m.To)
+			if cerr != nil {
+				rest = append(rest, m)
+				continue
+			}
+			points = append(points, p...)
+			rest = append(rest, r...)
+		}
+		missing = rest
+	}
+	if len(missing) == 1 && missing[0].From.Equal(iv.From) && missing[0].To.Equal(iv.To) {
+		return nil, false
+	}
+	s.servedStale(err, fmt.Sprintf("range|%s|%s|%s", tag, iv.From.Format(time.RFC3339Nano), iv.To.Format(time.RFC3339Nano)), func(b *Base) error {
+		_, err := b.tagFromTo(kind, []string{tag}, iv.From, iv.To)
+		return err
+	})
+	return points, true
+}
@@ -1,12 +1,14 @@
 package store
 
 import (
+	"database/sql"
 	"time"
 
 	"robin2/internal/cache"
 	"robin2/internal/config"
 	"robin2/internal/data"
 	"robin2/internal/errors"
+	"robin2/internal/trace"
 )
 
 var registry map[string]func(config.Config) (Store, error)
@@ -30,6 +32,21 @@ func New(cfg config.Config) (Store, error) {
 
 type Store interface {
 	Connect(name string, cache cache.Cache) error
+	Reconnect() error
+	CheckTags(kind string, n int) error
+	Failback() error
+	Host() string
+	Close() error
+	SetBreaker(b *Breaker)
+	// WithTrace возвращает копию хранилища для одного запроса, записывающую
+	// SQL-запросы и обращения к кэшу в трассировку t.
+	WithTrace(t *trace.Trace) Store
+	// WithStale возвращает копию хранилища для одного запроса, которая при
+	// недоступной базе отвечает из просроченного кэша (stale_if_error) и
+	// отмечает это в st.
+	WithStale(st *Staleness) Store
+	// RefreshStale обновляет в фоне значения, отданные из просроченного кэша.
+	RefreshStale()
 	GetTagDate(tag string, date time.Time) (*data.Tag, error)
 	// GetTagsDate(tags []string, date time.Time) (, error)
 	GetTagCount(tag string, from time.Time, to time.Time, strCount int) (map[string]map[time.Time]float32, error)
@@ -40,14 +57,12 @@ type Store interface {
 	GetDownDates(tag string, from time.Time, to time.Time) ([]time.Time, error)
 	GetUpDates(tag string, from time.Time, to time.Time) ([]time.Time, error)
 	GetStatus() (string, time.Duration, error)
+	// Pool возвращает пул соединений для служебных запросов.
+	Pool() (*sql.DB, error)
 
-	TemplateList(like string) (map[string]string, error)
-	TemplateExec(name string, params map[string]string) (*data.Output, error)
-
-	TemplateAdd(name string, body string) error
-	TemplateSet(name string, body string) error
-	TemplateGet(name string) (string, error)
-	TemplateDel(name string) error
-
-	ExecQuery(query string) (*data.Output, error)
+	// ExecQuery выполняет запрос только на чтение с параметрами SQL args:
+	// запросы, изменяющие данные, и несколько инструкций отклоняются.
+	ExecQuery(query string, args ...any) (*data.Output, error)
+	// ExecPrivileged выполняет произвольный запрос без ограничений.
+	ExecPrivileged(query string, args ...any) (*data.Output, error)
 }
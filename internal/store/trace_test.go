@@ -0,0 +1,66 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"robin2/internal/cache"
+	"robin2/internal/config"
+	"robin2/internal/trace"
+)
+
+func TestTrace(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	cc := config.CacheConfig{Name: "mem", Type: "memory"}
+	cfg := config.Config{CurrCacheName: "mem", CurrCache: &cc, CurrDB: &config.Database{Name: "db", Query: map[string]string{
+		"get_tag_from_to_group": "select {group}(v) from h where t = '{tag}' and d >= '{from}' and d < '{to}'",
+	}}}
+	c, err := cache.NewMemory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &countingDB{started: make(chan struct{}, 1), release: make(chan struct{})}
+	close(db.release)
+	s := newBase(cfg)
+	s.name, s.cache, s.p.db = "db", c, sql.OpenDB(db)
+	t.Cleanup(func() { _ = s.p.db.Close() })
+
+	// первый запрос читает базу и сохраняет агрегат, второй - берёт из кэша
+	tr := trace.New()
+	traced := s.withTrace(tr)
+	for range 2 {
+		if v, err := traced.GetTagFromToGroup("a", from, to, "avg"); err != nil || v != 1.5 {
+			t.Fatalf("GetTagFromToGroup: got %v (%v)", v, err)
+		}
+	}
+	if n := db.queries.Load(); n != 1 {
+		t.Fatalf("expected a single database query, got %d", n)
+	}
+
+	r := tr.Report("db")
+	if r.Database != "db" || r.Queries != 1 || r.CacheHits != 1 || r.CacheMisses != 1 {
+		t.Fatalf("unexpected summary %+v", r)
+	}
+	var query *trace.Step
+	for i, st := range r.Steps {
+		if st.Layer == trace.LayerDB {
+			query = &r.Steps[i]
+		} else if st.Target != "mem" || st.Key == "" {
+			t.Fatalf("cache step must name the cache and the key, got %+v", st)
+		}
+	}
+	if query == nil || query.Target != "db" || !strings.Contains(query.Query, "avg(v)") || query.Rows == nil || *query.Rows != 1 || query.Duration < 0 {
+		t.Fatalf("unexpected database step %+v", query)
+	}
+
+	// копия без трассировки ничего не записывает
+	if _, err := s.GetTagFromToGroup("a", from, to, "avg"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tr.Report("db").Steps); n != len(r.Steps) {
+		t.Fatalf("untraced store must not record steps, got %d", n)
+	}
+}
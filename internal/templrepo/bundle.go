@@ -0,0 +1,330 @@
+package templrepo
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"robin2/internal/errors"
+)
+
+// bundleVersion - версия формата набора шаблонов.
+const bundleVersion = 1
+
+const (
+	// manifest - описание набора в ZIP-архиве
+	manifest = "bundle.json"
+	// maxBundleFile - наибольший размер распакованного файла ZIP-архива
+	maxBundleFile = 16 << 20
+	// maxBundleTotal - наибольший размер всех распакованных файлов
+	maxBundleTotal = 64 << 20
+	// maxBundleEntries - наибольшее число файлов ZIP-архива
+	maxBundleEntries = 4096
+)
+
+// Bundle - набор шаблонов для переноса между экземплярами Robin: тела и
+// метаданные с параметрами и расписаниями. Номера версий, авторы и история
+// не переносятся.
+type Bundle struct {
+	Version   int        `json:"version"`
+	Exported  time.Time  `json:"exported"`
+	Templates []Template `json:"templates"`
+}
+
+// Export возвращает набор шаблонов, имена которых подходят к маске like.
+func Export(r Repo, like string) (Bundle, error) {
+	list, err := r.List(like)
+	if err != nil {
+		return Bundle{}, err
+	}
+	b := Bundle{Version: bundleVersion, Exported: time.Now(), Templates: make([]Template, 0, len(list))}
+	for _, t := range list {
+		b.Templates = append(b.Templates, portable(t))
+	}
+	return b, nil
+}
+
+// portable оставляет в шаблоне то, что переносится в набор.
+func portable(t Template) Template {
+	return Template{Name: t.Name, Body: t.Body, Meta: Meta{
+		Description: t.Description,
+		Params:      t.Params,
+		Schedules:   t.Schedules,
+		Privileged:  t.Privileged,
+	}}
+}
+
+// WriteZip записывает набор ZIP-архивом в раскладке каталога Files: тело
+// {name}.sql и метаданные {name}.json каждого шаблона, описание набора - в
+// bundle.json.
+func (b Bundle) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	add := func(name string, data []byte) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: b.Exported})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		return err
+	}
+	head, err := json.MarshalIndent(Bundle{Version: b.Version, Exported: b.Exported}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add(manifest, head); err != nil {
+		return err
+	}
+	for _, t := range b.Templates {
+		meta, err := json.MarshalIndent(t.Meta, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add(t.Name+bodyExt, []byte(t.Body)); err != nil {
+			return err
+		}
+		if err := add(t.Name+metaExt, meta); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ReadBundle разбирает набор: JSON (см. Bundle) или ZIP-архив (см. WriteZip).
+func ReadBundle(data []byte) (Bundle, error) {
+	var b Bundle
+	if bytes.HasPrefix(data, []byte("PK")) {
+		var err error
+		if b, err = readZip(data); err != nil {
+			return Bundle{}, fmt.Errorf("%w: %v", errors.ErrInvalidBundle, err)
+		}
+	} else if err := json.Unmarshal(data, &b); err != nil {
+		return Bundle{}, fmt.Errorf("%w: %v", errors.ErrInvalidBundle, err)
+	}
+	if b.Version < 1 || b.Version > bundleVersion {
+		return Bundle{}, fmt.Errorf("%w: unsupported version %d", errors.ErrInvalidBundle, b.Version)
+	}
+	return b, nil
+}
+
+// readZip разбирает ZIP-архив набора. Файлы лежат в корне архива без
+// каталогов; число файлов и их распакованный размер ограничены.
+func readZip(data []byte) (Bundle, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Bundle{}, err
+	}
+	if len(zr.File) > maxBundleEntries {
+		return Bundle{}, fmt.Errorf("too many files: %d", len(zr.File))
+	}
+	var b Bundle
+	bodies, metas := make(map[string]string), make(map[string][]byte)
+	seen := make(map[string]bool, len(zr.File))
+	total := 0
+	for _, f := range zr.File {
+		name := f.Name
+		if strings.ContainsAny(name, `/\`) {
+			return Bundle{}, fmt.Errorf("%s: nested files are not allowed", name)
+		}
+		if seen[name] {
+			return Bundle{}, fmt.Errorf("%s is repeated", name)
+		}
+		seen[name] = true
+		raw, err := readZipFile(f)
+		if err != nil {
+			return Bundle{}, err
+		}
+		if total += len(raw); total > maxBundleTotal {
+			return Bundle{}, fmt.Errorf("uncompressed size exceeds %d bytes", maxBundleTotal)
+		}
+		switch {
+		case name == manifest:
+			if err := json.Unmarshal(raw, &b); err != nil {
+				return Bundle{}, fmt.Errorf("%s: %w", f.Name, err)
+			}
+		case strings.HasSuffix(name, bodyExt):
+			bodies[strings.TrimSuffix(name, bodyExt)] = string(raw)
+		case strings.HasSuffix(name, metaExt):
+			metas[strings.TrimSuffix(name, metaExt)] = raw
+		}
+	}
+	b.Templates = nil
+	for name, body := range bodies {
+		t := Template{Name: name, Body: body}
+		if raw, ok := metas[name]; ok {
+			if err := json.Unmarshal(raw, &t.Meta); err != nil {
+				return Bundle{}, fmt.Errorf("%s%s: %w", name, metaExt, err)
+			}
+		}
+		b.Templates = append(b.Templates, portable(t))
+	}
+	slices.SortFunc(b.Templates, func(x, y Template) int { return strings.Compare(x.Name, y.Name) })
+	return b, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	raw, err := io.ReadAll(io.LimitReader(rc, maxBundleFile+1))
+	if err == nil && len(raw) > maxBundleFile {
+		err = fmt.Errorf("%s is too large", f.Name)
+	}
+	return raw, err
+}
+
+// Политики импорта шаблона, имя которого уже занято другим шаблоном.
+const (
+	PolicySkip      = "skip"
+	PolicyOverwrite = "overwrite"
+	PolicyRename    = "rename"
+)
+
+// Действия импорта шаблона.
+const (
+	ActionAdded     = "added"
+	ActionUpdated   = "updated"
+	ActionRenamed   = "renamed"
+	ActionSkipped   = "skipped"
+	ActionUnchanged = "unchanged"
+	ActionFailed    = "failed"
+)
+
+// ImportOptions - параметры импорта. Policy - что делать при конфликте (по
+// умолчанию skip); DryRun - только проверить и показать действия;
+// Privileged - имена шаблонов, которые могут быть привилегированными (с
+// учётом переименования); Author - автор версий.
+type ImportOptions struct {
+	Policy     string
+	DryRun     bool
+	Privileged []string
+	Author     string
+}
+
+// ImportResult - итог импорта шаблона Name: Action, новое имя As при
+// переименовании, Conflict - имя занято другим шаблоном.
+type ImportResult struct {
+	Name     string `json:"name"`
+	As       string `json:"as,omitempty"`
+	Action   string `json:"action"`
+	Conflict bool   `json:"conflict"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Import добавляет шаблоны набора b в хранилище r. Шаблон, совпадающий с
+// существующим, не изменяется; при конфликте поступает по политике: skip -
+// пропускает, overwrite - сохраняет новой версией существующего, rename -
+// добавляет под свободным именем {name}_2, {name}_3 и т.д.
+func Import(r Repo, b Bundle, opts ImportOptions) ([]ImportResult, error) {
+	switch opts.Policy {
+	case "":
+		opts.Policy = PolicySkip
+	case PolicySkip, PolicyOverwrite, PolicyRename:
+	default:
+		return nil, fmt.Errorf("%w: unknown policy %q", errors.ErrInvalidBundle, opts.Policy)
+	}
+	res := make([]ImportResult, 0, len(b.Templates))
+	// bundled - имена шаблонов набора: переименованный шаблон не должен
+	// занять имя шаблона, который идёт в наборе позже
+	bundled := make(map[string]bool, len(b.Templates))
+	for _, t := range b.Templates {
+		bundled[t.Name] = true
+	}
+	taken := make(map[string]bool)
+	for _, t := range b.Templates {
+		t = portable(t)
+		t.Author, t.Comment = opts.Author, "import"
+		ir, err := importOne(r, t, opts, taken, bundled)
+		if err != nil {
+			ir.Action, ir.Error = ActionFailed, err.Error()
+		}
+		res = append(res, ir)
+	}
+	return res, nil
+}
+
+func importOne(r Repo, t Template, opts ImportOptions, taken, bundled map[string]bool) (ImportResult, error) {
+	ir := ImportResult{Name: t.Name}
+	if err := opts.allow(t); err != nil {
+		return ir, err
+	}
+	if err := validate(t); err != nil {
+		return ir, err
+	}
+	if taken[t.Name] {
+		return ir, fmt.Errorf("%w: %s is repeated", errors.ErrInvalidBundle, t.Name)
+	}
+	taken[t.Name] = true
+
+	cur, err := r.Get(t.Name)
+	switch {
+	case stderrors.Is(err, errors.ErrTemplateNotFound):
+		ir.Action = ActionAdded
+		if opts.DryRun {
+			return ir, nil
+		}
+		return ir, r.Add(t)
+	case err != nil:
+		return ir, err
+	case same(cur, t):
+		ir.Action = ActionUnchanged
+		return ir, nil
+	}
+
+	ir.Conflict = true
+	switch opts.Policy {
+	case PolicyOverwrite:
+		ir.Action = ActionUpdated
+		if opts.DryRun {
+			return ir, nil
+		}
+		return ir, r.Set(t)
+	case PolicyRename:
+		for n := 2; ; n++ {
+			name := t.Name + "_" + strconv.Itoa(n)
+			if taken[name] || bundled[name] {
+				continue
+			}
+			if _, err := r.Get(name); stderrors.Is(err, errors.ErrTemplateNotFound) {
+				taken[name] = true
+				t.Name, ir.As, ir.Action = name, name, ActionRenamed
+				break
+			} else if err != nil {
+				return ir, err
+			}
+		}
+		if err := validName(t.Name); err != nil {
+			return ir, err
+		}
+		if err := opts.allow(t); err != nil || opts.DryRun {
+			return ir, err
+		}
+		return ir, r.Add(t)
+	}
+	ir.Action = ActionSkipped
+	return ir, nil
+}
+
+// allow проверяет, может ли шаблон t быть привилегированным.
+func (opts ImportOptions) allow(t Template) error {
+	if t.Privileged && !slices.Contains(opts.Privileged, t.Name) {
+		return fmt.Errorf("%w: %s", errors.ErrPrivilegeDenied, t.Name)
+	}
+	return nil
+}
+
+// same сообщает, совпадают ли переносимые части шаблонов. Сравнивается JSON:
+// пустой и отсутствующий списки параметров равны.
+func same(a, b Template) bool {
+	x, errX := json.Marshal(portable(a))
+	y, errY := json.Marshal(portable(b))
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
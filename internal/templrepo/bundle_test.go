@@ -0,0 +1,113 @@
+package templrepo
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	rerrors "robin2/internal/errors"
+)
+
+func TestBundle(t *testing.T) {
+	src := newTestFiles(t)
+	_ = src.Add(Template{Name: "day_total", Body: "select {d}", Meta: Meta{Description: "total", Params: []Param{{Name: "d", Type: ParamDate}}}})
+	_ = src.Add(Template{Name: "day_max", Body: "select 2"})
+	_ = src.Add(Template{Name: "night_total", Body: "select 3"})
+
+	b, err := Export(src, "day%")
+	if err != nil || len(b.Templates) != 2 {
+		t.Fatalf("Export: unexpected %+v (%v)", b, err)
+	}
+	var buf bytes.Buffer
+	if err := b.WriteZip(&buf); err != nil {
+		t.Fatalf("WriteZip: %v", err)
+	}
+	if b, err = ReadBundle(buf.Bytes()); err != nil || len(b.Templates) != 2 || b.Templates[1].Params[0].Name != "d" {
+		t.Fatalf("ReadBundle: unexpected %+v (%v)", b, err)
+	}
+
+	dst := newTestFiles(t)
+	_ = dst.Add(Template{Name: "day_total", Body: "select {d}", Meta: Meta{Description: "total", Params: []Param{{Name: "d", Type: ParamDate}}}})
+	_ = dst.Add(Template{Name: "day_max", Body: "select 20"})
+
+	res, err := Import(dst, b, ImportOptions{Policy: PolicyRename, DryRun: true})
+	if err != nil || len(res) != 2 || res[0].Action != ActionRenamed || res[0].As != "day_max_2" || !res[0].Conflict || res[1].Action != ActionUnchanged {
+		t.Fatalf("dry run: unexpected %+v (%v)", res, err)
+	}
+	if list, _ := dst.List(""); len(list) != 2 {
+		t.Fatalf("dry run must not change templates, got %+v", list)
+	}
+	if res, _ = Import(dst, b, ImportOptions{Policy: PolicyOverwrite, Author: "ann"}); res[0].Action != ActionUpdated {
+		t.Fatalf("overwrite: unexpected %+v", res)
+	}
+	if tm, _ := dst.Get("day_max"); tm.Body != "select 2" || tm.Version != 2 || tm.Author != "ann" {
+		t.Fatalf("overwritten template: unexpected %+v", tm)
+	}
+
+	b.Templates[0].Privileged = true
+	if res, _ = Import(dst, b, ImportOptions{}); res[0].Action != ActionFailed {
+		t.Fatalf("privileged template must need permission, got %+v", res)
+	}
+	opts := ImportOptions{Policy: PolicyRename, DryRun: true, Privileged: []string{"day_max"}}
+	if res, _ = Import(dst, b, opts); res[0].Action != ActionFailed || !strings.Contains(res[0].Error, "day_max_2") {
+		t.Fatalf("renamed privileged template must need permission, got %+v", res)
+	}
+	opts.Policy = PolicyOverwrite
+	if res, _ = Import(dst, b, opts); res[0].Action != ActionUpdated {
+		t.Fatalf("allowed privileged template: unexpected %+v", res)
+	}
+}
+
+func TestReadZipLimits(t *testing.T) {
+	archive := func(names ...string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		if fw, err := zw.Create(manifest); err == nil {
+			_, _ = fw.Write([]byte(`{"version": 1}`))
+		}
+		for _, name := range names {
+			fw, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = fw.Write([]byte("select 1"))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	many := make([]string, maxBundleEntries)
+	for i := range many {
+		many[i] = "t" + strconv.Itoa(i) + bodyExt
+	}
+	if _, err := ReadBundle(archive("a.sql", "b.sql")); err != nil {
+		t.Fatalf("ReadBundle: %v", err)
+	}
+	for _, names := range [][]string{
+		{"a.sql", "a.sql"},
+		{"a.sql", "x/a.sql"},
+		{"x/"},
+		many,
+	} {
+		if _, err := ReadBundle(archive(names...)); !errors.Is(err, rerrors.ErrInvalidBundle) {
+			t.Fatalf("%.3v: must be rejected, got %v", names, err)
+		}
+	}
+}
+
+func TestImportRenameBundled(t *testing.T) {
+	dst := newTestFiles(t)
+	_ = dst.Add(Template{Name: "day_max", Body: "select 1"})
+	b := Bundle{Version: bundleVersion, Templates: []Template{
+		{Name: "day_max", Body: "select 2"},
+		{Name: "day_max_2", Body: "select 3"},
+	}}
+	res, err := Import(dst, b, ImportOptions{Policy: PolicyRename})
+	if err != nil || len(res) != 2 || res[0].As != "day_max_3" || res[1].Action != ActionAdded {
+		t.Fatalf("renamed template must not take a bundled name, got %+v (%v)", res, err)
+	}
+}
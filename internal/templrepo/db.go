@@ -0,0 +1,384 @@
+package templrepo
+
+import (
+	"database/sql"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"robin2/internal/config"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+	"robin2/internal/store"
+)
+
+func init() {
+	Register(TypeDB, NewDB)
+}
+
+const (
+	defaultTable = "robin_templates"
+	// historySuffix - суффикс имени таблицы версий шаблонов
+	historySuffix = "_history"
+)
+
+// dialect - запросы к таблице шаблонов для одного типа базы данных. В
+// запросах {table} заменяется именем таблицы, {history} - именем таблицы
+// версий, параметры записываются как ? и преобразуются bind.
+type dialect struct {
+	create        string
+	createHistory string
+	list          string
+	get           string
+	insert        string
+	// update - запрос изменения шаблона; пустой - новая версия строки
+	// записывается insert (ClickHouse, ReplacingMergeTree по Updated)
+	update string
+	del    string
+	bind   func(n int) string
+}
+
+const (
+	selectFields = "SELECT Name, Body, Meta FROM {table}"
+	insertRow    = "INSERT INTO {table} (Name, Body, Meta, Updated) VALUES (?, ?, ?, ?)"
+	updateRow    = "UPDATE {table} SET Body = ?, Meta = ?, Updated = ? WHERE Name = ?"
+	deleteRow    = "DELETE FROM {table} WHERE Name = ?"
+
+	// запросы к таблице версий одинаковы для всех диалектов
+	insertVersion = "INSERT INTO {history} (Name, Version, Body, Meta, Updated) VALUES (?, ?, ?, ?, ?)"
+	listVersions  = "SELECT Name, Body, Meta FROM {history} WHERE Name = ? ORDER BY Version"
+	getVersion    = "SELECT Name, Body, Meta FROM {history} WHERE Name = ? AND Version = ?"
+	lastVersion   = "SELECT MAX(Version) FROM {history} WHERE Name = ?"
+)
+
+func question(int) string { return "?" }
+
+var dialects = map[string]dialect{
+	"clickhouse": {
+		create:        "CREATE TABLE IF NOT EXISTS {table} (Name String, Body String, Meta String, Updated DateTime64(3)) ENGINE = ReplacingMergeTree(Updated) ORDER BY Name",
+		createHistory: "CREATE TABLE IF NOT EXISTS {history} (Name String, Version UInt32, Body String, Meta String, Updated DateTime64(3)) ENGINE = MergeTree ORDER BY (Name, Version)",
+		list:          selectFields + " FINAL WHERE Name LIKE ? ORDER BY Name",
+		get:           selectFields + " FINAL WHERE Name = ?",
+		insert:        insertRow,
+		del:           deleteRow,
+		bind:          question,
+	},
+	"mysql": {
+		create:        "CREATE TABLE IF NOT EXISTS {table} (Name VARCHAR(255) NOT NULL PRIMARY KEY, Body MEDIUMTEXT NOT NULL, Meta TEXT NOT NULL, Updated DATETIME(3) NOT NULL)",
+		createHistory: "CREATE TABLE IF NOT EXISTS {history} (Name VARCHAR(255) NOT NULL, Version INT NOT NULL, Body MEDIUMTEXT NOT NULL, Meta TEXT NOT NULL, Updated DATETIME(3) NOT NULL, PRIMARY KEY (Name, Version))",
+		list:          selectFields + " WHERE Name LIKE ? ORDER BY Name",
+		get:           selectFields + " WHERE Name = ?",
+		insert:        insertRow,
+		update:        updateRow,
+		del:           deleteRow,
+		bind:          question,
+	},
+	"mssql": {
+		create:        "IF OBJECT_ID(N'{table}', N'U') IS NULL CREATE TABLE {table} (Name NVARCHAR(255) NOT NULL PRIMARY KEY, Body NVARCHAR(MAX) NOT NULL, Meta NVARCHAR(MAX) NOT NULL, Updated DATETIME2 NOT NULL)",
+		createHistory: "IF OBJECT_ID(N'{history}', N'U') IS NULL CREATE TABLE {history} (Name NVARCHAR(255) NOT NULL, Version INT NOT NULL, Body NVARCHAR(MAX) NOT NULL, Meta NVARCHAR(MAX) NOT NULL, Updated DATETIME2 NOT NULL, PRIMARY KEY (Name, Version))",
+		list:          selectFields + " WHERE Name LIKE ? ORDER BY Name",
+		get:           selectFields + " WHERE Name = ?",
+		insert:        insertRow,
+		update:        updateRow,
+		del:           deleteRow,
+		bind:          question,
+	},
+	"oracle": {
+		// ORA-00955: таблица уже существует
+		create:        "BEGIN EXECUTE IMMEDIATE 'CREATE TABLE {table} (Name VARCHAR2(255) NOT NULL PRIMARY KEY, Body CLOB NOT NULL, Meta CLOB NOT NULL, Updated TIMESTAMP NOT NULL)'; EXCEPTION WHEN OTHERS THEN IF SQLCODE != -955 THEN RAISE; END IF; END;",
+		createHistory: "BEGIN EXECUTE IMMEDIATE 'CREATE TABLE {history} (Name VARCHAR2(255) NOT NULL, Version NUMBER(10) NOT NULL, Body CLOB NOT NULL, Meta CLOB NOT NULL, Updated TIMESTAMP NOT NULL, PRIMARY KEY (Name, Version))'; EXCEPTION WHEN OTHERS THEN IF SQLCODE != -955 THEN RAISE; END IF; END;",
+		list:          selectFields + " WHERE Name LIKE ? ORDER BY Name",
+		get:           selectFields + " WHERE Name = ?",
+		insert:        insertRow,
+		update:        updateRow,
+		del:           deleteRow,
+		bind:          func(n int) string { return ":" + strconv.Itoa(n) },
+	},
+}
+
+// query подставляет в запрос q имя таблицы и параметры диалекта.
+func (d dialect) query(q, table string) string {
+	q = strings.ReplaceAll(q, "{table}", table)
+	q = strings.ReplaceAll(q, "{history}", table+historySuffix)
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString(d.bind(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// DB хранит шаблоны в таблице templates.table базы данных templates.db (по
+// умолчанию - curr_db), версии - в таблице {table}_history. Таблицы
+// создаются при первом обращении.
+type DB struct {
+	mu      sync.Mutex
+	st      store.Store
+	dialect dialect
+	table   string
+	ready   bool
+}
+
+func NewDB(cfg config.Config) (Repo, error) {
+	name := cfg.Templates.DB
+	if name == "" {
+		name = cfg.CurrDBName
+	}
+	dcfg, err := cfg.WithDB(name)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := dialects[dcfg.CurrDB.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: templates are not supported for %s", errors.ErrStoreError, dcfg.CurrDB.Type)
+	}
+	st, err := store.New(dcfg)
+	if err != nil {
+		return nil, err
+	}
+	// база может быть недоступна при запуске: подключение восстановится позже
+	if err := st.Connect(name, nil); err != nil {
+		logger.Error(fmt.Sprintf("templates: %s: %v", name, err))
+	}
+	table := cfg.Templates.Table
+	if table == "" {
+		table = defaultTable
+	}
+	logger.Info(fmt.Sprintf("templates in table %s of database %s", table, name))
+	return &DB{st: st, dialect: d, table: table}, nil
+}
+
+// pool возвращает пул соединений, при первом обращении создавая таблицу.
+func (r *DB) pool() (*sql.DB, error) {
+	db, err := r.st.Pool()
+	if err != nil {
+		if rerr := r.st.Reconnect(); rerr != nil {
+			return nil, err
+		}
+		if db, err = r.st.Pool(); err != nil {
+			return nil, err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.ready {
+		for _, q := range []string{r.dialect.create, r.dialect.createHistory} {
+			if _, err := db.Exec(r.q(q)); err != nil {
+				return nil, fmt.Errorf("templates: create %s: %w", r.table, err)
+			}
+		}
+		r.ready = true
+	}
+	return db, nil
+}
+
+func (r *DB) q(query string) string {
+	return r.dialect.query(query, r.table)
+}
+
+func (r *DB) List(like string) ([]Template, error) {
+	db, err := r.pool()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(r.q(r.dialect.list), like+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Template
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
+
+func (r *DB) Get(name string) (Template, error) {
+	db, err := r.pool()
+	if err != nil {
+		return Template{}, err
+	}
+	return r.get(db, name)
+}
+
+func (r *DB) get(db *sql.DB, name string) (Template, error) {
+	t, err := scan(db.QueryRow(r.q(r.dialect.get), name))
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return Template{}, fmt.Errorf("%w: %s", errors.ErrTemplateNotFound, name)
+	}
+	return t, err
+}
+
+func (r *DB) Add(t Template) error {
+	if err := validate(t); err != nil {
+		return err
+	}
+	db, err := r.pool()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.get(db, t.Name); err == nil {
+		return fmt.Errorf("%w: %s", errors.ErrTemplateExists, t.Name)
+	} else if !stderrors.Is(err, errors.ErrTemplateNotFound) {
+		return err
+	}
+	last, err := r.lastVersion(db, t.Name)
+	if err != nil {
+		return err
+	}
+	t.Version = last + 1
+	if err := r.archive(db, &t); err != nil {
+		return err
+	}
+	return r.insert(db, t)
+}
+
+func (r *DB) Set(t Template) error {
+	if err := validate(t); err != nil {
+		return err
+	}
+	db, err := r.pool()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, err := r.get(db, t.Name)
+	if err != nil {
+		return err
+	}
+	last, err := r.lastVersion(db, t.Name)
+	if err != nil {
+		return err
+	}
+	if cur.Version == 0 {
+		// шаблон создан до появления версий - сохраняется как версия
+		cur.Version = last + 1
+		if err := r.archive(db, &cur); err != nil {
+			return err
+		}
+		last = cur.Version
+	}
+	t.Version = last + 1
+	if err := r.archive(db, &t); err != nil {
+		return err
+	}
+	if r.dialect.update == "" {
+		return r.insert(db, t)
+	}
+	meta, err := json.Marshal(t.Meta)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(r.q(r.dialect.update), t.Body, string(meta), t.Updated, t.Name)
+	return err
+}
+
+// archive сохраняет версию t.Version шаблона, отмечая время изменения.
+func (r *DB) archive(db *sql.DB, t *Template) error {
+	t.Updated = time.Now()
+	meta, err := json.Marshal(t.Meta)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(r.q(insertVersion), t.Name, t.Version, t.Body, string(meta), t.Updated)
+	return err
+}
+
+func (r *DB) lastVersion(db *sql.DB, name string) (int, error) {
+	var v sql.NullInt64
+	if err := db.QueryRow(r.q(lastVersion), name).Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
+
+func (r *DB) Versions(name string) ([]Template, error) {
+	db, err := r.pool()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(r.q(listVersions), name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Template
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
+
+func (r *DB) Version(name string, v int) (Template, error) {
+	db, err := r.pool()
+	if err != nil {
+		return Template{}, err
+	}
+	t, err := scan(db.QueryRow(r.q(getVersion), name, v))
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return Template{}, fmt.Errorf("%w: %s version %d", errors.ErrTemplateNotFound, name, v)
+	}
+	return t, err
+}
+
+func (r *DB) insert(db *sql.DB, t Template) error {
+	meta, err := json.Marshal(t.Meta)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(r.q(r.dialect.insert), t.Name, t.Body, string(meta), t.Updated)
+	return err
+}
+
+func (r *DB) Del(name string) error {
+	db, err := r.pool()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.get(db, name); err != nil {
+		return err
+	}
+	_, err = db.Exec(r.q(r.dialect.del), name)
+	return err
+}
+
+func (r *DB) Close() error {
+	return r.st.Close()
+}
+
+// scan читает строку Name, Body, Meta.
+func scan(row interface{ Scan(...any) error }) (Template, error) {
+	var t Template
+	var meta string
+	if err := row.Scan(&t.Name, &t.Body, &meta); err != nil {
+		return Template{}, err
+	}
+	if meta != "" {
+		if err := json.Unmarshal([]byte(meta), &t.Meta); err != nil {
+			return Template{}, fmt.Errorf("template %s: %w", t.Name, err)
+		}
+	}
+	return t, nil
+}
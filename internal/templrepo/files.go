@@ -0,0 +1,307 @@
+package templrepo
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"robin2/internal/config"
+	"robin2/internal/errors"
+	"robin2/internal/logger"
+)
+
+func init() {
+	Register(TypeFiles, NewFiles)
+}
+
+const (
+	defaultFilesDir = "templates"
+	bodyExt         = ".sql"
+	metaExt         = ".json"
+	historyDir      = ".history"
+)
+
+// Files хранит каждый шаблон двумя файлами каталога dir: тело в {name}.sql
+// и метаданные в {name}.json. Файлы можно править вручную и хранить в
+// системе контроля версий; шаблон без {name}.json имеет пустые метаданные.
+// Версии хранятся целиком в .history/{name}/{version}.json и не изменяются.
+type Files struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+func NewFiles(cfg config.Config) (Repo, error) {
+	dir := cfg.Templates.Dir
+	if dir == "" {
+		dir = defaultFilesDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	logger.Info(fmt.Sprintf("templates in directory %s", dir))
+	return &Files{dir: dir}, nil
+}
+
+func (r *Files) List(like string) ([]Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	re := likeRe(like)
+	var res []Template
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), bodyExt)
+		if e.IsDir() || !ok || validName(name) != nil || !re.MatchString(name) {
+			continue
+		}
+		t, err := r.read(name)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+func (r *Files) Get(name string) (Template, error) {
+	if err := validName(name); err != nil {
+		return Template{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.read(name)
+}
+
+func (r *Files) Add(t Template) error {
+	if err := validate(t); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := os.Stat(r.path(t.Name, bodyExt)); err == nil {
+		return fmt.Errorf("%w: %s", errors.ErrTemplateExists, t.Name)
+	}
+	last, err := r.lastVersion(t.Name)
+	if err != nil {
+		return err
+	}
+	return r.write(t, last+1)
+}
+
+func (r *Files) Set(t Template) error {
+	if err := validate(t); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, err := r.read(t.Name)
+	if err != nil {
+		return err
+	}
+	last, err := r.lastVersion(t.Name)
+	if err != nil {
+		return err
+	}
+	if cur.Version == 0 {
+		// шаблон создан до появления версий или вручную - сохраняется как версия
+		cur.Version = last + 1
+		if err := r.archive(cur); err != nil {
+			return err
+		}
+		last = cur.Version
+	}
+	return r.write(t, last+1)
+}
+
+func (r *Files) Del(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.Remove(r.path(name, bodyExt)); err != nil {
+		return notFound(name, err)
+	}
+	if err := os.Remove(r.path(name, metaExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *Files) Versions(name string) ([]Template, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions, err := r.versions(name)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Template, 0, len(versions))
+	for _, v := range versions {
+		t, err := r.readVersion(name, v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+func (r *Files) Version(name string, v int) (Template, error) {
+	if err := validName(name); err != nil {
+		return Template{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.readVersion(name, v)
+}
+
+func (r *Files) Close() error {
+	return nil
+}
+
+func (r *Files) path(name, ext string) string {
+	return filepath.Join(r.dir, name+ext)
+}
+
+func (r *Files) versionPath(name string, v int) string {
+	return filepath.Join(r.dir, historyDir, name, strconv.Itoa(v)+metaExt)
+}
+
+// versions возвращает номера сохранённых версий шаблона по возрастанию.
+func (r *Files) versions(name string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, historyDir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []int
+	for _, e := range entries {
+		s, ok := strings.CutSuffix(e.Name(), metaExt)
+		if v, err := strconv.Atoi(s); ok && err == nil && v > 0 {
+			res = append(res, v)
+		}
+	}
+	sort.Ints(res)
+	return res, nil
+}
+
+func (r *Files) lastVersion(name string) (int, error) {
+	versions, err := r.versions(name)
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (r *Files) readVersion(name string, v int) (Template, error) {
+	raw, err := os.ReadFile(r.versionPath(name, v))
+	if err != nil {
+		if stderrors.Is(err, fs.ErrNotExist) {
+			return Template{}, fmt.Errorf("%w: %s version %d", errors.ErrTemplateNotFound, name, v)
+		}
+		return Template{}, err
+	}
+	var t Template
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return Template{}, fmt.Errorf("template %s version %d: %w", name, v, err)
+	}
+	return t, nil
+}
+
+// archive сохраняет версию t. Существующая версия не перезаписывается.
+func (r *Files) archive(t Template) error {
+	path := r.versionPath(t.Name, t.Version)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// read читает шаблон name. Вызывается под блокировкой.
+func (r *Files) read(name string) (Template, error) {
+	body, err := os.ReadFile(r.path(name, bodyExt))
+	if err != nil {
+		return Template{}, notFound(name, err)
+	}
+	t := Template{Name: name, Body: string(body)}
+	raw, err := os.ReadFile(r.path(name, metaExt))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return Template{}, err
+	default:
+		if err := json.Unmarshal(raw, &t.Meta); err != nil {
+			return Template{}, fmt.Errorf("template %s: %w", name, err)
+		}
+	}
+	return t, nil
+}
+
+// write сохраняет шаблон t версией v и записывает его текущим. Вызывается
+// под блокировкой.
+func (r *Files) write(t Template, v int) error {
+	t.Version, t.Updated = v, time.Now()
+	if err := r.archive(t); err != nil {
+		return err
+	}
+	meta, err := json.MarshalIndent(t.Meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(r.path(t.Name, metaExt), meta); err != nil {
+		return err
+	}
+	return writeFile(r.path(t.Name, bodyExt), []byte(t.Body))
+}
+
+// writeFile заменяет файл path целиком: читатели видят либо старое, либо новое содержимое.
+func writeFile(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".template-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func notFound(name string, err error) error {
+	if stderrors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errors.ErrTemplateNotFound, name)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package templrepo
+
+import (
+	"errors"
+	"testing"
+
+	"robin2/internal/config"
+	rerrors "robin2/internal/errors"
+)
+
+func newTestFiles(t *testing.T) Repo {
+	t.Helper()
+	r, err := New(config.Config{Templates: config.Templates{Type: TypeFiles, Dir: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return r
+}
+
+func TestFiles(t *testing.T) {
+	r := newTestFiles(t)
+
+	if err := r.Add(Template{Name: "day_total", Body: "select 1", Meta: Meta{Description: "total"}}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := r.Add(Template{Name: "day_total", Body: "select 2"}); !errors.Is(err, rerrors.ErrTemplateExists) {
+		t.Fatalf("second Add must fail with ErrTemplateExists, got %v", err)
+	}
+	if err := r.Add(Template{Name: "../x", Body: "select 1"}); !errors.Is(err, rerrors.ErrInvalidTemplateName) {
+		t.Fatalf("path in name must be rejected, got %v", err)
+	}
+	if err := r.Add(Template{Name: "cleanup", Body: "delete from t"}); !errors.Is(err, rerrors.ErrWriteQuery) {
+		t.Fatalf("writing template must be privileged, got %v", err)
+	}
+	if err := r.Add(Template{Name: "cleanup", Body: "delete from t", Meta: Meta{Privileged: true}}); err != nil {
+		t.Fatalf("Add privileged: %v", err)
+	}
+	if err := r.Set(Template{Name: "missing", Body: "select 1"}); !errors.Is(err, rerrors.ErrTemplateNotFound) {
+		t.Fatalf("Set of missing template must fail, got %v", err)
+	}
+	if err := r.Add(Template{Name: "night_total", Body: "select 3"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	tm, err := r.Get("day_total")
+	if err != nil || tm.Body != "select 1" || tm.Description != "total" || tm.Updated.IsZero() {
+		t.Fatalf("Get: unexpected %+v (%v)", tm, err)
+	}
+	tm.Body = "select 10"
+	if err := r.Set(tm); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	list, err := r.List("d%")
+	if err != nil || len(list) != 1 || list[0].Body != "select 10" {
+		t.Fatalf("List(d%%): unexpected %+v (%v)", list, err)
+	}
+	if list, _ = r.List("_ight"); len(list) != 1 || list[0].Name != "night_total" {
+		t.Fatalf("List(_ight): unexpected %+v", list)
+	}
+	if list, _ = r.List(""); len(list) != 3 || list[0].Name != "cleanup" {
+		t.Fatalf("List: unexpected %+v", list)
+	}
+
+	if err := r.Del("day_total"); err != nil {
+		t.Fatalf("Del: %v", err)
+	}
+	if _, err := r.Get("day_total"); !errors.Is(err, rerrors.ErrTemplateNotFound) {
+		t.Fatalf("deleted template must be missing, got %v", err)
+	}
+}
+
+func TestDialectQuery(t *testing.T) {
+	q := dialects["oracle"].query(updateRow, "t")
+	if q != "UPDATE t SET Body = :1, Meta = :2, Updated = :3 WHERE Name = :4" {
+		t.Fatalf("unexpected oracle query %q", q)
+	}
+}
+
+func TestFilesVersions(t *testing.T) {
+	r := newTestFiles(t)
+	_ = r.Add(Template{Name: "report", Body: "select a\nfrom t", Meta: Meta{Author: "ann", Comment: "first"}})
+	_ = r.Set(Template{Name: "report", Body: "select a, b\nfrom t", Meta: Meta{Author: "bob"}})
+
+	versions, err := r.Versions("report")
+	if err != nil || len(versions) != 2 || versions[0].Author != "ann" || versions[1].Version != 2 {
+		t.Fatalf("Versions: unexpected %+v (%v)", versions, err)
+	}
+	if diff := Diff(versions[0], versions[1], 1); diff != "--- report version 1\n+++ report version 2\n@@ -1,2 +1,2 @@\n-select a\n+select a, b\n from t\n" {
+		t.Fatalf("unexpected diff %q", diff)
+	}
+
+	_ = r.Del("report")
+	tm, err := Rollback(r, "report", 1, "carl")
+	if err != nil || tm.Version != 3 || tm.Body != "select a\nfrom t" || tm.Author != "carl" {
+		t.Fatalf("Rollback of deleted template: unexpected %+v (%v)", tm, err)
+	}
+	if v, err := r.Version("report", 2); err != nil || v.Body != "select a, b\nfrom t" {
+		t.Fatalf("old version must be kept, got %+v (%v)", v, err)
+	}
+}
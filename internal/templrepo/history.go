@@ -0,0 +1,172 @@
+package templrepo
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+
+	"robin2/internal/errors"
+)
+
+// Rollback делает текущей копию версии v шаблона name: сохраняется новая
+// версия с телом, описанием, параметрами и расписаниями v, предыдущие версии
+// не изменяются. Удалённый шаблон восстанавливается.
+func Rollback(r Repo, name string, v int, author string) (Template, error) {
+	old, err := r.Version(name, v)
+	if err != nil {
+		return Template{}, err
+	}
+	t := Template{Name: name, Body: old.Body, Meta: Meta{
+		Description: old.Description,
+		Params:      old.Params,
+		Schedules:   old.Schedules,
+		Privileged:  old.Privileged,
+		Author:      author,
+		Comment:     fmt.Sprintf("rollback to version %d", v),
+	}}
+	if _, err = r.Get(name); stderrors.Is(err, errors.ErrTemplateNotFound) {
+		err = r.Add(t)
+	} else if err == nil {
+		err = r.Set(t)
+	}
+	if err != nil {
+		return Template{}, err
+	}
+	return r.Get(name)
+}
+
+// Diff возвращает построчную разницу тел шаблонов a и b в формате unified
+// diff: строки только из a отмечены "-", только из b - "+". Вокруг изменений
+// выводится до context неизменных строк.
+func Diff(a, b Template, context int) string {
+	x, y := strings.Split(a.Body, "\n"), strings.Split(b.Body, "\n")
+	ops := diffLines(x, y)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s version %d\n+++ %s version %d\n", a.Name, a.Version, b.Name, b.Version)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// участок изменений с контекстом; близкие участки объединяются
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(ops) && j <= end+2*context; j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			}
+		}
+		end = min(end+context+1, len(ops))
+		writeHunkHeader(&sb, ops[start:end])
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+// diffOp - строка разницы: kind ' ', '-' или '+'; x, y - номера строк в a и b.
+type diffOp struct {
+	kind byte
+	line string
+	x, y int
+}
+
+// writeHunkHeader записывает заголовок участка "@@ -x,n +y,m @@": начало и
+// число строк участка в a и b. Пустой участок начинается со строки перед ним.
+func writeHunkHeader(sb *strings.Builder, ops []diffOp) {
+	n, m := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			n++
+		}
+		if op.kind != '-' {
+			m++
+		}
+	}
+	x, y := ops[0].x+1, ops[0].y+1
+	if n == 0 {
+		x--
+	}
+	if m == 0 {
+		y--
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", x, n, y, m)
+}
+
+// maxDiffCells - наибольший размер таблицы наибольшей общей
+// подпоследовательности (строки a на строки b после отбрасывания общих
+// начала и конца). Большие тела сравниваются без поиска общих строк.
+const maxDiffCells = 4 << 20
+
+// diffLines строит разницу по наибольшей общей подпоследовательности строк.
+// Общие начало и конец отбрасываются; если остаток слишком велик для
+// таблицы (см. maxDiffCells), он заменяется целиком.
+func diffLines(x, y []string) []diffOp {
+	var ops []diffOp
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		ops = append(ops, diffOp{' ', x[pre], pre, pre})
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+	mx, my := x[pre:len(x)-suf], y[pre:len(y)-suf]
+	if len(mx)*len(my) > maxDiffCells {
+		for i, line := range mx {
+			ops = append(ops, diffOp{'-', line, pre + i, pre})
+		}
+		for j, line := range my {
+			ops = append(ops, diffOp{'+', line, pre + len(mx), pre + j})
+		}
+	} else {
+		for _, op := range lcsDiff(mx, my) {
+			op.x, op.y = op.x+pre, op.y+pre
+			ops = append(ops, op)
+		}
+	}
+	for k := 0; k < suf; k++ {
+		i, j := len(x)-suf+k, len(y)-suf+k
+		ops = append(ops, diffOp{' ', x[i], i, j})
+	}
+	return ops
+}
+
+func lcsDiff(x, y []string) []diffOp {
+	// lcs[i][j] - длина общей подпоследовательности x[i:] и y[j:]
+	lcs := make([][]int32, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, diffOp{' ', x[i], i, j})
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', x[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', y[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
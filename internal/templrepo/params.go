@@ -0,0 +1,316 @@
+package templrepo
+
+import (
+	stderrors "errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"robin2/internal/errors"
+	"robin2/internal/store"
+	"robin2/internal/utils"
+)
+
+// Типы параметров шаблона.
+const (
+	ParamDate   = "date"
+	ParamNumber = "number"
+	ParamTag    = "tag"
+	ParamEnum   = "enum"
+	ParamString = "string"
+)
+
+// Param - объявленный параметр шаблона. Значение параметра проверяется,
+// преобразуется к типу и передаётся в запрос параметром SQL, а не текстом.
+// Для enum допустимые значения перечисляются в Values.
+type Param struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// ReservedParam - аргумент выполнения шаблона, выбирающий базу данных; в
+// тело шаблона он не подставляется.
+const ReservedParam = "db"
+
+var (
+	// placeholderRe - подстановка {name} в теле шаблона, в том числе в
+	// кавычках '{name}': параметр SQL заменяет её вместе с кавычками
+	placeholderRe = regexp.MustCompile(`'\{(\w+)\}'|\{(\w+)\}`)
+	// argRe - подстановка {name} без учёта кавычек: в тексте шаблона без
+	// объявленных параметров и внутри строкового литерала SQL
+	argRe       = regexp.MustCompile(`\{(\w+)\}`)
+	paramNameRe = regexp.MustCompile(`^\w+$`)
+	tagRe       = regexp.MustCompile(`^[\p{L}\p{N}_.:/\-]+$`)
+)
+
+// validate проверяет имя шаблона, тело обычного шаблона и объявления его
+// параметров. Привилегированный шаблон с подстановками должен объявить
+// параметры: его запрос не проверяется, и аргумент, вставленный текстом,
+// изменил бы запрос.
+func validate(t Template) error {
+	if err := validName(t.Name); err != nil {
+		return err
+	}
+	if !t.Privileged {
+		if err := store.CheckReadOnly(t.Body); err != nil {
+			return fmt.Errorf("%w (the template must be privileged)", err)
+		}
+	}
+	if len(t.Params) == 0 {
+		if t.Privileged && Legacy(t) {
+			return fmt.Errorf("%w: privileged template %s must declare its parameters", errors.ErrTemplateParam, t.Name)
+		}
+		return nil
+	}
+	declared := make(map[string]bool, len(t.Params))
+	for _, p := range t.Params {
+		if !paramNameRe.MatchString(p.Name) || p.Name == ReservedParam {
+			return fmt.Errorf("%w: invalid name %q", errors.ErrTemplateParam, p.Name)
+		}
+		if declared[p.Name] {
+			return fmt.Errorf("%w: %s declared twice", errors.ErrTemplateParam, p.Name)
+		}
+		declared[p.Name] = true
+		switch p.Type {
+		case ParamDate, ParamNumber, ParamTag, ParamString:
+		case ParamEnum:
+			if len(p.Values) == 0 {
+				return fmt.Errorf("%w: enum %s has no values", errors.ErrTemplateParam, p.Name)
+			}
+		default:
+			return fmt.Errorf("%w: %s has unknown type %q", errors.ErrTemplateParam, p.Name, p.Type)
+		}
+		// формат даты по умолчанию проверяется при выполнении: форматы дат
+		// задаются конфигурацией
+		if p.Default != "" && p.Type != ParamDate {
+			if _, err := p.convert(p.Default, nil); err != nil {
+				return fmt.Errorf("default: %w", err)
+			}
+		}
+	}
+	for _, m := range placeholderRe.FindAllStringSubmatch(t.Body, -1) {
+		if name := m[1] + m[2]; !declared[name] {
+			return fmt.Errorf("%w: {%s} is not declared", errors.ErrTemplateParam, name)
+		}
+	}
+	return embedded(t.Body)
+}
+
+// embedded проверяет, что объявленный параметр не подставляется внутрь
+// более длинного строкового литерала: параметр SQL в '{mask}%' оказался бы
+// частью текста литерала. Литерал должен быть ровно '{name}', остальное
+// собирается выражением, например CONCAT({mask}, '%').
+func embedded(body string) error {
+	for i := 0; i < len(body); i++ {
+		switch {
+		case strings.HasPrefix(body[i:], "--"):
+			if n := strings.IndexByte(body[i:], '\n'); n >= 0 {
+				i += n
+			} else {
+				i = len(body)
+			}
+		case strings.HasPrefix(body[i:], "/*"):
+			if n := strings.Index(body[i+2:], "*/"); n >= 0 {
+				i += n + 3
+			} else {
+				i = len(body)
+			}
+		case body[i] == '\'':
+			j := i + 1
+			for ; j < len(body); j++ {
+				if body[j] == '\'' {
+					if j+1 < len(body) && body[j+1] == '\'' {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			lit := body[i:min(j+1, len(body))]
+			if m := argRe.FindStringSubmatch(lit); m != nil && placeholderRe.FindString(lit) != lit {
+				return fmt.Errorf("%w: {%s} is inside the literal %s, use CONCAT({%s}, ...) instead", errors.ErrTemplateParam, m[1], lit, m[1])
+			}
+			i = j
+		}
+	}
+	return nil
+}
+
+// Legacy сообщает, что шаблон t не объявляет параметров, но содержит
+// подстановки: аргументы вставляются в его текст как есть.
+func Legacy(t Template) bool {
+	return len(t.Params) == 0 && argRe.MatchString(t.Body)
+}
+
+// convert проверяет значение s параметра и преобразует его к типу параметра.
+// Даты разбираются utils.ExcelTimeToTime по форматам dateFormats.
+func (p Param) convert(s string, dateFormats []string) (any, error) {
+	switch p.Type {
+	case ParamDate:
+		t, err := utils.ExcelTimeToTime(s, dateFormats)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", errors.ErrTemplateParam, p.Name, err)
+		}
+		return t, nil
+	case ParamNumber:
+		f, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %q is not a number", errors.ErrTemplateParam, p.Name, s)
+		}
+		return f, nil
+	case ParamTag:
+		s = strings.TrimSpace(s)
+		if !tagRe.MatchString(s) {
+			return nil, fmt.Errorf("%w: %s: invalid tag %q", errors.ErrTemplateParam, p.Name, s)
+		}
+		return s, nil
+	case ParamEnum:
+		if !slices.Contains(p.Values, s) {
+			return nil, fmt.Errorf("%w: %s: %q is not one of %s", errors.ErrTemplateParam, p.Name, s, strings.Join(p.Values, ", "))
+		}
+		return s, nil
+	}
+	return s, nil
+}
+
+// Rendered - запрос шаблона, связанный с аргументами: Query с параметрами
+// SQL и их значения Args в порядке следования, Preview - тот же запрос со
+// значениями, подставленными литералами SQL (только для чтения человеком).
+// Errors - все ошибки аргументов и подстановок.
+type Rendered struct {
+	Query   string
+	Args    []any
+	Preview string
+	Errors  []error
+}
+
+// Bind готовит запрос шаблона t к выполнению в базе типа dbType с
+// аргументами args (см. Render). Ошибки объединяются в одну.
+func Bind(t Template, args map[string]string, dateFormats []string, dbType string) (string, []any, error) {
+	r := Render(t, args, dateFormats, dbType)
+	if len(r.Errors) > 0 {
+		return "", nil, stderrors.Join(r.Errors...)
+	}
+	return r.Query, r.Args, nil
+}
+
+// Render связывает шаблон t с аргументами args для базы типа dbType, не
+// выполняя запрос. Объявленные параметры заменяются параметрами SQL диалекта
+// базы; их значения проверяются и преобразуются к типу параметра. Если
+// параметры не объявлены, аргументы подставляются в текст как есть, за один
+// проход: подстановки в значениях аргументов не раскрываются.
+// Отсутствующий аргумент без значения по умолчанию - ошибка, если параметр
+// обязателен или не объявлен; необязательный передаётся как NULL.
+func Render(t Template, args map[string]string, dateFormats []string, dbType string) Rendered {
+	var r Rendered
+	if len(t.Params) == 0 {
+		missing := make(map[string]bool)
+		body := argRe.ReplaceAllStringFunc(t.Body, func(m string) string {
+			name := m[1 : len(m)-1]
+			if v, ok := args[name]; ok {
+				return v
+			}
+			if !missing[name] {
+				missing[name] = true
+				r.Errors = append(r.Errors, fmt.Errorf("%w: %s is missing", errors.ErrTemplateParam, name))
+			}
+			return m
+		})
+		r.Query, r.Preview = body, body
+		return r
+	}
+
+	params := make(map[string]Param, len(t.Params))
+	for _, p := range t.Params {
+		params[p.Name] = p
+	}
+	values := make(map[string]any, len(params))
+	for _, p := range t.Params {
+		s, ok := args[p.Name]
+		if !ok || s == "" {
+			s = p.Default
+		}
+		if s == "" {
+			if p.Required {
+				r.Errors = append(r.Errors, fmt.Errorf("%w: %s is required", errors.ErrTemplateParam, p.Name))
+			}
+			values[p.Name] = nil
+			continue
+		}
+		v, err := p.convert(s, dateFormats)
+		if err != nil {
+			r.Errors = append(r.Errors, err)
+		}
+		values[p.Name] = v
+	}
+	unknown := make([]string, 0)
+	for k := range args {
+		if _, ok := params[k]; !ok && k != ReservedParam {
+			unknown = append(unknown, k)
+		}
+	}
+	slices.Sort(unknown)
+	for _, k := range unknown {
+		r.Errors = append(r.Errors, fmt.Errorf("%w: unknown parameter %s", errors.ErrTemplateParam, k))
+	}
+
+	if err := embedded(t.Body); err != nil {
+		r.Errors = append(r.Errors, err)
+	}
+
+	bind := question
+	if d, ok := dialects[dbType]; ok {
+		bind = d.bind
+	}
+	var query, preview strings.Builder
+	last := 0
+	for _, loc := range placeholderRe.FindAllStringSubmatchIndex(t.Body, -1) {
+		// loc[2:4] - имя в кавычках, loc[4:6] - без кавычек
+		var name string
+		if loc[2] >= 0 {
+			name = t.Body[loc[2]:loc[3]]
+		} else {
+			name = t.Body[loc[4]:loc[5]]
+		}
+		query.WriteString(t.Body[last:loc[0]])
+		preview.WriteString(t.Body[last:loc[0]])
+		last = loc[1]
+		v, ok := values[name]
+		if !ok {
+			r.Errors = append(r.Errors, fmt.Errorf("%w: {%s} is not declared", errors.ErrTemplateParam, name))
+			query.WriteString(t.Body[loc[0]:loc[1]])
+			preview.WriteString(t.Body[loc[0]:loc[1]])
+			continue
+		}
+		r.Args = append(r.Args, v)
+		query.WriteString(bind(len(r.Args)))
+		preview.WriteString(literal(v))
+	}
+	query.WriteString(t.Body[last:])
+	preview.WriteString(t.Body[last:])
+	r.Query = query.String()
+	r.Preview = preview.String()
+	return r
+}
+
+// literal записывает значение параметра литералом SQL.
+func literal(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05") + "'"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return fmt.Sprint(v)
+}
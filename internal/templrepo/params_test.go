@@ -0,0 +1,91 @@
+package templrepo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	rerrors "robin2/internal/errors"
+)
+
+func TestBind(t *testing.T) {
+	tm := Template{Name: "report", Body: "select v from t where tag = '{tag}' and d >= {from} and kind in ({kind}) and v > {min} and note = {note}", Meta: Meta{Params: []Param{
+		{Name: "tag", Type: ParamTag, Required: true},
+		{Name: "from", Type: ParamDate, Required: true},
+		{Name: "kind", Type: ParamEnum, Values: []string{"a", "b"}, Default: "a"},
+		{Name: "min", Type: ParamNumber},
+		{Name: "note", Type: ParamString},
+	}}}
+	if err := validate(tm); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	formats := []string{"2006-01-02 15:04:05"}
+	q, args, err := Bind(tm, map[string]string{"tag": "T1", "from": "2024-03-01 00:00:00", "note": "a, b", "db": "x"}, formats, "oracle")
+	if err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+	if q != "select v from t where tag = :1 and d >= :2 and kind in (:3) and v > :4 and note = :5" {
+		t.Fatalf("unexpected query %q", q)
+	}
+	from, _ := args[1].(time.Time)
+	if len(args) != 5 || args[0] != "T1" || from.Day() != 1 || args[2] != "a" || args[3] != nil || args[4] != "a, b" {
+		t.Fatalf("unexpected args %#v", args)
+	}
+
+	r := Render(tm, map[string]string{"tag": "T1", "from": "2024-03-01 00:00:00", "min": "1,5", "note": "it's"}, formats, "mysql")
+	if r.Preview != "select v from t where tag = 'T1' and d >= '2024-03-01 00:00:00' and kind in ('a') and v > 1.5 and note = 'it''s'" {
+		t.Fatalf("unexpected preview %q", r.Preview)
+	}
+	if r = Render(tm, map[string]string{"min": "x", "typo": "1"}, formats, "mysql"); len(r.Errors) != 4 {
+		t.Fatalf("Render must report every error, got %v", r.Errors)
+	}
+
+	for _, bad := range []map[string]string{
+		{"from": "2024-03-01 00:00:00"},
+		{"tag": "T1", "from": "yesterday"},
+		{"tag": "T1", "from": "2024-03-01 00:00:00", "kind": "c"},
+		{"tag": "T1", "from": "2024-03-01 00:00:00", "min": "x"},
+		{"tag": "T1", "from": "2024-03-01 00:00:00", "typo": "1"},
+	} {
+		if _, _, err := Bind(tm, bad, formats, "mysql"); !errors.Is(err, rerrors.ErrTemplateParam) {
+			t.Fatalf("Bind(%v) must fail with ErrTemplateParam, got %v", bad, err)
+		}
+	}
+
+	if _, _, err := Bind(Template{Body: "select {a}, {b}"}, map[string]string{"a": "1"}, nil, ""); !errors.Is(err, rerrors.ErrTemplateParam) {
+		t.Fatalf("missing legacy argument must fail, got %v", err)
+	}
+	if q, _, err := Bind(Template{Body: "select {a}, {b}"}, map[string]string{"a": "{b}", "b": "1"}, nil, ""); err != nil || q != "select {b}, 1" {
+		t.Fatalf("legacy arguments must be substituted once, got %q (%v)", q, err)
+	}
+	if err := validate(Template{Name: "x", Body: "delete from t where tag = '{tag}'", Meta: Meta{Privileged: true}}); !errors.Is(err, rerrors.ErrTemplateParam) {
+		t.Fatalf("privileged template must declare its parameters, got %v", err)
+	}
+	if err := validate(Template{Name: "x", Body: "select {y}", Meta: Meta{Params: []Param{{Name: "z", Type: ParamString}}}}); !errors.Is(err, rerrors.ErrTemplateParam) {
+		t.Fatalf("undeclared placeholder must be rejected, got %v", err)
+	}
+}
+
+func TestEmbeddedPlaceholder(t *testing.T) {
+	params := []Param{{Name: "mask", Type: ParamString}}
+	bad := Template{Name: "x", Body: "select * from t where name like '{mask}%'", Meta: Meta{Params: params}}
+	if err := validate(bad); !errors.Is(err, rerrors.ErrTemplateParam) {
+		t.Fatalf("placeholder inside a literal must be rejected, got %v", err)
+	}
+	if _, _, err := Bind(bad, map[string]string{"mask": "a"}, nil, "mysql"); !errors.Is(err, rerrors.ErrTemplateParam) {
+		t.Fatalf("Bind must reject placeholder inside a literal, got %v", err)
+	}
+
+	good := Template{Name: "x", Body: "select 'a%' -- it's\nfrom t where name like CONCAT({mask}, '%') or name = '{mask}'", Meta: Meta{Params: params}}
+	if err := validate(good); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	q, _, err := Bind(good, map[string]string{"mask": "a"}, nil, "mysql")
+	if err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+	if q != "select 'a%' -- it's\nfrom t where name like CONCAT(?, '%') or name = ?" {
+		t.Fatalf("unexpected query %q", q)
+	}
+}
@@ -0,0 +1,124 @@
+// Package templrepo хранит шаблоны SQL-запросов (/templ/...) независимо от
+// текущей базы данных: в каталоге файлов или в таблице выбранной базы.
+package templrepo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"robin2/internal/config"
+	"robin2/internal/errors"
+)
+
+// Template - шаблон запроса: тело с подстановками {param} и метаданные.
+type Template struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+	Meta
+}
+
+// Meta - метаданные шаблона, хранящиеся рядом с телом. Version, Author и
+// Comment относятся к версии: каждое добавление и изменение шаблона
+// сохраняет неизменяемую версию с очередным номером. Params объявляют
+// параметры тела шаблона (см. Bind), Schedules - расписания его выполнения.
+// Тело обычного шаблона - один запрос только на чтение; Privileged снимает
+// это ограничение (см. store.CheckReadOnly).
+type Meta struct {
+	Description string     `json:"description,omitempty"`
+	Params      []Param    `json:"params,omitempty"`
+	Schedules   []Schedule `json:"schedules,omitempty"`
+	Privileged  bool       `json:"privileged,omitempty"`
+	Version     int        `json:"version"`
+	Author      string     `json:"author,omitempty"`
+	Comment     string     `json:"comment,omitempty"`
+	Updated     time.Time  `json:"updated"`
+}
+
+// Schedule - расписание выполнения шаблона в формате cron (минута, час, день
+// месяца, месяц, день недели) с аргументами args; значения аргументов могут
+// быть выражениями дат вроде today-1d (см. scheduler). Результат сохраняется
+// в формате format; db - база выполнения, пустая - текущая.
+type Schedule struct {
+	Cron   string            `json:"cron"`
+	Args   map[string]string `json:"args,omitempty"`
+	Format string            `json:"format,omitempty"`
+	DB     string            `json:"db,omitempty"`
+}
+
+// Repo - хранилище шаблонов.
+type Repo interface {
+	// List возвращает шаблоны, имена которых начинаются с like (% - любые
+	// символы, _ - один символ), по возрастанию имени.
+	List(like string) ([]Template, error)
+	Get(name string) (Template, error)
+	// Add добавляет новый шаблон; ErrTemplateExists, если он уже есть.
+	// Version и Updated назначает хранилище.
+	Add(t Template) error
+	// Set изменяет существующий шаблон новой версией; ErrTemplateNotFound,
+	// если его нет.
+	Set(t Template) error
+	// Del удаляет шаблон. Его версии сохраняются: шаблон можно восстановить
+	// (см. Rollback), а при повторном добавлении нумерация продолжается.
+	Del(name string) error
+	// Versions возвращает версии шаблона name по возрастанию номера.
+	Versions(name string) ([]Template, error)
+	// Version возвращает версию v шаблона name.
+	Version(name string, v int) (Template, error)
+	Close() error
+}
+
+const (
+	TypeFiles = "files"
+	TypeDB    = "db"
+)
+
+var registry map[string]func(config.Config) (Repo, error)
+
+func Register(name string, f func(config.Config) (Repo, error)) {
+	if registry == nil {
+		registry = make(map[string]func(config.Config) (Repo, error))
+	}
+	registry[name] = f
+}
+
+// New создаёт хранилище шаблонов типа templates.type (по умолчанию files).
+func New(cfg config.Config) (Repo, error) {
+	t := cfg.Templates.Type
+	if t == "" {
+		t = TypeFiles
+	}
+	f, ok := registry[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown template repository type %q", errors.ErrStoreError, t)
+	}
+	return f(cfg)
+}
+
+// nameRe - допустимые имена шаблонов: имя используется как имя файла.
+var nameRe = regexp.MustCompile(`^[\p{L}\p{N}_-][\p{L}\p{N}_.-]*$`)
+
+func validName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("%w: %q", errors.ErrInvalidTemplateName, name)
+	}
+	return nil
+}
+
+// likeRe преобразует маску like в регулярное выражение для префикса имени.
+func likeRe(like string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range like {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,138 @@
+// Package trace собирает шаги выполнения одного запроса (SQL-запросы,
+// обращения к кэшу, длительности) для режима отладки debug=1.
+package trace
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	LayerDB    = "db"
+	LayerCache = "cache"
+)
+
+// Step - один шаг выполнения запроса.
+type Step struct {
+	Layer    string  `json:"layer"`
+	Op       string  `json:"op"`
+	Target   string  `json:"target,omitempty"`
+	Query    string  `json:"query,omitempty"`
+	Key      string  `json:"key,omitempty"`
+	Hit      *bool   `json:"hit,omitempty"`
+	Rows     *int    `json:"rows,omitempty"`
+	At       float64 `json:"at_ms"`
+	Duration float64 `json:"duration_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Trace - трассировка одного запроса. Методы безопасны для nil-трассировки
+// и для вызова из нескольких горутин.
+type Trace struct {
+	mu    sync.Mutex
+	start time.Time
+	steps []Step
+}
+
+func New() *Trace {
+	return &Trace{start: time.Now()}
+}
+
+// Span - незавершённый шаг трассировки.
+type Span struct {
+	t     *Trace
+	step  Step
+	start time.Time
+}
+
+// Start начинает шаг op на уровне layer (db, cache) для базы или кэша target.
+func (t *Trace) Start(layer, op, target string) *Span {
+	if t == nil {
+		return nil
+	}
+	return &Span{t: t, step: Step{Layer: layer, Op: op, Target: target}, start: time.Now()}
+}
+
+func (sp *Span) Query(q string) *Span {
+	if sp != nil {
+		sp.step.Query = q
+	}
+	return sp
+}
+
+func (sp *Span) Key(k string) *Span {
+	if sp != nil {
+		sp.step.Key = k
+	}
+	return sp
+}
+
+func (sp *Span) Hit(hit bool) *Span {
+	if sp != nil {
+		sp.step.Hit = &hit
+	}
+	return sp
+}
+
+func (sp *Span) Rows(n int) *Span {
+	if sp != nil {
+		sp.step.Rows = &n
+	}
+	return sp
+}
+
+// End завершает шаг и добавляет его в трассировку.
+func (sp *Span) End(err error) {
+	if sp == nil {
+		return
+	}
+	sp.step.At = ms(sp.start.Sub(sp.t.start))
+	sp.step.Duration = ms(time.Since(sp.start))
+	if err != nil {
+		sp.step.Error = err.Error()
+	}
+	sp.t.mu.Lock()
+	sp.t.steps = append(sp.t.steps, sp.step)
+	sp.t.mu.Unlock()
+}
+
+// Report - итог трассировки для ответа в режиме отладки.
+type Report struct {
+	Database    string  `json:"database"`
+	Duration    float64 `json:"duration_ms"`
+	Queries     int     `json:"queries"`
+	Rows        int     `json:"rows"`
+	CacheHits   int     `json:"cache_hits"`
+	CacheMisses int     `json:"cache_misses"`
+	Steps       []Step  `json:"steps"`
+}
+
+// Report возвращает шаги трассировки и сводку по ним.
+func (t *Trace) Report(database string) Report {
+	r := Report{Database: database, Steps: []Step{}}
+	if t == nil {
+		return r
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r.Duration = ms(time.Since(t.start))
+	r.Steps = append(r.Steps, t.steps...)
+	for _, s := range t.steps {
+		switch {
+		case s.Layer == LayerDB:
+			r.Queries++
+			if s.Rows != nil {
+				r.Rows += *s.Rows
+			}
+		case s.Hit != nil && *s.Hit:
+			r.CacheHits++
+		case s.Hit != nil:
+			r.CacheMisses++
+		}
+	}
+	return r
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}